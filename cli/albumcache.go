@@ -0,0 +1,99 @@
+package cli
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"sync"
+	"time"
+
+	homedir "github.com/mitchellh/go-homedir"
+)
+
+// albumCache is a persistent cache of album IDs by title.
+// It implements photos.AlbumCache.
+//
+// The cache holds a snapshot of all albums.
+// A title not in the snapshot is not cached, because another client may have created the album.
+type albumCache struct {
+	name string
+	ttl  time.Duration
+	mu   sync.Mutex
+	data albumCacheData
+}
+
+type albumCacheData struct {
+	UpdatedAt time.Time           `json:"updated_at"`
+	Albums    map[string][]string `json:"albums"`
+	Stale     map[string]bool     `json:"stale,omitempty"`
+}
+
+// readAlbumCache reads the cache file.
+// If the file does not exist or is broken, it returns an empty cache.
+func readAlbumCache(name string, ttl time.Duration) (*albumCache, error) {
+	p, err := homedir.Expand(name)
+	if err != nil {
+		return nil, fmt.Errorf("Could not expand %s: %s", name, err)
+	}
+	c := &albumCache{name: p, ttl: ttl}
+	b, err := ioutil.ReadFile(p)
+	if os.IsNotExist(err) {
+		return c, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("Could not read %s: %s", name, err)
+	}
+	if err := json.Unmarshal(b, &c.data); err != nil {
+		logger.Warn("Ignored the broken album cache", "path", name, "error", err)
+		c.data = albumCacheData{}
+	}
+	return c, nil
+}
+
+func (c *albumCache) Get(title string) ([]string, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.data.Albums == nil || time.Since(c.data.UpdatedAt) > c.ttl {
+		return nil, false
+	}
+	if c.data.Stale[title] {
+		return nil, false
+	}
+	ids, ok := c.data.Albums[title]
+	if !ok || len(ids) == 0 {
+		return nil, false
+	}
+	return ids, true
+}
+
+func (c *albumCache) Replace(albums map[string][]string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.data = albumCacheData{UpdatedAt: time.Now(), Albums: albums}
+	c.write()
+}
+
+func (c *albumCache) Invalidate(title string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.data.Albums == nil {
+		return
+	}
+	if c.data.Stale == nil {
+		c.data.Stale = make(map[string]bool)
+	}
+	c.data.Stale[title] = true
+	c.write()
+}
+
+func (c *albumCache) write() {
+	b, err := json.Marshal(&c.data)
+	if err != nil {
+		logger.Warn("Could not encode the album cache", "error", err)
+		return
+	}
+	if err := writeFileAtomic(c.name, b, 0600); err != nil {
+		logger.Warn("Could not write the album cache", "path", c.name, "error", err)
+	}
+}
@@ -0,0 +1,165 @@
+package cli
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"time"
+
+	"github.com/int128/gpup/photos"
+	homedir "github.com/mitchellh/go-homedir"
+	photoslibrary "google.golang.org/api/photoslibrary/v1"
+)
+
+// AlbumsCommand represents the albums command.
+type AlbumsCommand struct {
+	Get AlbumsGetCommand `command:"get" description:"Show the albums of the IDs"`
+}
+
+// AlbumsGetCommand represents the albums get command.
+type AlbumsGetCommand struct {
+	Args struct {
+		IDs []string `positional-arg-name:"ID" required:"1"`
+	} `positional-args:"yes"`
+}
+
+func (c *CLI) getAlbums(ctx context.Context) error {
+	service, err := c.newPhotos(ctx)
+	if err != nil {
+		return err
+	}
+	for _, id := range c.Albums.Get.Args.IDs {
+		album, err := service.GetAlbum(ctx, id)
+		if err != nil {
+			return err
+		}
+		printAlbum(album)
+	}
+	return nil
+}
+
+func printAlbum(album *photoslibrary.Album) {
+	fmt.Printf("id: %s\n", album.Id)
+	fmt.Printf("title: %s\n", album.Title)
+	fmt.Printf("items: %d\n", album.TotalMediaItems)
+	fmt.Printf("writeable: %v\n", album.IsWriteable)
+	fmt.Printf("shared: %v\n", album.ShareInfo != nil)
+	if album.ShareInfo != nil {
+		fmt.Printf("share-token: %s\n", album.ShareInfo.ShareToken)
+		fmt.Printf("shareable-url: %s\n", album.ShareInfo.ShareableUrl)
+	}
+	fmt.Printf("url: %s\n", album.ProductUrl)
+	fmt.Println()
+}
+
+// newDuplicateAlbumPolicy returns the policy for --duplicate-album option.
+func (c *CLI) newDuplicateAlbumPolicy(created *createdAlbums) (photos.DuplicateAlbumPolicy, error) {
+	switch c.DuplicateAlbum {
+	case "", "fail":
+		return photos.FailOnDuplicateAlbums, nil
+	case "newest":
+		return photos.NewestAlbum(created.times()), nil
+	case "most-items":
+		return photos.MostItemsAlbum, nil
+	case "created-by-gpup":
+		return photos.CreatedAlbum(created.times()), nil
+	}
+	return nil, fmt.Errorf("Unknown --duplicate-album=%s: wants one of fail, newest, most-items or created-by-gpup", c.DuplicateAlbum)
+}
+
+// createdAlbums represents albums created by gpup.
+// Since the API does not provide the creation time of an album,
+// they are recorded to the file.
+type createdAlbums struct {
+	Albums []createdAlbum `json:"albums"`
+}
+
+type createdAlbum struct {
+	ID        string    `json:"id"`
+	Title     string    `json:"title"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// readCreatedAlbums reads the file.
+// If the file does not exist, it returns an empty set.
+func readCreatedAlbums(name string) (*createdAlbums, error) {
+	p, err := homedir.Expand(name)
+	if err != nil {
+		return nil, fmt.Errorf("Could not expand %s: %s", name, err)
+	}
+	var a createdAlbums
+	b, err := ioutil.ReadFile(p)
+	if os.IsNotExist(err) {
+		return &a, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("Could not read %s: %s", name, err)
+	}
+	if err := json.Unmarshal(b, &a); err != nil {
+		return nil, fmt.Errorf("Could not decode %s: %s", name, err)
+	}
+	return &a, nil
+}
+
+// Write writes the albums to the file.
+func (a *createdAlbums) Write(name string) error {
+	p, err := homedir.Expand(name)
+	if err != nil {
+		return fmt.Errorf("Could not expand %s: %s", name, err)
+	}
+	b, err := json.MarshalIndent(a, "", "  ")
+	if err != nil {
+		return fmt.Errorf("Could not encode albums: %s", err)
+	}
+	// write atomically, because a broken file fails every run
+	if err := writeFileAtomic(p, b, 0600); err != nil {
+		return fmt.Errorf("Could not write to %s: %s", name, err)
+	}
+	return nil
+}
+
+func (a *createdAlbums) times() map[string]time.Time {
+	m := make(map[string]time.Time)
+	for _, album := range a.Albums {
+		m[album.ID] = album.CreatedAt
+	}
+	return m
+}
+
+// albumRecorder records albums created by gpup to the file.
+// It implements photos.AlbumRecorder.
+//
+// The file is read on each call, because another process may have updated it.
+// Caller should hold the album lock.
+type albumRecorder struct {
+	name string
+}
+
+func (r *albumRecorder) Created(album *photoslibrary.Album) {
+	a, err := readCreatedAlbums(r.name)
+	if err != nil {
+		logger.Warn("Could not record the created album", "album", album.Title, "error", err)
+		return
+	}
+	a.Albums = append(a.Albums, createdAlbum{ID: album.Id, Title: album.Title, CreatedAt: time.Now()})
+	if err := a.Write(r.name); err != nil {
+		logger.Warn("Could not record the created album", "album", album.Title, "error", err)
+	}
+}
+
+func (r *albumRecorder) Find(title string) []string {
+	a, err := readCreatedAlbums(r.name)
+	if err != nil {
+		logger.Warn("Could not read the created albums", "path", r.name, "error", err)
+		return nil
+	}
+	var ids []string
+	for _, album := range a.Albums {
+		if album.Title == title {
+			ids = append(ids, album.ID)
+		}
+	}
+	return ids
+}
@@ -0,0 +1,127 @@
+package cli
+
+import (
+	"io/ioutil"
+	"os"
+	"reflect"
+	"testing"
+	"time"
+
+	photoslibrary "google.golang.org/api/photoslibrary/v1"
+)
+
+func TestNew_AlbumsGet(t *testing.T) {
+	c, err := New([]string{"gpup", "--gpupconfig", "/nonexistent", "albums", "get", "ID1", "ID2"}, "test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c.command != "albums get" {
+		t.Errorf("command wants albums get but %s", c.command)
+	}
+	if want := []string{"ID1", "ID2"}; !reflect.DeepEqual(want, c.Albums.Get.Args.IDs) {
+		t.Errorf("IDs wants %v but %v", want, c.Albums.Get.Args.IDs)
+	}
+}
+
+func TestNew_Upload(t *testing.T) {
+	c, err := New([]string{"gpup", "--gpupconfig", "/nonexistent", "-a", "Trip", "a.jpg", "b.jpg"}, "test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c.command != "" {
+		t.Errorf("command wants empty but %s", c.command)
+	}
+	if want := []string{"a.jpg", "b.jpg"}; !reflect.DeepEqual(want, c.Paths) {
+		t.Errorf("Paths wants %v but %v", want, c.Paths)
+	}
+}
+
+func TestCreatedAlbums(t *testing.T) {
+	f, err := ioutil.TempFile("", "gpupalbums")
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.Close()
+	os.Remove(f.Name())
+	defer os.Remove(f.Name())
+
+	a, err := readCreatedAlbums(f.Name())
+	if err != nil {
+		t.Fatalf("readCreatedAlbums returns error: %s", err)
+	}
+	if len(a.Albums) != 0 {
+		t.Errorf("len(Albums) wants 0 but %d", len(a.Albums))
+	}
+	r := &albumRecorder{f.Name()}
+	r.Created(&photoslibrary.Album{Id: "ID1", Title: "Trip"})
+	r.Created(&photoslibrary.Album{Id: "ID2", Title: "Lunch"})
+	if ids := r.Find("Trip"); !reflect.DeepEqual([]string{"ID1"}, ids) {
+		t.Errorf("Find wants [ID1] but %v", ids)
+	}
+
+	a, err = readCreatedAlbums(f.Name())
+	if err != nil {
+		t.Fatalf("readCreatedAlbums returns error: %s", err)
+	}
+	if len(a.Albums) != 2 || a.Albums[0].ID != "ID1" || a.Albums[0].Title != "Trip" {
+		t.Errorf("Albums wants [ID1 ID2] but %+v", a.Albums)
+	}
+	if _, ok := a.times()["ID1"]; !ok {
+		t.Errorf("times() wants ID1 but %+v", a.times())
+	}
+}
+
+func TestCLI_newDuplicateAlbumPolicy(t *testing.T) {
+	for _, policy := range []string{"", "fail", "newest", "most-items", "created-by-gpup"} {
+		c := CLI{DuplicateAlbum: policy}
+		if _, err := c.newDuplicateAlbumPolicy(&createdAlbums{}); err != nil {
+			t.Errorf("newDuplicateAlbumPolicy(%s) returns error: %s", policy, err)
+		}
+	}
+	c := CLI{DuplicateAlbum: "oldest"}
+	if _, err := c.newDuplicateAlbumPolicy(&createdAlbums{}); err == nil {
+		t.Errorf("newDuplicateAlbumPolicy wants error but nil")
+	}
+}
+
+func TestAlbumCache(t *testing.T) {
+	f, err := ioutil.TempFile("", "gpupalbumcache")
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.Close()
+	os.Remove(f.Name())
+	defer os.Remove(f.Name())
+
+	c, err := readAlbumCache(f.Name(), time.Hour)
+	if err != nil {
+		t.Fatalf("readAlbumCache returns error: %s", err)
+	}
+	if _, ok := c.Get("Trip"); ok {
+		t.Errorf("Get wants miss but hit")
+	}
+	c.Replace(map[string][]string{"Trip": {"ID1"}})
+
+	c, err = readAlbumCache(f.Name(), time.Hour)
+	if err != nil {
+		t.Fatalf("readAlbumCache returns error: %s", err)
+	}
+	if ids, ok := c.Get("Trip"); !ok || !reflect.DeepEqual([]string{"ID1"}, ids) {
+		t.Errorf("Get wants [ID1] but %v, %v", ids, ok)
+	}
+	if _, ok := c.Get("Nothing"); ok {
+		t.Errorf("Get wants miss for a title not in the cache but hit")
+	}
+	c.Invalidate("Trip")
+	if _, ok := c.Get("Trip"); ok {
+		t.Errorf("Get wants miss after invalidation but hit")
+	}
+
+	c, err = readAlbumCache(f.Name(), 0)
+	if err != nil {
+		t.Fatalf("readAlbumCache returns error: %s", err)
+	}
+	if _, ok := c.Get("Trip"); ok {
+		t.Errorf("Get wants miss after expiration but hit")
+	}
+}
@@ -0,0 +1,252 @@
+package cli
+
+import (
+	"bufio"
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/int128/oauth2cli"
+	"golang.org/x/oauth2"
+)
+
+const googleDeviceAuthURL = "https://oauth2.googleapis.com/device/code"
+
+// getToken performs the flow of --auth-flow option and returns a token.
+func (c *CLI) getToken(ctx context.Context, config oauth2.Config) (*oauth2.Token, error) {
+	cfg := c.ExternalConfig
+	config.RedirectURL = cfg.AuthRedirectURL
+	switch cfg.AuthFlow {
+	case "", "local-server":
+		flow := oauth2cli.AuthCodeFlow{Config: config, LocalServerPort: cfg.AuthPort}
+		return flow.GetToken(ctx)
+	case "copy-paste":
+		flow := copyPasteFlow{Config: config, LocalServerPort: cfg.AuthPort, In: os.Stdin, Out: os.Stderr}
+		return flow.GetToken(ctx)
+	case "device":
+		hc, _ := ctx.Value(oauth2.HTTPClient).(*http.Client)
+		flow := deviceFlow{Config: config, DeviceAuthURL: googleDeviceAuthURL, Client: hc, Out: os.Stderr}
+		return flow.GetToken(ctx)
+	}
+	return nil, fmt.Errorf("Unknown auth-flow=%s: wants one of local-server, copy-paste or device", cfg.AuthFlow)
+}
+
+// copyPasteFlow performs the authorization code grant without a local server.
+// It shows the URL and reads the code or the redirected URL pasted by the user.
+// By default the browser is redirected to the loopback address,
+// which does not load but the user can copy the URL from the address bar.
+type copyPasteFlow struct {
+	Config          oauth2.Config
+	LocalServerPort int // Port of the redirect URL. Default to a free port.
+	In              io.Reader
+	Out             io.Writer
+}
+
+func (f *copyPasteFlow) GetToken(ctx context.Context) (*oauth2.Token, error) {
+	if f.Config.RedirectURL == "" {
+		redirectURL, err := loopbackRedirectURL(f.LocalServerPort)
+		if err != nil {
+			return nil, fmt.Errorf("Could not determine the redirect URL: %s", err)
+		}
+		f.Config.RedirectURL = redirectURL
+	}
+	state, err := newState()
+	if err != nil {
+		return nil, fmt.Errorf("Could not generate state parameter: %s", err)
+	}
+	fmt.Fprintf(f.Out, "Open the following URL in a browser and authorize the access:\n\n%s\n\n", f.Config.AuthCodeURL(state))
+	fmt.Fprintf(f.Out, "The redirected page will not load. Copy the URL in the address bar and enter it here: ")
+	line, err := bufio.NewReader(f.In).ReadString('\n')
+	if err != nil && line == "" {
+		return nil, fmt.Errorf("Could not read the code: %s", err)
+	}
+	code, err := parsePastedCode(strings.TrimSpace(line), state)
+	if err != nil {
+		return nil, err
+	}
+	token, err := f.Config.Exchange(ctx, code)
+	if err != nil {
+		return nil, fmt.Errorf("Could not exchange token: %s", err)
+	}
+	return token, nil
+}
+
+// parsePastedCode returns the code in the input, which is a code or a redirected URL.
+func parsePastedCode(input, state string) (string, error) {
+	if input == "" {
+		return "", fmt.Errorf("Code must not be empty")
+	}
+	if !strings.Contains(input, "://") {
+		return input, nil
+	}
+	u, err := url.Parse(input)
+	if err != nil {
+		return "", fmt.Errorf("Invalid URL: %s", err)
+	}
+	q := u.Query()
+	if e := q.Get("error"); e != "" {
+		return "", fmt.Errorf("OAuth Error: %s %s", e, q.Get("error_description"))
+	}
+	if q.Get("state") != state {
+		return "", fmt.Errorf("State does not match, wants %s but %s", state, q.Get("state"))
+	}
+	if q.Get("code") == "" {
+		return "", fmt.Errorf("URL does not contain a code")
+	}
+	return q.Get("code"), nil
+}
+
+// loopbackRedirectURL returns the redirect URL to the loopback address.
+// If the port is 0, it finds a free port so that no other server receives the code.
+func loopbackRedirectURL(port int) (string, error) {
+	if port == 0 {
+		l, err := net.Listen("tcp", "127.0.0.1:0")
+		if err != nil {
+			return "", err
+		}
+		port = l.Addr().(*net.TCPAddr).Port
+		if err := l.Close(); err != nil {
+			return "", err
+		}
+	}
+	return fmt.Sprintf("http://127.0.0.1:%d/", port), nil
+}
+
+func newState() (string, error) {
+	b := make([]byte, 16)
+	if _, err := io.ReadFull(rand.Reader, b); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+// deviceFlow performs the device authorization grant.
+// See https://tools.ietf.org/html/rfc8628
+type deviceFlow struct {
+	Config        oauth2.Config
+	DeviceAuthURL string
+	Client        *http.Client // default to http.DefaultClient
+	Out           io.Writer
+}
+
+type deviceAuthResponse struct {
+	DeviceCode       string `json:"device_code"`
+	UserCode         string `json:"user_code"`
+	VerificationURI  string `json:"verification_uri"`
+	VerificationURL  string `json:"verification_url"` // Google uses this instead
+	ExpiresIn        int64  `json:"expires_in"`
+	Interval         int64  `json:"interval"`
+	Error            string `json:"error"`
+	ErrorDescription string `json:"error_description"`
+}
+
+type deviceTokenResponse struct {
+	AccessToken      string `json:"access_token"`
+	TokenType        string `json:"token_type"`
+	RefreshToken     string `json:"refresh_token"`
+	ExpiresIn        int64  `json:"expires_in"`
+	Error            string `json:"error"`
+	ErrorDescription string `json:"error_description"`
+}
+
+func (f *deviceFlow) GetToken(ctx context.Context) (*oauth2.Token, error) {
+	var auth deviceAuthResponse
+	if err := f.post(ctx, f.DeviceAuthURL, url.Values{
+		"client_id": {f.Config.ClientID},
+		"scope":     {strings.Join(f.Config.Scopes, " ")},
+	}, &auth); err != nil {
+		return nil, fmt.Errorf("Could not start device authorization: %s", err)
+	}
+	if auth.Error == "invalid_scope" {
+		return nil, fmt.Errorf("Could not start device authorization: the scope is not allowed in the device flow (Google does not allow Photos Library API), use auth-flow=copy-paste instead: %s", auth.ErrorDescription)
+	}
+	if auth.Error != "" {
+		return nil, fmt.Errorf("Could not start device authorization: OAuth Error: %s %s", auth.Error, auth.ErrorDescription)
+	}
+	if auth.DeviceCode == "" {
+		return nil, fmt.Errorf("Could not start device authorization: no device code in the response")
+	}
+	verificationURL := auth.VerificationURI
+	if verificationURL == "" {
+		verificationURL = auth.VerificationURL
+	}
+	fmt.Fprintf(f.Out, "Open %s on any device and enter the code %s\n", verificationURL, auth.UserCode)
+
+	interval := time.Duration(auth.Interval) * time.Second
+	if interval <= 0 {
+		interval = 5 * time.Second
+	}
+	deadline := time.Now().Add(time.Duration(auth.ExpiresIn) * time.Second)
+	for {
+		select {
+		case <-time.After(interval):
+		case <-ctx.Done():
+			return nil, fmt.Errorf("Context done while waiting for authorization: %s", ctx.Err())
+		}
+		var res deviceTokenResponse
+		if err := f.post(ctx, f.Config.Endpoint.TokenURL, url.Values{
+			"client_id":     {f.Config.ClientID},
+			"client_secret": {f.Config.ClientSecret},
+			"device_code":   {auth.DeviceCode},
+			"grant_type":    {"urn:ietf:params:oauth:grant-type:device_code"},
+		}, &res); err != nil {
+			return nil, fmt.Errorf("Could not get a token: %s", err)
+		}
+		switch res.Error {
+		case "":
+			token := &oauth2.Token{
+				AccessToken:  res.AccessToken,
+				TokenType:    res.TokenType,
+				RefreshToken: res.RefreshToken,
+			}
+			if res.ExpiresIn > 0 {
+				token.Expiry = time.Now().Add(time.Duration(res.ExpiresIn) * time.Second)
+			}
+			return token, nil
+		case "authorization_pending":
+		case "slow_down":
+			interval += 5 * time.Second
+		default:
+			return nil, fmt.Errorf("OAuth Error: %s %s", res.Error, res.ErrorDescription)
+		}
+		if auth.ExpiresIn > 0 && time.Now().After(deadline) {
+			return nil, fmt.Errorf("The code %s has been expired", auth.UserCode)
+		}
+		logger.Info("Waiting for authorization", "url", verificationURL)
+	}
+}
+
+// post sends the form and decodes the JSON response.
+// An OAuth error response is decoded as well as a successful response.
+func (f *deviceFlow) post(ctx context.Context, endpoint string, form url.Values, v interface{}) error {
+	client := f.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+	req, err := http.NewRequest("POST", endpoint, strings.NewReader(form.Encode()))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	resp, err := client.Do(req.WithContext(ctx))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 500 {
+		return fmt.Errorf("%s %s returned %s", req.Method, endpoint, resp.Status)
+	}
+	if err := json.NewDecoder(resp.Body).Decode(v); err != nil {
+		return fmt.Errorf("Invalid response of %s (%s): %s", endpoint, resp.Status, err)
+	}
+	return nil
+}
@@ -0,0 +1,185 @@
+package cli
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"regexp"
+	"strings"
+	"testing"
+
+	"golang.org/x/oauth2"
+)
+
+func TestParsePastedCode(t *testing.T) {
+	for _, c := range []struct {
+		input string
+		code  string
+	}{
+		{"4/CODE", "4/CODE"},
+		{"http://localhost:8000/?state=STATE&code=4/CODE", "4/CODE"},
+		{"http://localhost/?code=4%2FCODE&state=STATE&scope=photos", "4/CODE"},
+	} {
+		code, err := parsePastedCode(c.input, "STATE")
+		if err != nil {
+			t.Errorf("parsePastedCode(%s) returned error: %s", c.input, err)
+		}
+		if code != c.code {
+			t.Errorf("parsePastedCode(%s) wants %s but %s", c.input, c.code, code)
+		}
+	}
+	for _, input := range []string{
+		"",
+		"http://localhost/?code=CODE&state=OTHER",
+		"http://localhost/?state=STATE",
+		"http://localhost/?error=access_denied&state=STATE",
+	} {
+		if _, err := parsePastedCode(input, "STATE"); err == nil {
+			t.Errorf("parsePastedCode(%s) wants error", input)
+		}
+	}
+}
+
+func newTokenServer(t *testing.T, handler func(form url.Values) string) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if err := r.ParseForm(); err != nil {
+			t.Errorf("ParseForm returned error: %s", err)
+		}
+		w.Header().Set("Content-Type", "application/json")
+		body := handler(r.PostForm)
+		if strings.Contains(body, `"error"`) {
+			w.WriteHeader(http.StatusBadRequest)
+		}
+		fmt.Fprint(w, body)
+	}))
+}
+
+func TestCopyPasteFlow(t *testing.T) {
+	s := newTokenServer(t, func(form url.Values) string {
+		if form.Get("code") != "4/CODE" {
+			t.Errorf("code wants 4/CODE but %s", form.Get("code"))
+		}
+		if form.Get("redirect_uri") != "http://127.0.0.1:8000/" {
+			t.Errorf("redirect_uri wants http://127.0.0.1:8000/ but %s", form.Get("redirect_uri"))
+		}
+		return `{"access_token":"ACCESS","token_type":"Bearer","refresh_token":"REFRESH","expires_in":3600}`
+	})
+	defer s.Close()
+	var out bytes.Buffer
+	flow := copyPasteFlow{
+		Config: oauth2.Config{
+			ClientID: "CLIENT_ID",
+			Endpoint: oauth2.Endpoint{AuthURL: "https://example.com/auth", TokenURL: s.URL},
+		},
+		LocalServerPort: 8000,
+		In:              strings.NewReader("4/CODE\n"),
+		Out:             &out,
+	}
+	token, err := flow.GetToken(context.Background())
+	if err != nil {
+		t.Fatalf("GetToken returned error: %s", err)
+	}
+	if token.AccessToken != "ACCESS" || token.RefreshToken != "REFRESH" {
+		t.Errorf("token wants ACCESS and REFRESH but %+v", token)
+	}
+	if !regexp.MustCompile(`https://example\.com/auth\?\S*client_id=CLIENT_ID`).MatchString(out.String()) {
+		t.Errorf("output wants the auth URL but %s", out.String())
+	}
+}
+
+func TestDeviceFlow(t *testing.T) {
+	var polls int
+	s := newTokenServer(t, func(form url.Values) string {
+		if form.Get("grant_type") == "" {
+			if form.Get("scope") != "SCOPE1 SCOPE2" {
+				t.Errorf("scope wants SCOPE1 SCOPE2 but %s", form.Get("scope"))
+			}
+			return `{"device_code":"DEVICE","user_code":"USER-CODE","verification_url":"https://example.com/device","expires_in":60,"interval":1}`
+		}
+		if form.Get("device_code") != "DEVICE" {
+			t.Errorf("device_code wants DEVICE but %s", form.Get("device_code"))
+		}
+		polls++
+		if polls == 1 {
+			return `{"error":"authorization_pending"}`
+		}
+		return `{"access_token":"ACCESS","token_type":"Bearer","refresh_token":"REFRESH","expires_in":3600}`
+	})
+	defer s.Close()
+	var out bytes.Buffer
+	flow := deviceFlow{
+		Config: oauth2.Config{
+			ClientID: "CLIENT_ID",
+			Endpoint: oauth2.Endpoint{TokenURL: s.URL},
+			Scopes:   []string{"SCOPE1", "SCOPE2"},
+		},
+		DeviceAuthURL: s.URL,
+		Out:           &out,
+	}
+	token, err := flow.GetToken(context.Background())
+	if err != nil {
+		t.Fatalf("GetToken returned error: %s", err)
+	}
+	if token.AccessToken != "ACCESS" || token.RefreshToken != "REFRESH" || token.Expiry.IsZero() {
+		t.Errorf("token wants ACCESS, REFRESH and expiry but %+v", token)
+	}
+	if polls != 2 {
+		t.Errorf("polls wants 2 but %d", polls)
+	}
+	if !strings.Contains(out.String(), "https://example.com/device") || !strings.Contains(out.String(), "USER-CODE") {
+		t.Errorf("output wants the URL and code but %s", out.String())
+	}
+}
+
+func TestDeviceFlow_AccessDenied(t *testing.T) {
+	s := newTokenServer(t, func(form url.Values) string {
+		if form.Get("grant_type") == "" {
+			return `{"device_code":"DEVICE","user_code":"USER-CODE","verification_uri":"https://example.com/device","expires_in":60,"interval":1}`
+		}
+		return `{"error":"access_denied"}`
+	})
+	defer s.Close()
+	flow := deviceFlow{
+		Config:        oauth2.Config{ClientID: "CLIENT_ID", Endpoint: oauth2.Endpoint{TokenURL: s.URL}},
+		DeviceAuthURL: s.URL,
+		Out:           &bytes.Buffer{},
+	}
+	if _, err := flow.GetToken(context.Background()); err == nil || !strings.Contains(err.Error(), "access_denied") {
+		t.Errorf("GetToken wants access_denied error but %v", err)
+	}
+}
+
+func TestDeviceFlow_InvalidScope(t *testing.T) {
+	s := newTokenServer(t, func(form url.Values) string {
+		return `{"error":"invalid_scope","error_description":"Invalid device flow scope"}`
+	})
+	defer s.Close()
+	flow := deviceFlow{
+		Config:        oauth2.Config{ClientID: "CLIENT_ID", Endpoint: oauth2.Endpoint{TokenURL: s.URL}},
+		DeviceAuthURL: s.URL,
+		Out:           &bytes.Buffer{},
+	}
+	if _, err := flow.GetToken(context.Background()); err == nil || !strings.Contains(err.Error(), "auth-flow=copy-paste") {
+		t.Errorf("GetToken wants error to use copy-paste but %v", err)
+	}
+}
+
+func TestLoopbackRedirectURL(t *testing.T) {
+	u, err := loopbackRedirectURL(8000)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if u != "http://127.0.0.1:8000/" {
+		t.Errorf("URL wants http://127.0.0.1:8000/ but %s", u)
+	}
+	u, err = loopbackRedirectURL(0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !regexp.MustCompile(`^http://127\.0\.0\.1:[1-9][0-9]*/$`).MatchString(u) {
+		t.Errorf("URL wants a free port but %s", u)
+	}
+}
@@ -3,8 +3,12 @@ package cli
 import (
 	"context"
 	"fmt"
-	"log"
+	"io"
+	"os"
+	"strings"
+	"time"
 
+	"github.com/int128/gpup/logging"
 	flags "github.com/jessevdk/go-flags"
 )
 
@@ -12,57 +16,173 @@ import (
 type CLI struct {
 	AlbumTitle       string   `short:"a" long:"album" value-name:"TITLE" description:"Add files to the album or a new album if it does not exist"`
 	NewAlbum         string   `short:"n" long:"new-album" value-name:"TITLE" description:"Add files to a new album"`
+	AlbumID          string   `long:"album-id" value-name:"ID" description:"Add files to the album of the ID"`
+	ShareToken       string   `long:"share-token" value-name:"TOKEN" description:"Join the shared album of the token and add files to it"`
+	DuplicateAlbum   string   `long:"duplicate-album" value-name:"POLICY" default:"fail" description:"Policy if multiple albums have the title (fail, newest, most-items or created-by-gpup)"`
+	Sort             string   `long:"sort" value-name:"KEY" default:"none" description:"Sort items before uploading (name, mtime, exif-date or none)"`
+	Position         string   `long:"position" value-name:"POSITION" default:"last" description:"Position to add items in the album (first, last, after-item:ID or after-enrichment:ID)"`
 	RequestHeaders   []string `long:"request-header" value-name:"KEY:VALUE" description:"Add the header on fetching URLs"`
 	RequestBasicAuth string   `long:"request-auth" value-name:"USER:PASS" description:"Add the basic auth header on fetching URLs"`
+	Crawl            bool     `long:"crawl" description:"Find media links in HTML pages of URLs, such as directory listings"`
+	CrawlDepth       int      `long:"crawl-depth" value-name:"N" description:"Follow links to subpages up to the depth"`
+	CrawlAnyHost     bool     `long:"crawl-any-host" description:"Allow media links to other hosts"`
+	CrawlPatterns    []string `long:"crawl-pattern" value-name:"GLOB" description:"Filename pattern of media links (default: common photo and movie extensions)"`
+	FromFiles        []string `long:"from-file" value-name:"FILE" description:"Read paths or URLs separated by newline or NUL from the file (- for stdin)"`
+	Manifests        []string `long:"manifest" value-name:"FILE" description:"Read items from the JSONL manifest (- for stdin)"`
+	Concurrency      int      `long:"concurrency" value-name:"N" default:"4" description:"Number of concurrent uploads"`
+	MetricsListen    string   `long:"metrics-listen" value-name:"HOST:PORT" description:"Expose Prometheus metrics on the address, e.g. :9090"`
+	DryRun           string   `long:"dry-run" value-name:"FORMAT" optional:"yes" optional-value:"text" description:"Show the plan without uploading (text or json)"`
 
-	ConfigName string `long:"gpupconfig" env:"GPUPCONFIG" default:"~/.gpupconfig" description:"Path to the config file"`
-	Debug      bool   `long:"debug" env:"DEBUG" description:"Enable request and response logging"`
+	Profile          string        `long:"profile" env:"GPUP_PROFILE" value-name:"NAME" description:"Use the profile in gpupconfig (default: default-profile in gpupconfig)"`
+	ConfigName       string        `long:"gpupconfig" env:"GPUPCONFIG" description:"Path to the config file (default: $XDG_CONFIG_HOME/gpup/config.yaml or ~/.gpupconfig)"`
+	SystemConfigName string        `long:"system-config" env:"GPUP_SYSTEM_CONFIG" description:"Path to the config file shared by all users (default: /etc/gpup/config.yaml)"`
+	AlbumsName       string        `long:"gpupalbums" env:"GPUPALBUMS" default:"~/.gpupalbums" description:"Path to the record of albums created by gpup"`
+	AlbumCache       string        `long:"album-cache" env:"GPUPALBUMCACHE" default:"~/.gpupalbumcache" description:"Path to the cache of album titles"`
+	AlbumCacheTTL    time.Duration `long:"album-cache-ttl" value-name:"DURATION" default:"1h" description:"Time to live of the album cache (0 to disable)"`
+	Debug            bool          `long:"debug" env:"DEBUG" description:"Enable request and response logging (implies --verbose)"`
+	DebugBody        bool          `long:"debug-body" description:"Include JSON bodies in the request and response logging"`
+	TraceHAR         string        `long:"trace-har" value-name:"FILE" description:"Record API calls to the HAR file with secrets redacted"`
+	LogFormat        string        `long:"log-format" env:"GPUP_LOG_FORMAT" value-name:"FORMAT" default:"text" description:"Format of logs (text or json)"`
+	Quiet            bool          `short:"q" long:"quiet" description:"Show only warnings and errors"`
+	Verbose          bool          `short:"v" long:"verbose" description:"Show debug logs"`
 
 	ExternalConfig ExternalConfig `group:"Options read from gpupconfig"`
 
-	Paths []string
+	Albums AlbumsCommand `command:"albums" description:"Manage albums"`
+	Auth   AuthCommand   `command:"auth" description:"Manage profiles"`
+	Config ConfigCommand `command:"config" description:"Manage gpupconfig"`
+	Watch  WatchCommand  `command:"watch" description:"Upload new files in the directories as they appear"`
+	Serve  ServeCommand  `command:"serve" description:"Run the API server to accept upload jobs"`
+
+	Paths   []string
+	command string            // name of the active subcommand, e.g. "albums get"
+	profile string            // name of the profile in use
+	origins map[string]string // where each item of gpupconfig came from
+	har     *harRecorder      // set if --trace-har is given
 }
 
+// logger is used in this package.
+// It is set by New.
+var logger = logging.Default
+
 // New creates a new CLI object.
 //
 // This does the followings:
-// - Determine path to the config
-// - Read the config
 // - Parse the arguments
-// - Validate
-//
-// If the config is invalid, it will be ignored.
+// - Read the system, user and project config
+// - Merge the config into options which are not given by the arguments or environment variables
 func New(osArgs []string, version string) (*CLI, error) {
 	var c CLI
 	parser := flags.NewParser(&c, flags.HelpFlag)
-	parser.Usage = "[OPTIONS] <FILE | DIRECTORY | URL>..."
+	parser.SubcommandsOptional = true
+	parser.Usage = "[OPTIONS] <FILE | DIRECTORY | URL | s3://BUCKET/PREFIX | webdav://HOST/PATH | ->..."
 	parser.LongDescription = fmt.Sprintf("Version %s", version)
-	if _, err := parser.ParseArgs(osArgs[1:]); err != nil {
-		return nil, err
-	}
-	if err := c.ExternalConfig.Read(c.ConfigName); err != nil {
-		log.Printf("Skip reading %s: %s", c.ConfigName, err)
-	}
 	var err error
 	c.Paths, err = parser.ParseArgs(osArgs[1:])
 	if err != nil {
 		return nil, err
 	}
+	if err := c.setupLogger(os.Stderr); err != nil {
+		return nil, err
+	}
+	if c.TraceHAR != "" {
+		c.har = &harRecorder{version: version}
+	}
+	if err := c.loadConfig(parser); err != nil {
+		return nil, err
+	}
+	c.applySettings(parser)
+	if c.profile != "" {
+		c.applyProfile(parser, c.profile)
+	}
+	for cmd := parser.Active; cmd != nil; cmd = cmd.Active {
+		c.command = strings.TrimSpace(c.command + " " + cmd.Name)
+	}
 	return &c, nil
 }
 
+// setupLogger sets the logger by --log-format, --quiet and --verbose.
+func (c *CLI) setupLogger(w io.Writer) error {
+	if c.Quiet && (c.Verbose || c.Debug) {
+		return fmt.Errorf("--quiet and --verbose are exclusive")
+	}
+	level := logging.LevelInfo
+	switch {
+	case c.Quiet:
+		level = logging.LevelWarn
+	case c.Verbose || c.Debug:
+		level = logging.LevelDebug
+	}
+	l, err := logging.New(w, c.LogFormat, level)
+	if err != nil {
+		return fmt.Errorf("Invalid --log-format: %s", err)
+	}
+	logger = l
+	return nil
+}
+
+// Logger returns the logger configured by the options.
+func (c *CLI) Logger() logging.Logger {
+	return logger
+}
+
 // Run runs the command.
+// If --trace-har is given, it writes the API calls to the file during the run.
 func (c *CLI) Run(ctx context.Context) error {
+	if c.har != nil {
+		if err := c.har.Open(c.TraceHAR); err != nil {
+			return err
+		}
+		defer func() {
+			if err := c.har.Close(); err != nil {
+				logger.Error("Could not write the trace", "error", err)
+				return
+			}
+			logger.Info("Wrote the trace", "path", c.TraceHAR)
+		}()
+	}
+	return c.run(ctx)
+}
+
+func (c *CLI) run(ctx context.Context) error {
+	switch c.command {
+	case "auth list":
+		return c.listProfiles()
+	case "auth":
+		return fmt.Errorf("Specify a subcommand of auth")
+	case "config get":
+		return c.getConfig()
+	case "config set":
+		return c.setConfig()
+	case "config unset":
+		return c.unsetConfig()
+	case "config path":
+		return c.showConfigPath()
+	case "config show":
+		return c.showConfig()
+	case "config":
+		return fmt.Errorf("Specify a subcommand of config")
+	}
 	if c.ExternalConfig.ClientID == "" || c.ExternalConfig.ClientSecret == "" {
 		if err := c.initialSetup(ctx); err != nil {
 			return err
 		}
 	}
+	switch c.command {
+	case "albums get":
+		return c.getAlbums(ctx)
+	case "albums":
+		return fmt.Errorf("Specify a subcommand of albums")
+	case "watch":
+		return c.watch(ctx)
+	case "serve":
+		return c.serve(ctx)
+	}
 	return c.upload(ctx)
 }
 
 func (c *CLI) initialSetup(ctx context.Context) error {
-	log.Printf(`Setup your API access by the following steps:
+	fmt.Fprintf(os.Stderr, `Setup your API access by the following steps:
 
 1. Open https://console.cloud.google.com/apis/library/photoslibrary.googleapis.com/
 1. Enable Photos Library API.
@@ -80,9 +200,12 @@ func (c *CLI) initialSetup(ctx context.Context) error {
 	if c.ExternalConfig.ClientSecret == "" {
 		return fmt.Errorf("OAuth client ID must not be empty")
 	}
-	if err := c.ExternalConfig.Write(c.ConfigName); err != nil {
+	if err := c.updateConfig(func(cfg *ExternalConfig) {
+		cfg.ClientID = c.ExternalConfig.ClientID
+		cfg.ClientSecret = c.ExternalConfig.ClientSecret
+	}); err != nil {
 		return fmt.Errorf("Could not save credentials to %s: %s", c.ConfigName, err)
 	}
-	log.Printf("Saved credentials to %s", c.ConfigName)
+	logger.Info("Saved credentials", "path", c.ConfigName)
 	return nil
 }
@@ -0,0 +1,51 @@
+package cli
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/int128/gpup/logging"
+)
+
+func TestCLI_setupLogger(t *testing.T) {
+	defer func(restore logging.Logger) { logger = restore }(logger)
+	for _, c := range []struct {
+		cli   CLI
+		lines []string
+		err   bool
+	}{
+		{CLI{LogFormat: "text"}, []string{"INFO", "WARN"}, false},
+		{CLI{LogFormat: "text", Quiet: true}, []string{"WARN"}, false},
+		{CLI{LogFormat: "text", Verbose: true}, []string{"DEBUG", "INFO", "WARN"}, false},
+		{CLI{LogFormat: "json", Debug: true}, []string{`"level":"DEBUG"`, `"level":"INFO"`, `"level":"WARN"`}, false},
+		{CLI{LogFormat: "text", Quiet: true, Verbose: true}, nil, true},
+		{CLI{LogFormat: "xml"}, nil, true},
+	} {
+		var b bytes.Buffer
+		err := c.cli.setupLogger(&b)
+		if c.err {
+			if err == nil {
+				t.Errorf("setupLogger(%+v) wants error but nil", c.cli)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("setupLogger(%+v) returns error: %s", c.cli, err)
+			continue
+		}
+		logger.Debug("debug")
+		logger.Info("info")
+		logger.Warn("warn")
+		lines := strings.Split(strings.TrimSpace(b.String()), "\n")
+		if len(lines) != len(c.lines) {
+			t.Errorf("setupLogger(%+v) wants %d lines but %q", c.cli, len(c.lines), lines)
+			continue
+		}
+		for i, want := range c.lines {
+			if !strings.Contains(lines[i], want) {
+				t.Errorf("lines[%d] wants %s but %s", i, want, lines[i])
+			}
+		}
+	}
+}
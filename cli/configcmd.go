@@ -0,0 +1,154 @@
+package cli
+
+import (
+	"fmt"
+	"net/url"
+	"os"
+	"reflect"
+	"text/tabwriter"
+
+	yaml "gopkg.in/yaml.v3"
+)
+
+// ConfigCommand represents the config command.
+type ConfigCommand struct {
+	Get struct {
+		Args struct {
+			Key string `positional-arg-name:"KEY" required:"yes"`
+		} `positional-args:"yes"`
+	} `command:"get" description:"Show the value of the key, e.g. client-id or profiles.work.album"`
+	Set struct {
+		Args struct {
+			Key   string `positional-arg-name:"KEY" required:"yes"`
+			Value string `positional-arg-name:"VALUE" required:"yes"`
+		} `positional-args:"yes"`
+	} `command:"set" description:"Set the value of the key"`
+	Unset struct {
+		Args struct {
+			Key string `positional-arg-name:"KEY" required:"yes"`
+		} `positional-args:"yes"`
+	} `command:"unset" description:"Remove the key"`
+	Path struct{} `command:"path" description:"Show the path to gpupconfig"`
+	Show struct {
+		Origin bool `long:"origin" description:"Show where each value came from"`
+	} `command:"show" description:"Show the settings merged from the config files, environment variables and options"`
+}
+
+func (c *CLI) getConfig() error {
+	key := c.Config.Get.Args.Key
+	f, err := openConfigFile(c.ConfigName)
+	if err != nil {
+		return err
+	}
+	n := f.get(key)
+	if n == nil {
+		return fmt.Errorf("No such key %s in %s", key, c.ConfigName)
+	}
+	if n.Kind == yaml.ScalarNode {
+		fmt.Println(n.Value)
+		return nil
+	}
+	b, err := yaml.Marshal(n)
+	if err != nil {
+		return fmt.Errorf("Could not write to YAML: %s", err)
+	}
+	fmt.Print(string(b))
+	return nil
+}
+
+func (c *CLI) setConfig() error {
+	f, err := openConfigFile(c.ConfigName)
+	if err != nil {
+		return err
+	}
+	if err := f.set(c.Config.Set.Args.Key, c.Config.Set.Args.Value); err != nil {
+		return err
+	}
+	return f.write()
+}
+
+func (c *CLI) unsetConfig() error {
+	key := c.Config.Unset.Args.Key
+	f, err := openConfigFile(c.ConfigName)
+	if err != nil {
+		return err
+	}
+	if !f.unset(key) {
+		return fmt.Errorf("No such key %s in %s", key, c.ConfigName)
+	}
+	return f.write()
+}
+
+func (c *CLI) showConfigPath() error {
+	f, err := openConfigFile(c.ConfigName)
+	if err != nil {
+		return err
+	}
+	fmt.Println(f.path)
+	return nil
+}
+
+// secretConfigKeys is the set of keys hidden in config show.
+var secretConfigKeys = map[string]bool{
+	"client-secret":    true,
+	"token":            true,
+	"client-key":       true,
+	"http-credentials": true,
+	"s3":               true,
+}
+
+// showConfig shows the effective settings.
+func (c *CLI) showConfig() error {
+	// options override the settings in gpupconfig
+	settings := map[string]interface{}{
+		"album":           c.AlbumTitle,
+		"duplicate-album": c.DuplicateAlbum,
+		"sort":            c.Sort,
+		"position":        c.Position,
+		"concurrency":     c.Concurrency,
+		"default-profile": c.profile,
+	}
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	v := reflect.ValueOf(&c.ExternalConfig).Elem()
+	for i := 0; i < v.NumField(); i++ {
+		key := yamlKeyOf(v.Type().Field(i))
+		value := v.Field(i).Interface()
+		if s, ok := settings[key]; ok {
+			value = s
+		}
+		if key == "profiles" {
+			continue
+		}
+		value = displayConfigValue(key, value)
+		if c.Config.Show.Origin {
+			origin, ok := c.origins[key]
+			if !ok {
+				origin = "default"
+			}
+			fmt.Fprintf(w, "%s:\t%v\t# %s\n", key, value, origin)
+		} else {
+			fmt.Fprintf(w, "%s:\t%v\n", key, value)
+		}
+	}
+	return w.Flush()
+}
+
+// displayConfigValue returns the value to show without secrets.
+func displayConfigValue(key string, value interface{}) interface{} {
+	f := reflect.ValueOf(value)
+	switch {
+	case f.IsZero():
+		return ""
+	case secretConfigKeys[key]:
+		return "(hidden)"
+	case f.Kind() == reflect.Slice || f.Kind() == reflect.Ptr:
+		return "(set)"
+	case key == "proxy":
+		// strip the user and password
+		if u, err := url.Parse(f.String()); err == nil && u.User != nil {
+			u.User = nil
+			return u.String()
+		}
+	}
+	return value
+}
@@ -0,0 +1,282 @@
+package cli
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+
+	homedir "github.com/mitchellh/go-homedir"
+	yaml "gopkg.in/yaml.v3"
+)
+
+// configFile represents the YAML document of gpupconfig.
+// It preserves comments and unknown keys on writing.
+type configFile struct {
+	name   string
+	path   string
+	exists bool
+	doc    yaml.Node
+}
+
+// openConfigFile reads the file.
+// If the file does not exist, it returns an empty document.
+func openConfigFile(name string) (*configFile, error) {
+	p, err := homedir.Expand(name)
+	if err != nil {
+		return nil, fmt.Errorf("Could not expand %s: %s", name, err)
+	}
+	f := &configFile{name: name, path: p}
+	b, err := ioutil.ReadFile(p)
+	switch {
+	case os.IsNotExist(err):
+	case err != nil:
+		return nil, fmt.Errorf("Could not open %s: %s", name, err)
+	default:
+		f.exists = true
+		if err := yaml.Unmarshal(b, &f.doc); err != nil {
+			return nil, fmt.Errorf("Could not read YAML: %s", err)
+		}
+	}
+	if len(f.doc.Content) == 0 {
+		f.doc = yaml.Node{Kind: yaml.DocumentNode, Content: []*yaml.Node{{Kind: yaml.MappingNode, Tag: "!!map"}}}
+	}
+	if f.root().Kind != yaml.MappingNode {
+		return nil, fmt.Errorf("Could not read YAML: %s is not a mapping", name)
+	}
+	return f, nil
+}
+
+func (f *configFile) root() *yaml.Node {
+	return f.doc.Content[0]
+}
+
+// decode stores the items to the config.
+func (f *configFile) decode(c *ExternalConfig) error {
+	if err := f.root().Decode(c); err != nil {
+		return fmt.Errorf("Could not read YAML: %s", err)
+	}
+	return nil
+}
+
+// update replaces the items by the config.
+// Comments and unknown keys are kept.
+func (f *configFile) update(c *ExternalConfig) error {
+	var src yaml.Node
+	if err := src.Encode(c); err != nil {
+		return fmt.Errorf("Could not write to YAML: %s", err)
+	}
+	mergeNode(f.root(), &src, reflect.TypeOf(c))
+	return nil
+}
+
+// write writes the document to a temporary file and renames it.
+func (f *configFile) write() error {
+	var b bytes.Buffer
+	e := yaml.NewEncoder(&b)
+	e.SetIndent(2)
+	if err := e.Encode(&f.doc); err != nil {
+		return fmt.Errorf("Could not write to YAML: %s", err)
+	}
+	if err := e.Close(); err != nil {
+		return fmt.Errorf("Could not write to YAML: %s", err)
+	}
+	if err := os.MkdirAll(filepath.Dir(f.path), 0700); err != nil {
+		return fmt.Errorf("Could not create the directory of %s: %s", f.name, err)
+	}
+	if err := writeFileAtomic(f.path, b.Bytes(), 0600); err != nil {
+		return fmt.Errorf("Could not write to %s: %s", f.name, err)
+	}
+	return nil
+}
+
+// get returns the node of the dotted key, e.g. profiles.work.album.
+func (f *configFile) get(key string) *yaml.Node {
+	n := f.root()
+	for _, k := range strings.Split(key, ".") {
+		if n.Kind != yaml.MappingNode {
+			return nil
+		}
+		i := indexOfKey(n, k)
+		if i < 0 {
+			return nil
+		}
+		n = n.Content[i+1]
+	}
+	return n
+}
+
+// set sets the scalar value to the dotted key.
+// It returns an error if the key is not a setting of gpupconfig.
+func (f *configFile) set(key, value string) error {
+	t, err := typeOfKey(key)
+	if err != nil {
+		return err
+	}
+	switch t.Kind() {
+	case reflect.Struct, reflect.Map, reflect.Slice:
+		return fmt.Errorf("%s is not a single value", key)
+	}
+	v := &yaml.Node{Kind: yaml.ScalarNode, Value: value}
+	if t.Kind() == reflect.String {
+		v.Tag = "!!str"
+	}
+	if err := v.Decode(reflect.New(t).Interface()); err != nil {
+		return fmt.Errorf("Invalid value of %s: %s", key, err)
+	}
+	keys := strings.Split(key, ".")
+	n := f.root()
+	for _, k := range keys[:len(keys)-1] {
+		i := indexOfKey(n, k)
+		if i < 0 {
+			n.Content = append(n.Content,
+				&yaml.Node{Kind: yaml.ScalarNode, Tag: "!!str", Value: k},
+				&yaml.Node{Kind: yaml.MappingNode, Tag: "!!map"})
+			i = len(n.Content) - 2
+		}
+		n = n.Content[i+1]
+		if n.Kind != yaml.MappingNode {
+			return fmt.Errorf("%s is not a mapping", k)
+		}
+	}
+	k := keys[len(keys)-1]
+	if i := indexOfKey(n, k); i >= 0 {
+		old := n.Content[i+1]
+		v.LineComment, v.HeadComment, v.FootComment = old.LineComment, old.HeadComment, old.FootComment
+		n.Content[i+1] = v
+	} else {
+		n.Content = append(n.Content, &yaml.Node{Kind: yaml.ScalarNode, Tag: "!!str", Value: k}, v)
+	}
+	return nil
+}
+
+// unset removes the dotted key.
+// It returns false if the key does not exist.
+func (f *configFile) unset(key string) bool {
+	keys := strings.Split(key, ".")
+	parent := f.root()
+	if len(keys) > 1 {
+		parent = f.get(strings.Join(keys[:len(keys)-1], "."))
+	}
+	if parent == nil || parent.Kind != yaml.MappingNode {
+		return false
+	}
+	i := indexOfKey(parent, keys[len(keys)-1])
+	if i < 0 {
+		return false
+	}
+	parent.Content = append(parent.Content[:i], parent.Content[i+2:]...)
+	return true
+}
+
+// typeOfKey returns the type of the dotted key in gpupconfig.
+func typeOfKey(key string) (reflect.Type, error) {
+	t := reflect.TypeOf(ExternalConfig{})
+	for _, k := range strings.Split(key, ".") {
+		for t.Kind() == reflect.Ptr {
+			t = t.Elem()
+		}
+		switch t.Kind() {
+		case reflect.Map:
+			t = t.Elem()
+		case reflect.Struct:
+			field, ok := yamlFields(t)[k]
+			if !ok {
+				return nil, fmt.Errorf("Unknown key %s", key)
+			}
+			t = field
+		default:
+			return nil, fmt.Errorf("Unknown key %s", key)
+		}
+	}
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	return t, nil
+}
+
+// yamlFields returns the types of the YAML keys of the struct.
+func yamlFields(t reflect.Type) map[string]reflect.Type {
+	fields := make(map[string]reflect.Type)
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		tag := strings.Split(field.Tag.Get("yaml"), ",")
+		name := tag[0]
+		switch {
+		case name == "-":
+		case len(tag) > 1 && tag[1] == "inline":
+			for k, v := range yamlFields(field.Type) {
+				fields[k] = v
+			}
+		case name == "":
+			fields[strings.ToLower(field.Name)] = field.Type
+		default:
+			fields[name] = field.Type
+		}
+	}
+	return fields
+}
+
+// mergeNode updates the mapping of dst by src, where t is the type of src.
+// Known keys which do not exist in src are removed and unknown keys are kept.
+func mergeNode(dst, src *yaml.Node, t reflect.Type) {
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	var fields map[string]reflect.Type
+	if t.Kind() == reflect.Struct {
+		fields = yamlFields(t)
+	}
+	typeOf := func(key string) (reflect.Type, bool) {
+		if t.Kind() == reflect.Map {
+			return t.Elem(), true
+		}
+		field, ok := fields[key]
+		return field, ok
+	}
+	var content []*yaml.Node
+	for i := 0; i+1 < len(dst.Content); i += 2 {
+		k, v := dst.Content[i], dst.Content[i+1]
+		vt, known := typeOf(k.Value)
+		j := indexOfKey(src, k.Value)
+		switch {
+		case j >= 0:
+			sv := src.Content[j+1]
+			if v.Kind == yaml.MappingNode && sv.Kind == yaml.MappingNode && isMappingType(vt) {
+				mergeNode(v, sv, vt)
+			} else {
+				sv.LineComment, sv.HeadComment, sv.FootComment = v.LineComment, v.HeadComment, v.FootComment
+				v = sv
+			}
+			content = append(content, k, v)
+		case !known:
+			content = append(content, k, v)
+		}
+	}
+	for i := 0; i+1 < len(src.Content); i += 2 {
+		if indexOfKey(dst, src.Content[i].Value) < 0 {
+			content = append(content, src.Content[i], src.Content[i+1])
+		}
+	}
+	dst.Content = content
+}
+
+func isMappingType(t reflect.Type) bool {
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	return t.Kind() == reflect.Struct || t.Kind() == reflect.Map
+}
+
+// indexOfKey returns the index of the key in the mapping, or -1 if not found.
+func indexOfKey(n *yaml.Node, key string) int {
+	for i := 0; i+1 < len(n.Content); i += 2 {
+		if n.Content[i].Value == key {
+			return i
+		}
+	}
+	return -1
+}
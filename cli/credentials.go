@@ -0,0 +1,237 @@
+package cli
+
+import (
+	"bufio"
+	"fmt"
+	"net/http"
+	"net/http/cookiejar"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	homedir "github.com/mitchellh/go-homedir"
+)
+
+// HTTPCredential represents credentials for fetching URLs.
+// Match is a host (e.g. example.com or *.example.com) or an URL prefix (e.g. https://example.com/private/).
+type HTTPCredential struct {
+	Match       string            `yaml:"match"`
+	BearerToken string            `yaml:"bearer-token,omitempty"`
+	BasicAuth   string            `yaml:"basic-auth,omitempty"`
+	Headers     map[string]string `yaml:"headers,omitempty"`
+	Cookies     map[string]string `yaml:"cookies,omitempty"`
+	CookieFile  string            `yaml:"cookie-file,omitempty"`
+}
+
+// Validate returns an error if the credential is malformed.
+func (c *HTTPCredential) Validate() error {
+	if c.Match == "" {
+		return fmt.Errorf("match must not be empty")
+	}
+	if strings.Contains(c.Match, "://") {
+		u, err := url.Parse(c.Match)
+		if err != nil {
+			return fmt.Errorf("invalid URL prefix %s: %s", c.Match, err)
+		}
+		if u.Scheme == "" || u.Host == "" {
+			return fmt.Errorf("invalid URL prefix %s: scheme and host are required", c.Match)
+		}
+	} else if strings.ContainsAny(c.Match, "/ ") {
+		return fmt.Errorf("invalid host %s", c.Match)
+	}
+	if c.BearerToken != "" && c.BasicAuth != "" {
+		return fmt.Errorf("%s: bearer-token and basic-auth are exclusive", c.Match)
+	}
+	if c.BasicAuth != "" {
+		if _, _, err := parseBasicAuth(c.BasicAuth); err != nil {
+			return fmt.Errorf("%s: %s", c.Match, err)
+		}
+	}
+	for k := range c.Headers {
+		if !isValidHeaderName(k) {
+			return fmt.Errorf("%s: invalid header name %q", c.Match, k)
+		}
+	}
+	for k := range c.Cookies {
+		if k == "" || strings.ContainsAny(k, "=; \t") {
+			return fmt.Errorf("%s: invalid cookie name %q", c.Match, k)
+		}
+	}
+	return nil
+}
+
+// Matches returns true if the URL matches to the credential.
+// An URL prefix matches if the scheme and host are same and the path is under the prefix.
+func (c *HTTPCredential) Matches(u *url.URL) bool {
+	if strings.Contains(c.Match, "://") {
+		m, err := url.Parse(c.Match)
+		if err != nil {
+			return false
+		}
+		return strings.EqualFold(u.Scheme, m.Scheme) && strings.EqualFold(u.Host, m.Host) && hasPathPrefix(u.Path, m.Path)
+	}
+	host := strings.ToLower(u.Hostname())
+	match := strings.ToLower(c.Match)
+	if strings.Contains(match, ":") {
+		host = strings.ToLower(u.Host)
+	}
+	if strings.HasPrefix(match, "*.") {
+		return strings.HasSuffix(host, match[1:])
+	}
+	return host == match
+}
+
+// hasPathPrefix returns true if the path is the prefix or under the prefix.
+// For example, /private matches /private and /private/a.jpg but not /private2.
+func hasPathPrefix(path, prefix string) bool {
+	if prefix == "" || strings.HasSuffix(prefix, "/") {
+		return strings.HasPrefix(path, prefix)
+	}
+	return path == prefix || strings.HasPrefix(path, prefix+"/")
+}
+
+// Apply sets the credential to the request.
+func (c *HTTPCredential) Apply(r *http.Request) {
+	switch {
+	case c.BearerToken != "":
+		r.Header.Set("Authorization", "Bearer "+c.BearerToken)
+	case c.BasicAuth != "":
+		user, pass, _ := parseBasicAuth(c.BasicAuth)
+		r.SetBasicAuth(user, pass)
+	}
+	for k, v := range c.Headers {
+		r.Header.Set(k, v)
+	}
+	for k, v := range c.Cookies {
+		r.AddCookie(&http.Cookie{Name: k, Value: v})
+	}
+}
+
+// httpCredentials is a set of credentials for fetching URLs.
+type httpCredentials struct {
+	credentials []HTTPCredential
+	jar         http.CookieJar // nil if no cookie file is given
+}
+
+func newHTTPCredentials(credentials []HTTPCredential) (*httpCredentials, error) {
+	var creds httpCredentials
+	for i, c := range credentials {
+		if err := c.Validate(); err != nil {
+			return nil, fmt.Errorf("Invalid http-credentials[%d]: %s", i, err)
+		}
+		if c.CookieFile != "" {
+			if creds.jar == nil {
+				jar, err := cookiejar.New(nil)
+				if err != nil {
+					return nil, fmt.Errorf("Could not create a cookie jar: %s", err)
+				}
+				creds.jar = jar
+			}
+			if err := loadCookieFile(creds.jar, c.CookieFile); err != nil {
+				return nil, fmt.Errorf("Invalid http-credentials[%d]: %s", i, err)
+			}
+		}
+	}
+	creds.credentials = credentials
+	return &creds, nil
+}
+
+// Apply sets the first matched credential to the request.
+func (creds *httpCredentials) Apply(r *http.Request) {
+	for _, c := range creds.credentials {
+		if c.Matches(r.URL) {
+			c.Apply(r)
+			return
+		}
+	}
+}
+
+// loadCookieFile reads the cookies in Netscape format and stores them to the jar.
+func loadCookieFile(jar http.CookieJar, name string) error {
+	p, err := homedir.Expand(name)
+	if err != nil {
+		return fmt.Errorf("Could not expand %s: %s", name, err)
+	}
+	f, err := os.Open(p)
+	if err != nil {
+		return fmt.Errorf("Could not open %s: %s", name, err)
+	}
+	defer f.Close()
+	s := bufio.NewScanner(f)
+	for n := 1; s.Scan(); n++ {
+		line := strings.TrimSpace(s.Text())
+		httpOnly := strings.HasPrefix(line, "#HttpOnly_")
+		line = strings.TrimPrefix(line, "#HttpOnly_")
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		fields := strings.Split(line, "\t")
+		if len(fields) != 7 {
+			return fmt.Errorf("%s:%d: wants 7 fields but %d", name, n, len(fields))
+		}
+		expires, err := strconv.ParseInt(fields[4], 10, 64)
+		if err != nil {
+			return fmt.Errorf("%s:%d: invalid expiration: %s", name, n, err)
+		}
+		domain := strings.TrimPrefix(fields[0], ".")
+		secure := fields[3] == "TRUE"
+		scheme := "http"
+		if secure {
+			scheme = "https"
+		}
+		cookie := &http.Cookie{
+			Name:     fields[5],
+			Value:    fields[6],
+			Path:     fields[2],
+			Secure:   secure,
+			HttpOnly: httpOnly,
+		}
+		if fields[1] == "TRUE" {
+			cookie.Domain = domain
+		}
+		if expires > 0 {
+			cookie.Expires = time.Unix(expires, 0)
+		}
+		jar.SetCookies(&url.URL{Scheme: scheme, Host: domain, Path: fields[2]}, []*http.Cookie{cookie})
+	}
+	return s.Err()
+}
+
+// parseRequestHeader parses the header in form of KEY:VALUE.
+func parseRequestHeader(s string) (string, string, error) {
+	kv := strings.SplitN(s, ":", 2)
+	if len(kv) != 2 {
+		return "", "", fmt.Errorf("invalid header %q: wants KEY:VALUE", s)
+	}
+	k, v := strings.TrimSpace(kv[0]), strings.TrimSpace(kv[1])
+	if !isValidHeaderName(k) {
+		return "", "", fmt.Errorf("invalid header %q: invalid name", s)
+	}
+	return k, v, nil
+}
+
+// parseBasicAuth parses the credential in form of USER:PASS.
+func parseBasicAuth(s string) (string, string, error) {
+	kv := strings.SplitN(s, ":", 2)
+	if len(kv) != 2 {
+		return "", "", fmt.Errorf("invalid basic auth: wants USER:PASS")
+	}
+	if kv[0] == "" {
+		return "", "", fmt.Errorf("invalid basic auth: user must not be empty")
+	}
+	return kv[0], kv[1], nil
+}
+
+func isValidHeaderName(name string) bool {
+	if name == "" {
+		return false
+	}
+	for _, r := range name {
+		if r <= ' ' || r >= 0x7f || strings.ContainsRune(`()<>@,;:\"/[]?={}`, r) {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,147 @@
+package cli
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"testing"
+)
+
+func TestHTTPCredential_Matches(t *testing.T) {
+	for _, c := range []struct {
+		match string
+		url   string
+		want  bool
+	}{
+		{"example.com", "https://example.com/a.jpg", true},
+		{"example.com", "https://EXAMPLE.com:8080/a.jpg", true},
+		{"example.com", "https://www.example.com/a.jpg", false},
+		{"example.com:8080", "https://example.com:8080/a.jpg", true},
+		{"example.com:8080", "https://example.com/a.jpg", false},
+		{"*.example.com", "https://www.example.com/a.jpg", true},
+		{"*.example.com", "https://example.com/a.jpg", false},
+		{"https://example.com/private/", "https://example.com/private/a.jpg", true},
+		{"https://example.com/private/", "https://example.com/public/a.jpg", false},
+		{"https://example.com/private/", "http://example.com/private/a.jpg", false},
+		{"https://example.com/private", "https://example.com/private/a.jpg", true},
+		{"https://example.com/private", "https://example.com/private2/a.jpg", false},
+		{"https://example.com", "https://example.com/a.jpg", true},
+		{"https://example.com", "https://example.com.evil.net/a.jpg", false},
+		{"https://example.com", "https://example.com@evil.net/a.jpg", false},
+		{"https://example.com", "https://example.com:8443/a.jpg", false},
+	} {
+		t.Run(c.match+" "+c.url, func(t *testing.T) {
+			u, err := url.Parse(c.url)
+			if err != nil {
+				t.Fatal(err)
+			}
+			cred := HTTPCredential{Match: c.match}
+			if got := cred.Matches(u); got != c.want {
+				t.Errorf("Matches wants %v but %v", c.want, got)
+			}
+		})
+	}
+}
+
+func TestHTTPCredential_Validate(t *testing.T) {
+	for _, c := range []HTTPCredential{
+		{},
+		{Match: "example.com/foo"},
+		{Match: "://example.com"},
+		{Match: "example.com", BasicAuth: "alice"},
+		{Match: "example.com", BasicAuth: "alice:bob", BearerToken: "TOKEN"},
+		{Match: "example.com", Headers: map[string]string{"X Foo": "bar"}},
+		{Match: "example.com", Cookies: map[string]string{"a=b": "c"}},
+	} {
+		t.Run(c.Match, func(t *testing.T) {
+			if err := c.Validate(); err == nil {
+				t.Errorf("Validate wants error but nil")
+			}
+		})
+	}
+}
+
+func TestHTTPCredentials_Apply(t *testing.T) {
+	creds, err := newHTTPCredentials([]HTTPCredential{
+		{Match: "https://example.com/private/", BearerToken: "TOKEN"},
+		{Match: "example.com", BasicAuth: "alice:bob", Headers: map[string]string{"X-Foo": "bar"}, Cookies: map[string]string{"session": "baz"}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	r, err := http.NewRequest("GET", "https://example.com/private/a.jpg", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	creds.Apply(r)
+	if v := r.Header.Get("Authorization"); v != "Bearer TOKEN" {
+		t.Errorf("Authorization wants Bearer TOKEN but %s", v)
+	}
+	if v := r.Header.Get("X-Foo"); v != "" {
+		t.Errorf("X-Foo wants empty but %s", v)
+	}
+
+	r, err = http.NewRequest("GET", "https://example.com/public/a.jpg", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	creds.Apply(r)
+	if username, password, ok := r.BasicAuth(); !ok || username != "alice" || password != "bob" {
+		t.Errorf("BasicAuth wants alice:bob but %s:%s", username, password)
+	}
+	if v := r.Header.Get("X-Foo"); v != "bar" {
+		t.Errorf("X-Foo wants bar but %s", v)
+	}
+	if cookie, err := r.Cookie("session"); err != nil {
+		t.Errorf("Cookie(session) returns error: %s", err)
+	} else if cookie.Value != "baz" {
+		t.Errorf("Cookie(session) wants baz but %s", cookie.Value)
+	}
+}
+
+func TestNewHTTPCredentials_CookieFile(t *testing.T) {
+	f, err := ioutil.TempFile("", "cookies")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(f.Name())
+	if _, err := f.WriteString("# Netscape HTTP Cookie File\n" +
+		".example.com\tTRUE\t/\tFALSE\t0\tsession\tfoo\n" +
+		"#HttpOnly_other.example.org\tFALSE\t/\tTRUE\t0\tid\tbar\n"); err != nil {
+		t.Fatal(err)
+	}
+	f.Close()
+
+	creds, err := newHTTPCredentials([]HTTPCredential{{Match: "example.com", CookieFile: f.Name()}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if creds.jar == nil {
+		t.Fatalf("jar wants non-nil but nil")
+	}
+	cookies := creds.jar.Cookies(&url.URL{Scheme: "http", Host: "www.example.com", Path: "/"})
+	if len(cookies) != 1 || cookies[0].Name != "session" || cookies[0].Value != "foo" {
+		t.Errorf("cookies wants session=foo but %+v", cookies)
+	}
+	cookies = creds.jar.Cookies(&url.URL{Scheme: "https", Host: "other.example.org", Path: "/"})
+	if len(cookies) != 1 || cookies[0].Name != "id" || cookies[0].Value != "bar" {
+		t.Errorf("cookies wants id=bar but %+v", cookies)
+	}
+}
+
+func TestParseRequestHeader(t *testing.T) {
+	k, v, err := parseRequestHeader("Cookie: foo=bar")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if k != "Cookie" || v != "foo=bar" {
+		t.Errorf("wants Cookie:foo=bar but %s:%s", k, v)
+	}
+	for _, s := range []string{"Cookie", ": foo", "X Foo: bar"} {
+		if _, _, err := parseRequestHeader(s); err == nil {
+			t.Errorf("parseRequestHeader(%q) wants error but nil", s)
+		}
+	}
+}
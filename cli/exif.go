@@ -0,0 +1,133 @@
+package cli
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"strings"
+	"time"
+)
+
+const (
+	exifTagExifIFD          = 0x8769
+	exifTagDateTime         = 0x0132
+	exifTagDateTimeOriginal = 0x9003
+	exifTypeASCII           = 2
+	exifDateLayout          = "2006:01:02 15:04:05"
+)
+
+// readExifDate returns DateTimeOriginal or DateTime in the EXIF of the JPEG.
+// The time is in the local time zone because EXIF has no time zone.
+func readExifDate(r io.Reader) (time.Time, error) {
+	tiff, err := readJPEGExif(bufio.NewReader(r))
+	if err != nil {
+		return time.Time{}, err
+	}
+	if len(tiff) < 8 {
+		return time.Time{}, fmt.Errorf("Too short TIFF header")
+	}
+	var order binary.ByteOrder
+	switch string(tiff[:2]) {
+	case "II":
+		order = binary.LittleEndian
+	case "MM":
+		order = binary.BigEndian
+	default:
+		return time.Time{}, fmt.Errorf("Invalid byte order of TIFF header")
+	}
+	ifd0 := readExifIFD(tiff, order, order.Uint32(tiff[4:]))
+	if e, ok := ifd0[exifTagExifIFD]; ok {
+		exif := readExifIFD(tiff, order, order.Uint32(e[8:]))
+		if e, ok := exif[exifTagDateTimeOriginal]; ok {
+			if t, err := parseExifDate(tiff, order, e); err == nil {
+				return t, nil
+			}
+		}
+	}
+	if e, ok := ifd0[exifTagDateTime]; ok {
+		return parseExifDate(tiff, order, e)
+	}
+	return time.Time{}, fmt.Errorf("No date in EXIF")
+}
+
+// readJPEGExif returns the TIFF structure in the APP1 segment.
+func readJPEGExif(r *bufio.Reader) ([]byte, error) {
+	var soi [2]byte
+	if _, err := io.ReadFull(r, soi[:]); err != nil {
+		return nil, err
+	}
+	if soi != [2]byte{0xff, 0xd8} {
+		return nil, fmt.Errorf("Not a JPEG")
+	}
+	for {
+		var header [4]byte
+		if _, err := io.ReadFull(r, header[:]); err != nil {
+			return nil, err
+		}
+		if header[0] != 0xff {
+			return nil, fmt.Errorf("Invalid JPEG marker")
+		}
+		marker := header[1]
+		if marker == 0xda || marker == 0xd9 {
+			return nil, fmt.Errorf("No EXIF in JPEG")
+		}
+		length := int(binary.BigEndian.Uint16(header[2:]))
+		if length < 2 {
+			return nil, fmt.Errorf("Invalid JPEG segment length")
+		}
+		if marker != 0xe1 {
+			if _, err := io.CopyN(ioutil.Discard, r, int64(length-2)); err != nil {
+				return nil, err
+			}
+			continue
+		}
+		segment := make([]byte, length-2)
+		if _, err := io.ReadFull(r, segment); err != nil {
+			return nil, err
+		}
+		if bytes.HasPrefix(segment, []byte("Exif\x00\x00")) {
+			return segment[6:], nil
+		}
+	}
+}
+
+// readExifIFD returns the entries in the IFD at the offset, keyed by tag.
+// Each entry is 12 bytes of tag, type, count and value or offset.
+func readExifIFD(tiff []byte, order binary.ByteOrder, offset uint32) map[uint16][]byte {
+	entries := make(map[uint16][]byte)
+	if int64(offset)+2 > int64(len(tiff)) {
+		return entries
+	}
+	n := int(order.Uint16(tiff[offset:]))
+	for i := 0; i < n; i++ {
+		start := int64(offset) + 2 + int64(i)*12
+		if start+12 > int64(len(tiff)) {
+			break
+		}
+		e := tiff[start : start+12]
+		entries[order.Uint16(e)] = e
+	}
+	return entries
+}
+
+func parseExifDate(tiff []byte, order binary.ByteOrder, e []byte) (time.Time, error) {
+	if order.Uint16(e[2:]) != exifTypeASCII {
+		return time.Time{}, fmt.Errorf("Invalid type of date in EXIF")
+	}
+	count := int64(order.Uint32(e[4:]))
+	var value []byte
+	if count <= 4 {
+		value = e[8 : 8+count]
+	} else {
+		offset := int64(order.Uint32(e[8:]))
+		if offset+count > int64(len(tiff)) {
+			return time.Time{}, fmt.Errorf("Invalid offset of date in EXIF")
+		}
+		value = tiff[offset : offset+count]
+	}
+	s := strings.TrimRight(string(value), "\x00 ")
+	return time.ParseInLocation(exifDateLayout, s, time.Local)
+}
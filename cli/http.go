@@ -3,26 +3,93 @@ package cli
 import (
 	"context"
 	"fmt"
-	"log"
 	"net/http"
-	"net/http/httputil"
 
-	"github.com/int128/oauth2cli"
 	"github.com/int128/gpup/photos"
 	"golang.org/x/oauth2"
 )
 
-func (c *CLI) newHTTPClient() *http.Client {
-	if c.Debug {
-		return &http.Client{Transport: loggingTransport{http.DefaultTransport}}
+// newPhotos returns a service with the options.
+func (c *CLI) newPhotos(ctx context.Context) (*photos.Photos, error) {
+	created, err := readCreatedAlbums(c.AlbumsName)
+	if err != nil {
+		return nil, err
+	}
+	policy, err := c.newDuplicateAlbumPolicy(created)
+	if err != nil {
+		return nil, err
+	}
+	position, err := parseAlbumPosition(c.Position)
+	if err != nil {
+		return nil, err
+	}
+	if c.Concurrency < 1 {
+		return nil, fmt.Errorf("--concurrency must be positive but %d", c.Concurrency)
+	}
+	client, err := c.newOAuth2Client(ctx)
+	if err != nil {
+		return nil, err
+	}
+	o := photos.Options{
+		BasePath:  c.ExternalConfig.APIBasePath,
+		UploadURL: c.ExternalConfig.UploadURL,
+		Logger:    logger,
+	}
+	registry, err := c.startMetrics()
+	if err != nil {
+		return nil, err
+	}
+	if registry != nil {
+		o.Metrics = registry
+	}
+	service, err := photos.NewWithOptions(client, o)
+	if err != nil {
+		return nil, err
+	}
+	service.DuplicateAlbumPolicy = policy
+	service.AlbumPosition = position
+	service.Concurrency = c.Concurrency
+	if c.AlbumCacheTTL > 0 {
+		cache, err := readAlbumCache(c.AlbumCache, c.AlbumCacheTTL)
+		if err != nil {
+			return nil, err
+		}
+		service.AlbumCache = cache
+	}
+	service.AlbumRecorder = &albumRecorder{c.AlbumsName}
+	service.AlbumLocker = &fileLocker{c.ConfigName + ".lock"}
+	return service, nil
+}
+
+func (c *CLI) newHTTPClient() (*http.Client, error) {
+	transport, err := c.newTransport()
+	if err != nil {
+		return nil, err
+	}
+	if c.Debug || c.har != nil {
+		return &http.Client{Transport: &traceTransport{transport: transport, log: c.Debug, body: c.DebugBody, har: c.har}}, nil
+	}
+	if transport == http.DefaultTransport {
+		return http.DefaultClient, nil
 	}
-	return http.DefaultClient
+	return &http.Client{Transport: transport}, nil
 }
 
 func (c *CLI) newOAuth2Client(ctx context.Context) (*http.Client, error) {
-	token, err := c.ExternalConfig.EncodedToken.Decode()
+	store, err := c.newTokenStore()
 	if err != nil {
-		return nil, fmt.Errorf("Invalid config: %s", err)
+		return nil, err
+	}
+	// read the passphrase before the run,
+	// so that saving a refreshed token in the workers never prompts
+	if s, ok := store.(*encryptedFileTokenStore); ok {
+		if _, err := s.getPassphrase(); err != nil {
+			return nil, err
+		}
+	}
+	token, err := c.loadToken(store)
+	if err != nil {
+		return nil, err
 	}
 	oauth2Config := oauth2.Config{
 		ClientID:     c.ExternalConfig.ClientID,
@@ -30,67 +97,68 @@ func (c *CLI) newOAuth2Client(ctx context.Context) (*http.Client, error) {
 		Endpoint:     photos.Endpoint,
 		Scopes:       photos.Scopes,
 	}
-	ctx = context.WithValue(ctx, oauth2.HTTPClient, c.newHTTPClient())
+	hc, err := c.newHTTPClient()
+	if err != nil {
+		return nil, err
+	}
+	ctx = context.WithValue(ctx, oauth2.HTTPClient, hc)
+	lockName := c.ConfigName + ".token.lock"
+	if c.profile != "" {
+		lockName = c.ConfigName + "." + c.profile + ".token.lock"
+	}
 	switch {
 	case token == nil:
-		flow := oauth2cli.AuthCodeFlow{Config: oauth2Config}
-		token, err = flow.GetToken(ctx)
-		if err != nil {
-			return nil, fmt.Errorf("Could not get a token: %s", err)
-		}
-		c.ExternalConfig.EncodedToken, err = EncodeToken(token)
+		token, err = c.authorize(ctx, oauth2Config, store, lockName)
 		if err != nil {
-			return nil, fmt.Errorf("Could not encode the token: %s", err)
+			return nil, err
 		}
-		if err := c.ExternalConfig.Write(c.ConfigName); err != nil {
-			return nil, fmt.Errorf("Could not write the token to %s: %s", c.ConfigName, err)
-		}
-		log.Printf("Saved token to %s", c.ConfigName)
 
 	case !token.Valid():
-		log.Printf("Token of %s has been expired, refreshing", c.ConfigName)
-		token, err = oauth2Config.TokenSource(ctx, token).Token()
-		if err != nil {
+		logger.Info("Refreshing the expired token", "store", store)
+		refreshed, err := oauth2Config.TokenSource(ctx, token).Token()
+		switch {
+		case isInvalidGrant(err):
+			logger.Warn("The token has been expired or revoked, authorizing again", "error", err)
+			token, err = c.authorize(ctx, oauth2Config, store, lockName)
+			if err != nil {
+				return nil, err
+			}
+		case err != nil:
 			return nil, fmt.Errorf("Could not refresh the token: %s", err)
+		default:
+			token = refreshed
+			if err := saveToken(ctx, store, lockName, token); err != nil {
+				return nil, err
+			}
 		}
-		c.ExternalConfig.EncodedToken, err = EncodeToken(token)
-		if err != nil {
-			return nil, fmt.Errorf("Could not encode the token: %s", err)
-		}
-		if err := c.ExternalConfig.Write(c.ConfigName); err != nil {
-			return nil, fmt.Errorf("Could not write token to %s: %s", c.ConfigName, err)
-		}
-		log.Printf("Saved token to %s", c.ConfigName)
 	}
-	client := oauth2Config.Client(ctx, token)
+	ts := newPersistentTokenSource(ctx, oauth2Config.TokenSource(ctx, token), store, lockName, token)
+	// requests are traced by the base transport after the authorization header is set
+	return oauth2.NewClient(ctx, ts), nil
+}
+
+// authorize performs the flow to get a token and saves it to the store.
+func (c *CLI) authorize(ctx context.Context, config oauth2.Config, store tokenStore, lockName string) (*oauth2.Token, error) {
+	token, err := c.getToken(ctx, config)
 	if err != nil {
-		return nil, fmt.Errorf("Could not create a client: %s", err)
+		return nil, fmt.Errorf("Could not get a token: %s", err)
 	}
-	if c.Debug {
-		client.Transport = loggingTransport{client.Transport}
+	if err := saveToken(ctx, store, lockName, token); err != nil {
+		return nil, err
 	}
-	return client, nil
-}
-
-type loggingTransport struct {
-	transport http.RoundTripper
+	return token, nil
 }
 
-func (t loggingTransport) RoundTrip(req *http.Request) (*http.Response, error) {
-	if req != nil {
-		dump, err := httputil.DumpRequestOut(req, false)
-		if err != nil {
-			log.Printf("Could not dump request: %s", err)
-		}
-		log.Printf("[REQUEST] %s %s\n%s", req.Method, req.URL, string(dump))
+// saveToken saves the token to the store with the lock.
+func saveToken(ctx context.Context, store tokenStore, lockName string, token *oauth2.Token) error {
+	unlock, err := lockFile(ctx, lockName)
+	if err != nil {
+		return err
 	}
-	res, err := t.transport.RoundTrip(req)
-	if res != nil {
-		dump, err := httputil.DumpResponse(res, false)
-		if err != nil {
-			log.Printf("Could not dump response: %s", err)
-		}
-		log.Printf("[RESPONSE] %s %s\n%s", req.Method, req.URL, string(dump))
+	defer unlock()
+	if err := store.Save(token); err != nil {
+		return fmt.Errorf("Could not save the token to %s: %s", store, err)
 	}
-	return res, err
+	logger.Info("Saved the token", "store", store)
+	return nil
 }
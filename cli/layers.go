@@ -0,0 +1,240 @@
+package cli
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"runtime"
+	"sort"
+	"strings"
+
+	flags "github.com/jessevdk/go-flags"
+	homedir "github.com/mitchellh/go-homedir"
+)
+
+// projectConfigName is the name of the config file in the upload directory or its parents.
+const projectConfigName = ".gpup.yaml"
+
+// projectConfigKeys is the set of keys allowed in the project config.
+// Credentials, endpoints and the profile are not allowed, because the file may come from others.
+var projectConfigKeys = map[string]bool{
+	"album":           true,
+	"duplicate-album": true,
+	"sort":            true,
+	"position":        true,
+	"concurrency":     true,
+}
+
+// systemConfigName returns the path to the config file shared by all users.
+func systemConfigName() string {
+	if runtime.GOOS == "windows" {
+		return filepath.Join(os.Getenv("ProgramData"), "gpup", "config.yaml")
+	}
+	return "/etc/gpup/config.yaml"
+}
+
+// userConfigName returns the path to the config file of the user.
+// It is $XDG_CONFIG_HOME/gpup/config.yaml,
+// or ~/.gpupconfig if only it exists for compatibility.
+func userConfigName() string {
+	xdg := filepath.Join("~", ".config", "gpup", "config.yaml")
+	if dir := os.Getenv("XDG_CONFIG_HOME"); dir != "" {
+		xdg = filepath.Join(dir, "gpup", "config.yaml")
+	}
+	if fileExists(xdg) {
+		return xdg
+	}
+	if legacy := "~/.gpupconfig"; fileExists(legacy) {
+		return legacy
+	}
+	return xdg
+}
+
+func fileExists(name string) bool {
+	p, err := homedir.Expand(name)
+	if err != nil {
+		return false
+	}
+	_, err = os.Stat(p)
+	return err == nil
+}
+
+// findProjectConfig returns the nearest project config from the upload directory,
+// which is the first local path in the arguments or the current directory.
+// It returns an empty string if not found.
+func findProjectConfig(paths []string) string {
+	dir := "."
+	for _, p := range paths {
+		if p == "-" || strings.Contains(p, "://") {
+			continue
+		}
+		dir = p
+		if info, err := os.Stat(p); err == nil && !info.IsDir() {
+			dir = filepath.Dir(p)
+		}
+		break
+	}
+	dir, err := filepath.Abs(dir)
+	if err != nil {
+		return ""
+	}
+	for {
+		name := filepath.Join(dir, projectConfigName)
+		if fileExists(name) {
+			return name
+		}
+		parent := filepath.Dir(dir)
+		if parent == dir {
+			return ""
+		}
+		dir = parent
+	}
+}
+
+// loadConfig reads the system, user and project config and merges them into the options,
+// which are not given by the command line or environment variables.
+// It records where each item came from.
+func (c *CLI) loadConfig(parser *flags.Parser) error {
+	if c.ConfigName == "" {
+		c.ConfigName = userConfigName()
+	}
+	layers := []struct {
+		origin string
+		name   string
+	}{
+		{"system config", firstNonEmpty(c.SystemConfigName, systemConfigName())},
+		{"user config", c.ConfigName},
+		{"project config", findProjectConfig(append(c.Paths, c.Watch.Args.Dirs...))},
+	}
+	var merged ExternalConfig
+	c.origins = make(map[string]string)
+	for _, layer := range layers {
+		if layer.name == "" {
+			continue
+		}
+		f, err := openConfigFile(layer.name)
+		if err != nil {
+			return fmt.Errorf("Could not read the %s: %s", layer.origin, err)
+		}
+		if !f.exists {
+			if layer.name == c.ConfigName {
+				logger.Info("Skip reading the config: no such file", "path", layer.name)
+			}
+			continue
+		}
+		var cfg ExternalConfig
+		if err := f.decode(&cfg); err != nil {
+			return fmt.Errorf("Could not read %s: %s", layer.name, err)
+		}
+		if layer.origin == "project config" {
+			restrictProjectConfig(&cfg, layer.name)
+		}
+		merged.mergeLayer(&cfg, fmt.Sprintf("%s %s", layer.origin, layer.name), c.origins)
+	}
+
+	name, profile, err := merged.selectProfile(c.Profile)
+	if err != nil {
+		if parser.Active == nil || parser.Active.Name != "config" {
+			return err
+		}
+		// allow fixing the config
+		logger.Warn("Skip the profile", "error", err)
+	}
+	if origin := optionOrigin(parser, "profile"); origin != "" {
+		c.origins["default-profile"] = origin
+	}
+	if profile != nil {
+		c.profile = name
+		merged.clearAccountItems(c.origins)
+		merged.mergeWithOrigin(&profile.ExternalConfig, "profile "+name, c.origins)
+	}
+
+	// options given by the command line or environment variables take precedence
+	dst := reflect.ValueOf(&c.ExternalConfig).Elem()
+	src := reflect.ValueOf(&merged).Elem()
+	for i := 0; i < src.NumField(); i++ {
+		field := src.Type().Field(i)
+		long := field.Tag.Get("long")
+		if long == "" {
+			long = yamlKeyOf(field)
+		}
+		if origin := optionOrigin(parser, long); origin != "" {
+			c.origins[yamlKeyOf(field)] = origin
+			if field.Tag.Get("long") != "" {
+				continue
+			}
+		}
+		dst.Field(i).Set(src.Field(i))
+	}
+	return nil
+}
+
+// restrictProjectConfig removes the items not allowed in the project config.
+func restrictProjectConfig(cfg *ExternalConfig, name string) {
+	v := reflect.ValueOf(cfg).Elem()
+	for i := 0; i < v.NumField(); i++ {
+		key := yamlKeyOf(v.Type().Field(i))
+		if !projectConfigKeys[key] && !v.Field(i).IsZero() {
+			logger.Warn("Ignored the key not allowed in a project config", "key", key, "path", name, "allowed", strings.Join(sortedKeys(projectConfigKeys), ","))
+			v.Field(i).Set(reflect.Zero(v.Field(i).Type()))
+		}
+	}
+}
+
+// optionOrigin returns the origin if the option is given by the command line or the environment variable.
+func optionOrigin(parser *flags.Parser, longName string) string {
+	o := parser.FindOptionByLongName(longName)
+	switch {
+	case o == nil:
+		return ""
+	case o.IsSet() && !o.IsSetDefault():
+		return "option --" + longName
+	case o.EnvDefaultKey != "":
+		if _, ok := os.LookupEnv(o.EnvDefaultKey); ok {
+			return "environment " + o.EnvDefaultKey
+		}
+	}
+	return ""
+}
+
+// mergeLayer overwrites the items by the config of the next layer.
+// Profiles of the same name are merged as well.
+func (c *ExternalConfig) mergeLayer(p *ExternalConfig, origin string, origins map[string]string) {
+	c.mergeWithOrigin(p, origin, origins)
+	if p.DefaultProfile != "" {
+		c.DefaultProfile = p.DefaultProfile
+		origins["default-profile"] = origin
+	}
+	for name, profile := range p.Profiles {
+		if profile == nil {
+			continue
+		}
+		if c.Profiles == nil {
+			c.Profiles = make(map[string]*Profile)
+		}
+		merged := &Profile{}
+		if existing := c.Profiles[name]; existing != nil {
+			*merged = *existing
+		}
+		merged.merge(&profile.ExternalConfig)
+		c.Profiles[name] = merged
+	}
+}
+
+func yamlKeyOf(field reflect.StructField) string {
+	name := strings.Split(field.Tag.Get("yaml"), ",")[0]
+	if name == "" {
+		return strings.ToLower(field.Name)
+	}
+	return name
+}
+
+func sortedKeys(m map[string]bool) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -0,0 +1,104 @@
+package cli
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func writeFile(t *testing.T, name, content string) {
+	if err := os.MkdirAll(filepath.Dir(name), 0700); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(name, []byte(content), 0600); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestNew_Layers(t *testing.T) {
+	dir, err := ioutil.TempDir("", "gpupconfig")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	system := filepath.Join(dir, "system.yaml")
+	user := filepath.Join(dir, "user.yaml")
+	writeFile(t, system, "client-id: SYSTEM_ID\nsort: name\nconcurrency: 2\n")
+	writeFile(t, user, "client-id: USER_ID\nupload-url: http://user\nconcurrency: 3\n")
+	writeFile(t, filepath.Join(dir, "event", ".gpup.yaml"), "album: Wedding\nproxy: http://project\ndefault-profile: work\n")
+	writeFile(t, filepath.Join(dir, "event", "day1", "a.jpg"), "")
+
+	defer os.Unsetenv("GPUP_UPLOAD_URL")
+	os.Setenv("GPUP_UPLOAD_URL", "http://env")
+	c, err := New([]string{"gpup", "--gpupconfig", user, "--system-config", system, "--concurrency", "4",
+		filepath.Join(dir, "event", "day1", "a.jpg")}, "test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, m := range []struct {
+		key, value, got, origin string
+	}{
+		{"client-id", "USER_ID", c.ExternalConfig.ClientID, "user config " + user},
+		{"sort", "name", c.Sort, "system config " + system},
+		{"album", "Wedding", c.AlbumTitle, "project config " + filepath.Join(dir, "event", ".gpup.yaml")},
+		{"upload-url", "http://env", c.ExternalConfig.UploadURL, "environment GPUP_UPLOAD_URL"},
+		{"proxy", "", c.ExternalConfig.Proxy, ""},
+	} {
+		if m.got != m.value {
+			t.Errorf("%s wants %s but %s", m.key, m.value, m.got)
+		}
+		if c.origins[m.key] != m.origin {
+			t.Errorf("origin of %s wants %s but %s", m.key, m.origin, c.origins[m.key])
+		}
+	}
+	if c.profile != "" {
+		t.Errorf("profile wants empty but %s", c.profile)
+	}
+	if c.Concurrency != 4 {
+		t.Errorf("Concurrency wants 4 but %d", c.Concurrency)
+	}
+	if c.origins["concurrency"] != "option --concurrency" {
+		t.Errorf("origin of concurrency wants option --concurrency but %s", c.origins["concurrency"])
+	}
+}
+
+func TestFindProjectConfig(t *testing.T) {
+	dir, err := ioutil.TempDir("", "gpupconfig")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	dir, err = filepath.EvalSymlinks(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := filepath.Join(dir, "event", ".gpup.yaml")
+	writeFile(t, want, "album: Wedding\n")
+	writeFile(t, filepath.Join(dir, "event", "day1", "a.jpg"), "")
+
+	for _, paths := range [][]string{
+		{filepath.Join(dir, "event")},
+		{filepath.Join(dir, "event", "day1")},
+		{"https://example.com/a.jpg", filepath.Join(dir, "event", "day1", "a.jpg")},
+	} {
+		if got := findProjectConfig(paths); got != want {
+			t.Errorf("findProjectConfig(%v) wants %s but %s", paths, want, got)
+		}
+	}
+}
+
+func TestUserConfigName(t *testing.T) {
+	dir, err := ioutil.TempDir("", "gpupconfig")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	defer os.Unsetenv("XDG_CONFIG_HOME")
+	os.Setenv("XDG_CONFIG_HOME", dir)
+	want := filepath.Join(dir, "gpup", "config.yaml")
+	writeFile(t, want, "client-id: ID\n")
+	if got := userConfigName(); got != want {
+		t.Errorf("userConfigName wants %s but %s", want, got)
+	}
+}
@@ -0,0 +1,217 @@
+package cli
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"strings"
+
+	"github.com/int128/gpup/photos"
+	homedir "github.com/mitchellh/go-homedir"
+)
+
+// manifestEntry represents a line of the JSONL manifest.
+type manifestEntry struct {
+	Path        string            `json:"path"`
+	Album       string            `json:"album,omitempty"`
+	Description string            `json:"description,omitempty"`
+	Headers     map[string]string `json:"headers,omitempty"`
+	SHA256      string            `json:"sha256,omitempty"`
+}
+
+// manifestUploadItem represents an item with the attributes given by the manifest.
+type manifestUploadItem struct {
+	photos.UploadItem
+	album       string
+	description string
+}
+
+// Description returns the description or the filename.
+func (m *manifestUploadItem) Description() string {
+	if m.description != "" {
+		return m.description
+	}
+	return m.Name()
+}
+
+// Size returns the size of the underlying item, or -1 if unknown.
+func (m *manifestUploadItem) Size() (int64, error) {
+	if s, ok := m.UploadItem.(photos.SizedUploadItem); ok {
+		return s.Size()
+	}
+	return -1, nil
+}
+
+// albumOf returns the album title of the item, or empty if not given.
+func albumOf(item photos.UploadItem) string {
+	if m, ok := item.(*manifestUploadItem); ok {
+		return m.album
+	}
+	return ""
+}
+
+// findManifestEntries returns the entries in the arguments, list files and manifests.
+// An argument "-" means the list is read from stdin.
+// Stdin can be given only once in the arguments, --from-file and --manifest.
+func (c *CLI) findManifestEntries() ([]manifestEntry, error) {
+	var stdin int
+	for _, names := range [][]string{c.Paths, c.FromFiles, c.Manifests} {
+		for _, name := range names {
+			if name == "-" {
+				stdin++
+			}
+		}
+	}
+	if stdin > 1 {
+		return nil, fmt.Errorf("Stdin (-) can be read only once, but given %d times in the arguments, --from-file and --manifest", stdin)
+	}
+	var entries []manifestEntry
+	for _, arg := range c.Paths {
+		if arg == "-" {
+			paths, err := readListFile("-")
+			if err != nil {
+				return nil, err
+			}
+			for _, p := range paths {
+				entries = append(entries, manifestEntry{Path: p})
+			}
+			continue
+		}
+		entries = append(entries, manifestEntry{Path: arg})
+	}
+	for _, name := range c.FromFiles {
+		paths, err := readListFile(name)
+		if err != nil {
+			return nil, err
+		}
+		for _, p := range paths {
+			entries = append(entries, manifestEntry{Path: p})
+		}
+	}
+	for _, name := range c.Manifests {
+		m, err := readManifestFile(name)
+		if err != nil {
+			return nil, err
+		}
+		entries = append(entries, m...)
+	}
+	return entries, nil
+}
+
+func openListFile(name string) (io.ReadCloser, error) {
+	if name == "-" {
+		return ioutil.NopCloser(os.Stdin), nil
+	}
+	p, err := homedir.Expand(name)
+	if err != nil {
+		return nil, fmt.Errorf("Could not expand %s: %s", name, err)
+	}
+	f, err := os.Open(p)
+	if err != nil {
+		return nil, fmt.Errorf("Could not open %s: %s", name, err)
+	}
+	return f, nil
+}
+
+func readListFile(name string) ([]string, error) {
+	f, err := openListFile(name)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	paths, err := readList(f)
+	if err != nil {
+		return nil, fmt.Errorf("Could not read the list from %s: %s", name, err)
+	}
+	return paths, nil
+}
+
+// readList returns the entries separated by NUL or newline.
+// If the content contains NUL, it is treated as NUL-separated.
+func readList(r io.Reader) ([]string, error) {
+	b, err := ioutil.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	var lines []string
+	if bytes.IndexByte(b, 0) >= 0 {
+		lines = strings.Split(string(b), "\x00")
+	} else {
+		lines = strings.Split(string(b), "\n")
+		for i, line := range lines {
+			lines[i] = strings.TrimSuffix(line, "\r")
+		}
+	}
+	paths := make([]string, 0)
+	for _, line := range lines {
+		if line != "" {
+			paths = append(paths, line)
+		}
+	}
+	return paths, nil
+}
+
+func readManifestFile(name string) ([]manifestEntry, error) {
+	f, err := openListFile(name)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	entries, err := readManifest(f)
+	if err != nil {
+		return nil, fmt.Errorf("Could not read the manifest %s: %s", name, err)
+	}
+	return entries, nil
+}
+
+// readManifest returns the entries in the JSONL.
+// Blank lines are ignored.
+func readManifest(r io.Reader) ([]manifestEntry, error) {
+	s := bufio.NewScanner(r)
+	s.Buffer(make([]byte, 64*1024), 1024*1024)
+	entries := make([]manifestEntry, 0)
+	for n := 1; s.Scan(); n++ {
+		line := strings.TrimSpace(s.Text())
+		if line == "" {
+			continue
+		}
+		var entry manifestEntry
+		if err := json.Unmarshal([]byte(line), &entry); err != nil {
+			return nil, fmt.Errorf("line %d: invalid JSON: %s", n, err)
+		}
+		if entry.Path == "" {
+			return nil, fmt.Errorf("line %d: path must not be empty", n)
+		}
+		for k := range entry.Headers {
+			if !isValidHeaderName(k) {
+				return nil, fmt.Errorf("line %d: invalid header name %q", n, k)
+			}
+		}
+		if entry.SHA256 != "" {
+			if _, err := decodeSHA256(entry.SHA256); err != nil {
+				return nil, fmt.Errorf("line %d: %s", n, err)
+			}
+		}
+		entries = append(entries, entry)
+	}
+	if err := s.Err(); err != nil {
+		return nil, err
+	}
+	return entries, nil
+}
+
+func decodeSHA256(s string) ([]byte, error) {
+	b, err := hex.DecodeString(s)
+	if err != nil {
+		return nil, fmt.Errorf("invalid sha256 %s: %s", s, err)
+	}
+	if len(b) != 32 {
+		return nil, fmt.Errorf("invalid sha256 %s: wants 32 bytes but %d bytes", s, len(b))
+	}
+	return b, nil
+}
@@ -0,0 +1,80 @@
+package cli
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestReadList(t *testing.T) {
+	for _, c := range []struct {
+		name  string
+		input string
+		want  []string
+	}{
+		{"newline", "a.jpg\nb c.jpg\n\nhttp://example.com/d.jpg\n", []string{"a.jpg", "b c.jpg", "http://example.com/d.jpg"}},
+		{"crlf", "a.jpg\r\nb.jpg\r\n", []string{"a.jpg", "b.jpg"}},
+		{"nul", "a.jpg\x00b\nc.jpg\x00", []string{"a.jpg", "b\nc.jpg"}},
+		{"empty", "", []string{}},
+	} {
+		t.Run(c.name, func(t *testing.T) {
+			got, err := readList(strings.NewReader(c.input))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(c.want, got) {
+				t.Errorf("readList wants %v but %v", c.want, got)
+			}
+		})
+	}
+}
+
+func TestReadManifest(t *testing.T) {
+	input := `{"path": "a.jpg", "album": "Trip", "description": "Lunch"}
+
+{"path": "http://example.com/b.jpg", "headers": {"Cookie": "foo"}, "sha256": "e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855"}
+`
+	got, err := readManifest(strings.NewReader(input))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []manifestEntry{
+		{Path: "a.jpg", Album: "Trip", Description: "Lunch"},
+		{
+			Path:    "http://example.com/b.jpg",
+			Headers: map[string]string{"Cookie": "foo"},
+			SHA256:  "e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855",
+		},
+	}
+	if !reflect.DeepEqual(want, got) {
+		t.Errorf("readManifest wants %+v but %+v", want, got)
+	}
+}
+
+func TestReadManifest_Error(t *testing.T) {
+	for _, input := range []string{
+		`{"path": "a.jpg"`,
+		`{"album": "Trip"}`,
+		`{"path": "a.jpg", "sha256": "xyz"}`,
+		`{"path": "a.jpg", "sha256": "e3b0"}`,
+	} {
+		t.Run(input, func(t *testing.T) {
+			if _, err := readManifest(strings.NewReader(input)); err == nil {
+				t.Errorf("readManifest wants error but nil")
+			}
+		})
+	}
+}
+
+func TestCLI_findManifestEntries_StdinTwice(t *testing.T) {
+	for _, c := range []CLI{
+		{Paths: []string{"-"}, FromFiles: []string{"-"}},
+		{Paths: []string{"-"}, Manifests: []string{"-"}},
+		{FromFiles: []string{"-"}, Manifests: []string{"-"}},
+		{Paths: []string{"-", "-"}},
+	} {
+		if _, err := c.findManifestEntries(); err == nil || !strings.Contains(err.Error(), "only once") {
+			t.Errorf("findManifestEntries(%v, %v, %v) wants error but %v", c.Paths, c.FromFiles, c.Manifests, err)
+		}
+	}
+}
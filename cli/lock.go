@@ -0,0 +1,65 @@
+package cli
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	homedir "github.com/mitchellh/go-homedir"
+)
+
+// fileLocker provides an exclusive lock between gpup processes by a lock file.
+// It implements photos.AlbumLocker.
+type fileLocker struct {
+	name string
+}
+
+// LockAlbum acquires the lock regardless of the title.
+func (l *fileLocker) LockAlbum(ctx context.Context, title string) (func(), error) {
+	return lockFile(ctx, l.name)
+}
+
+var lockPollInterval = 100 * time.Millisecond
+
+// lockFile acquires an exclusive lock of the file.
+// It waits until the lock is released by another process or the context is done.
+func lockFile(ctx context.Context, name string) (func(), error) {
+	p, err := homedir.Expand(name)
+	if err != nil {
+		return nil, fmt.Errorf("Could not expand %s: %s", name, err)
+	}
+	// the config directory may not exist yet, e.g. the client ID is given by the environment variables
+	if err := os.MkdirAll(filepath.Dir(p), 0700); err != nil {
+		return nil, fmt.Errorf("Could not create the directory of %s: %s", name, err)
+	}
+	f, err := os.OpenFile(p, os.O_RDWR|os.O_CREATE, 0600)
+	if err != nil {
+		return nil, fmt.Errorf("Could not open %s: %s", name, err)
+	}
+	for waiting := false; ; waiting = true {
+		ok, err := tryLock(f)
+		if err != nil {
+			f.Close()
+			return nil, fmt.Errorf("Could not lock %s: %s", name, err)
+		}
+		if ok {
+			return func() {
+				if err := unlock(f); err != nil {
+					logger.Warn("Could not unlock", "path", name, "error", err)
+				}
+				f.Close()
+			}, nil
+		}
+		if !waiting {
+			logger.Info("Waiting for another process to release the lock", "path", name)
+		}
+		select {
+		case <-ctx.Done():
+			f.Close()
+			return nil, fmt.Errorf("Context done while waiting for %s: %s", name, ctx.Err())
+		case <-time.After(lockPollInterval):
+		}
+	}
+}
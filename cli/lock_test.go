@@ -0,0 +1,78 @@
+package cli
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	homedir "github.com/mitchellh/go-homedir"
+)
+
+// setEmptyHome sets HOME to an empty directory and returns the function to restore it.
+func setEmptyHome(t *testing.T) func() {
+	dir, err := ioutil.TempDir("", "home")
+	if err != nil {
+		t.Fatal(err)
+	}
+	home, xdg := os.Getenv("HOME"), os.Getenv("XDG_CONFIG_HOME")
+	os.Setenv("HOME", dir)
+	os.Unsetenv("XDG_CONFIG_HOME")
+	homedir.DisableCache = true
+	return func() {
+		os.Setenv("HOME", home)
+		os.Setenv("XDG_CONFIG_HOME", xdg)
+		homedir.DisableCache = false
+		os.RemoveAll(dir)
+	}
+}
+
+func TestLockFile(t *testing.T) {
+	f, err := ioutil.TempFile("", "gpuplock")
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.Close()
+	defer os.Remove(f.Name())
+
+	unlock, err := lockFile(context.Background(), f.Name())
+	if err != nil {
+		t.Fatalf("lockFile returns error: %s", err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 300*time.Millisecond)
+	defer cancel()
+	if _, err := lockFile(ctx, f.Name()); err == nil {
+		t.Errorf("lockFile wants error while locked but nil")
+	}
+
+	go func() {
+		time.Sleep(200 * time.Millisecond)
+		unlock()
+	}()
+	unlock2, err := lockFile(context.Background(), f.Name())
+	if err != nil {
+		t.Fatalf("lockFile returns error: %s", err)
+	}
+	unlock2()
+}
+
+func TestLockFile_EmptyHome(t *testing.T) {
+	defer setEmptyHome(t)()
+	name := userConfigName() + ".lock"
+	if want := filepath.Join("~", ".config", "gpup", "config.yaml.lock"); name != want {
+		t.Errorf("name wants %s but %s", want, name)
+	}
+	unlock, err := lockFile(context.Background(), name)
+	if err != nil {
+		t.Fatalf("lockFile returns error: %s", err)
+	}
+	unlock()
+	locker := &fileLocker{name}
+	unlock, err = locker.LockAlbum(context.Background(), "Album")
+	if err != nil {
+		t.Fatalf("LockAlbum returns error: %s", err)
+	}
+	unlock()
+}
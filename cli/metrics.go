@@ -0,0 +1,31 @@
+package cli
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+
+	"github.com/int128/gpup/metrics"
+)
+
+// startMetrics serves the metrics on --metrics-listen in background.
+// It returns nil if the option is not given.
+func (c *CLI) startMetrics() (*metrics.Registry, error) {
+	if c.MetricsListen == "" {
+		return nil, nil
+	}
+	l, err := net.Listen("tcp", c.MetricsListen)
+	if err != nil {
+		return nil, fmt.Errorf("Could not listen on %s: %s", c.MetricsListen, err)
+	}
+	registry := metrics.New()
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", registry)
+	go func() {
+		if err := http.Serve(l, mux); err != nil {
+			logger.Error("Error while serving metrics", "error", err)
+		}
+	}()
+	logger.Info("Serving metrics", "url", fmt.Sprintf("http://%s/metrics", l.Addr()))
+	return registry, nil
+}
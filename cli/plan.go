@@ -0,0 +1,149 @@
+package cli
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/int128/gpup/photos"
+)
+
+// uploadPlan represents the plan of the upload for --dry-run option.
+type uploadPlan struct {
+	Plans            []*photos.Plan  `json:"plans"`
+	Items            int             `json:"items"`
+	Skipped          int             `json:"skipped"`
+	TotalBytes       int64           `json:"totalBytes"`
+	UnknownSizeItems int             `json:"unknownSizeItems"`
+	APICalls         photos.APICalls `json:"apiCalls"`
+	TotalAPICalls    int             `json:"totalApiCalls"`
+
+	numbers map[photos.UploadItem]int // item number shown in the list
+}
+
+func validateDryRun(format string) error {
+	switch format {
+	case "", "text", "json":
+		return nil
+	}
+	return fmt.Errorf("Unknown --dry-run=%s: wants text or json", format)
+}
+
+// planUpload plans the upload without calling any API which changes the library.
+func (c *CLI) planUpload(ctx context.Context, service *photos.Photos, uploadItems []photos.UploadItem) (*uploadPlan, error) {
+	plan := uploadPlan{Plans: make([]*photos.Plan, 0), numbers: make(map[photos.UploadItem]int)}
+	for i, item := range uploadItems {
+		plan.numbers[item] = i + 1
+	}
+	for _, g := range groupByAlbum(uploadItems) {
+		p, err := c.plan(ctx, service, g.album, g.items)
+		if err != nil {
+			return nil, err
+		}
+		plan.Plans = append(plan.Plans, p)
+		for _, item := range p.Items {
+			if item.Skip != "" {
+				plan.Skipped++
+			} else {
+				plan.Items++
+			}
+		}
+		plan.TotalBytes += p.TotalBytes
+		plan.UnknownSizeItems += p.UnknownSizeItems
+		plan.APICalls.Lookup += p.APICalls.Lookup
+		plan.APICalls.CreateAlbum += p.APICalls.CreateAlbum
+		plan.APICalls.JoinSharedAlbum += p.APICalls.JoinSharedAlbum
+		plan.APICalls.Upload += p.APICalls.Upload
+		plan.APICalls.BatchCreate += p.APICalls.BatchCreate
+	}
+	plan.TotalAPICalls = plan.APICalls.Total()
+	return &plan, nil
+}
+
+// plan corresponds to add.
+func (c *CLI) plan(ctx context.Context, service *photos.Photos, album string, uploadItems []photos.UploadItem) (*photos.Plan, error) {
+	switch {
+	case album != "":
+		return service.PlanAddToAlbum(ctx, album, uploadItems)
+	case c.AlbumID != "":
+		return service.PlanAddToAlbumByID(ctx, c.AlbumID, uploadItems)
+	case c.ShareToken != "":
+		return service.PlanAddToSharedAlbum(ctx, c.ShareToken, uploadItems)
+	case c.AlbumTitle != "":
+		return service.PlanAddToAlbum(ctx, c.AlbumTitle, uploadItems)
+	case c.NewAlbum != "":
+		return service.PlanCreateAlbum(ctx, c.NewAlbum, uploadItems), nil
+	default:
+		return service.PlanAddToLibrary(ctx, uploadItems), nil
+	}
+}
+
+func (plan *uploadPlan) writeJSON(w io.Writer) error {
+	e := json.NewEncoder(w)
+	e.SetIndent("", "  ")
+	if err := e.Encode(plan); err != nil {
+		return fmt.Errorf("Could not write the plan: %s", err)
+	}
+	return nil
+}
+
+func (plan *uploadPlan) writeText(w io.Writer) {
+	for _, p := range plan.Plans {
+		switch {
+		case p.Album == nil:
+			fmt.Fprintf(w, "Library:\n")
+		case p.Album.ID != "":
+			fmt.Fprintf(w, "Album %s (id=%s): %s\n", p.Album.Title, p.Album.ID, p.Action)
+		case p.Album.ShareToken != "":
+			fmt.Fprintf(w, "Shared album of the share token: %s\n", p.Action)
+		default:
+			fmt.Fprintf(w, "Album %s: %s\n", p.Album.Title, p.Action)
+		}
+		for i, b := range p.Batches {
+			fmt.Fprintf(w, "  Batch %d: %d item(s)\n", i+1, b.Items)
+			for _, item := range p.Items {
+				if item.Batch == i {
+					fmt.Fprintf(w, "    #%d: %s (%s)\n", plan.numbers[item.Item], item.Path, formatSize(item.Size))
+				}
+			}
+		}
+		for _, item := range p.Items {
+			if item.Skip != "" {
+				fmt.Fprintf(w, "  Skip #%d: %s: %s\n", plan.numbers[item.Item], item.Path, item.Skip)
+			}
+		}
+	}
+	fmt.Fprintf(w, "Total: %d item(s) to upload, %d item(s) to skip, %d bytes", plan.Items, plan.Skipped, plan.TotalBytes)
+	if plan.UnknownSizeItems > 0 {
+		fmt.Fprintf(w, " and %d item(s) of unknown size", plan.UnknownSizeItems)
+	}
+	fmt.Fprintln(w)
+	fmt.Fprintf(w, "API calls: %d (lookup=%d, createAlbum=%d, joinSharedAlbum=%d, upload=%d, batchCreate=%d)\n",
+		plan.TotalAPICalls,
+		plan.APICalls.Lookup,
+		plan.APICalls.CreateAlbum,
+		plan.APICalls.JoinSharedAlbum,
+		plan.APICalls.Upload,
+		plan.APICalls.BatchCreate)
+}
+
+func formatSize(size int64) string {
+	if size < 0 {
+		return "unknown size"
+	}
+	return fmt.Sprintf("%d bytes", size)
+}
+
+func (c *CLI) dryRun(ctx context.Context, service *photos.Photos, uploadItems []photos.UploadItem) error {
+	plan, err := c.planUpload(ctx, service, uploadItems)
+	if err != nil {
+		return err
+	}
+	if c.DryRun == "json" {
+		return plan.writeJSON(os.Stdout)
+	}
+	plan.writeText(os.Stdout)
+	return nil
+}
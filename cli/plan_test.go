@@ -0,0 +1,98 @@
+package cli
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/int128/gpup/photos"
+)
+
+func TestCLI_planUpload(t *testing.T) {
+	dir, err := ioutil.TempDir("", "plan")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	if err := ioutil.WriteFile(filepath.Join(dir, "a.jpg"), []byte("JPEG"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(dir, "empty.jpg"), nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+	service, err := photos.New(http.DefaultClient)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c := CLI{NewAlbum: "Trip", DryRun: "json"}
+	items := []photos.UploadItem{
+		photos.FileUploadItem(filepath.Join(dir, "a.jpg")),
+		photos.FileUploadItem(filepath.Join(dir, "empty.jpg")),
+	}
+	plan, err := c.planUpload(context.Background(), service, items)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var b bytes.Buffer
+	if err := plan.writeJSON(&b); err != nil {
+		t.Fatal(err)
+	}
+	var got struct {
+		Plans []struct {
+			Action string
+			Album  struct{ Title string }
+			Items  []struct {
+				Name string
+				Skip string
+			}
+		}
+		Items         int
+		Skipped       int
+		TotalBytes    int64
+		TotalAPICalls int
+	}
+	if err := json.Unmarshal(b.Bytes(), &got); err != nil {
+		t.Fatalf("Invalid JSON: %s\n%s", err, b.String())
+	}
+	if len(got.Plans) != 1 || got.Plans[0].Action != "create" || got.Plans[0].Album.Title != "Trip" {
+		t.Errorf("Plans wants a new album Trip but %+v", got.Plans)
+	}
+	if got.Items != 1 || got.Skipped != 1 || got.TotalBytes != 4 {
+		t.Errorf("wants 1 item, 1 skipped and 4 bytes but %+v", got)
+	}
+	// createAlbum, upload and batchCreate
+	if got.TotalAPICalls != 3 {
+		t.Errorf("TotalAPICalls wants 3 but %d", got.TotalAPICalls)
+	}
+	if err := validateDryRun("yaml"); err == nil {
+		t.Errorf("validateDryRun wants error for unknown format but nil")
+	}
+}
+
+func TestNew_DryRun(t *testing.T) {
+	for _, c := range []struct {
+		args   []string
+		dryRun string
+	}{
+		{[]string{"gpup", "--gpupconfig", "/nonexistent", "--dry-run", "a.jpg"}, "text"},
+		{[]string{"gpup", "--gpupconfig", "/nonexistent", "--dry-run=json", "a.jpg"}, "json"},
+		{[]string{"gpup", "--gpupconfig", "/nonexistent", "a.jpg"}, ""},
+	} {
+		cli, err := New(c.args, "test")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if cli.DryRun != c.dryRun {
+			t.Errorf("DryRun wants %s but %s", c.dryRun, cli.DryRun)
+		}
+		if want := []string{"a.jpg"}; !reflect.DeepEqual(want, cli.Paths) {
+			t.Errorf("Paths wants %v but %v", want, cli.Paths)
+		}
+	}
+}
@@ -0,0 +1,195 @@
+package cli
+
+import (
+	"fmt"
+	"os"
+	"reflect"
+	"sort"
+	"text/tabwriter"
+
+	flags "github.com/jessevdk/go-flags"
+)
+
+// Profile represents a named set of credentials and options in gpupconfig.
+// Empty items are inherited from the top level of gpupconfig,
+// except the token which belongs to the account of the top level.
+type Profile struct {
+	ExternalConfig `yaml:",inline"`
+}
+
+// AuthCommand represents the auth command.
+type AuthCommand struct {
+	List struct{} `command:"list" description:"Show the profiles in gpupconfig"`
+}
+
+// selectProfile returns the profile of the name or default-profile.
+// It returns nil if no profile is used.
+func (c *ExternalConfig) selectProfile(name string) (string, *Profile, error) {
+	if name == "" {
+		name = c.DefaultProfile
+	}
+	if name == "" {
+		return "", nil, nil
+	}
+	p, ok := c.Profiles[name]
+	if !ok || p == nil {
+		return "", nil, fmt.Errorf("No such profile %s in gpupconfig", name)
+	}
+	return name, p, nil
+}
+
+// clearAccountItems removes the token and the token file,
+// so that a profile never uses the token of another account.
+func (c *ExternalConfig) clearAccountItems(origins map[string]string) {
+	c.EncodedToken = ""
+	c.TokenFile = ""
+	delete(origins, "token")
+	delete(origins, "token-file")
+}
+
+// merge overwrites the items by non-empty items of the profile.
+func (c *ExternalConfig) merge(p *ExternalConfig) {
+	c.mergeWithOrigin(p, "", nil)
+}
+
+// mergeWithOrigin overwrites the items by non-empty items of p,
+// and records the origin of them if origins is not nil.
+func (c *ExternalConfig) mergeWithOrigin(p *ExternalConfig, origin string, origins map[string]string) {
+	dst := reflect.ValueOf(c).Elem()
+	src := reflect.ValueOf(p).Elem()
+	for i := 0; i < src.NumField(); i++ {
+		field := src.Type().Field(i)
+		switch field.Name {
+		case "DefaultProfile", "Profiles":
+			continue
+		}
+		if v := src.Field(i); !v.IsZero() {
+			dst.Field(i).Set(v)
+			if origins != nil {
+				origins[yamlKeyOf(field)] = origin
+			}
+		}
+	}
+}
+
+// applySettings sets the options in gpupconfig which are not given by the command line.
+func (c *CLI) applySettings(parser *flags.Parser) {
+	cfg := c.ExternalConfig
+	if c.AlbumTitle == "" && c.NewAlbum == "" && c.AlbumID == "" && c.ShareToken == "" {
+		c.AlbumTitle = cfg.Album
+	}
+	if cfg.DuplicateAlbum != "" && isDefaultValue(parser, "duplicate-album") {
+		c.DuplicateAlbum = cfg.DuplicateAlbum
+	}
+	if cfg.Sort != "" && isDefaultValue(parser, "sort") {
+		c.Sort = cfg.Sort
+	}
+	if cfg.Position != "" && isDefaultValue(parser, "position") {
+		c.Position = cfg.Position
+	}
+	if cfg.Concurrency != 0 && isDefaultValue(parser, "concurrency") {
+		c.Concurrency = cfg.Concurrency
+	}
+}
+
+// applyProfile separates the files which depend on the account.
+func (c *CLI) applyProfile(parser *flags.Parser, name string) {
+	if isDefaultValue(parser, "gpupalbums") {
+		c.AlbumsName += "." + name
+	}
+	if isDefaultValue(parser, "album-cache") {
+		c.AlbumCache += "." + name
+	}
+}
+
+// isDefaultValue returns true if the option is given by neither the command line nor the environment variable.
+func isDefaultValue(parser *flags.Parser, longName string) bool {
+	o := parser.FindOptionByLongName(longName)
+	if o == nil {
+		return false
+	}
+	if o.IsSet() && !o.IsSetDefault() {
+		return false
+	}
+	if o.EnvDefaultKey != "" {
+		if _, ok := os.LookupEnv(o.EnvDefaultKey); ok {
+			return false
+		}
+	}
+	return true
+}
+
+// updateConfig applies the change to the current config and the user config.
+// Only the changed items are written, i.e. items from other layers are not.
+func (c *CLI) updateConfig(update func(cfg *ExternalConfig)) error {
+	update(&c.ExternalConfig)
+	f, err := openConfigFile(c.ConfigName)
+	if err != nil {
+		return err
+	}
+	var cfg ExternalConfig
+	if err := f.decode(&cfg); err != nil {
+		return err
+	}
+	target := &cfg
+	if c.profile != "" {
+		if cfg.Profiles == nil {
+			cfg.Profiles = make(map[string]*Profile)
+		}
+		p, ok := cfg.Profiles[c.profile]
+		if !ok || p == nil {
+			p = &Profile{}
+			cfg.Profiles[c.profile] = p
+		}
+		target = &p.ExternalConfig
+	}
+	update(target)
+	if err := f.update(&cfg); err != nil {
+		return err
+	}
+	return f.write()
+}
+
+// listProfiles shows the profiles in gpupconfig.
+// The profile used without --profile is marked with an asterisk.
+func (c *CLI) listProfiles() error {
+	var cfg ExternalConfig
+	if err := cfg.Read(c.ConfigName); err != nil {
+		return err
+	}
+	names := make([]string, 0, len(cfg.Profiles))
+	for name := range cfg.Profiles {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "\tPROFILE\tCLIENT ID\tTOKEN STORAGE\tALBUM")
+	row := func(name string, p *Profile) {
+		merged := cfg
+		merged.merge(&p.ExternalConfig)
+		mark := ""
+		if name == cfg.DefaultProfile {
+			mark = "*"
+		}
+		storage := merged.TokenStorage
+		if storage == "" {
+			storage = "config"
+		}
+		if name == "" {
+			name = "(top level)"
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\n", mark, name, merged.ClientID, storage, merged.Album)
+	}
+	if cfg.ClientID != "" || len(names) == 0 {
+		row("", &Profile{})
+	}
+	for _, name := range names {
+		p := cfg.Profiles[name]
+		if p == nil {
+			p = &Profile{}
+		}
+		row(name, p)
+	}
+	return w.Flush()
+}
@@ -0,0 +1,178 @@
+package cli
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+const profileConfig = `client-id: TOP_ID
+client-secret: TOP_SECRET
+proxy: http://proxy.example.com:8080
+default-profile: personal
+profiles:
+  work:
+    client-id: WORK_ID
+    client-secret: WORK_SECRET
+    album: Team
+    duplicate-album: newest
+  personal:
+    token-storage: keyring
+`
+
+func writeProfileConfig(t *testing.T) (string, func()) {
+	dir, err := ioutil.TempDir("", "gpupconfig")
+	if err != nil {
+		t.Fatal(err)
+	}
+	name := filepath.Join(dir, "gpupconfig")
+	if err := ioutil.WriteFile(name, []byte(profileConfig), 0600); err != nil {
+		t.Fatal(err)
+	}
+	return name, func() { os.RemoveAll(dir) }
+}
+
+func TestNew_Profile(t *testing.T) {
+	name, cleanup := writeProfileConfig(t)
+	defer cleanup()
+	c, err := New([]string{"gpup", "--gpupconfig", name, "--profile", "work", "a.jpg"}, "test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c.profile != "work" {
+		t.Errorf("profile wants work but %s", c.profile)
+	}
+	if c.ExternalConfig.ClientID != "WORK_ID" {
+		t.Errorf("ClientID wants WORK_ID but %s", c.ExternalConfig.ClientID)
+	}
+	if c.ExternalConfig.Proxy != "http://proxy.example.com:8080" {
+		t.Errorf("Proxy wants inherited but %s", c.ExternalConfig.Proxy)
+	}
+	if c.AlbumTitle != "Team" {
+		t.Errorf("AlbumTitle wants Team but %s", c.AlbumTitle)
+	}
+	if c.DuplicateAlbum != "newest" {
+		t.Errorf("DuplicateAlbum wants newest but %s", c.DuplicateAlbum)
+	}
+	if c.AlbumsName != "~/.gpupalbums.work" {
+		t.Errorf("AlbumsName wants ~/.gpupalbums.work but %s", c.AlbumsName)
+	}
+}
+
+func TestNew_ProfileOverriddenByOptions(t *testing.T) {
+	name, cleanup := writeProfileConfig(t)
+	defer cleanup()
+	c, err := New([]string{"gpup", "--gpupconfig", name, "--profile", "work",
+		"--album-id", "ID", "--duplicate-album", "fail", "--gpupalbums", "/tmp/albums", "--google-client-id", "FLAG_ID", "a.jpg"}, "test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c.AlbumTitle != "" {
+		t.Errorf("AlbumTitle wants empty but %s", c.AlbumTitle)
+	}
+	if c.DuplicateAlbum != "fail" {
+		t.Errorf("DuplicateAlbum wants fail but %s", c.DuplicateAlbum)
+	}
+	if c.AlbumsName != "/tmp/albums" {
+		t.Errorf("AlbumsName wants /tmp/albums but %s", c.AlbumsName)
+	}
+	if c.ExternalConfig.ClientID != "FLAG_ID" {
+		t.Errorf("ClientID wants FLAG_ID but %s", c.ExternalConfig.ClientID)
+	}
+}
+
+func TestNew_DefaultProfile(t *testing.T) {
+	name, cleanup := writeProfileConfig(t)
+	defer cleanup()
+	c, err := New([]string{"gpup", "--gpupconfig", name, "a.jpg"}, "test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c.profile != "personal" {
+		t.Errorf("profile wants personal but %s", c.profile)
+	}
+	if c.ExternalConfig.ClientID != "TOP_ID" {
+		t.Errorf("ClientID wants TOP_ID but %s", c.ExternalConfig.ClientID)
+	}
+	if c.ExternalConfig.TokenStorage != "keyring" {
+		t.Errorf("TokenStorage wants keyring but %s", c.ExternalConfig.TokenStorage)
+	}
+}
+
+func TestNew_ProfileDoesNotInheritToken(t *testing.T) {
+	dir, err := ioutil.TempDir("", "gpupconfig")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	name := filepath.Join(dir, "gpupconfig")
+	token, err := EncodeToken(testToken)
+	if err != nil {
+		t.Fatal(err)
+	}
+	config := `client-id: TOP_ID
+token: ` + string(token) + `
+token-file: /tmp/top.token
+profiles:
+  work:
+    client-id: WORK_ID
+    auth-flow: unknown
+`
+	if err := ioutil.WriteFile(name, []byte(config), 0600); err != nil {
+		t.Fatal(err)
+	}
+	c, err := New([]string{"gpup", "--gpupconfig", name, "--profile", "work", "a.jpg"}, "test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c.ExternalConfig.EncodedToken != "" {
+		t.Errorf("EncodedToken wants empty but %s", c.ExternalConfig.EncodedToken)
+	}
+	if c.ExternalConfig.TokenFile != "" {
+		t.Errorf("TokenFile wants empty but %s", c.ExternalConfig.TokenFile)
+	}
+	// the auth flow runs and fails due to auth-flow=unknown
+	_, err = c.newOAuth2Client(context.Background())
+	if err == nil || !strings.Contains(err.Error(), "Could not get a token") {
+		t.Errorf("newOAuth2Client wants error of the auth flow but %v", err)
+	}
+}
+
+func TestNew_NoSuchProfile(t *testing.T) {
+	name, cleanup := writeProfileConfig(t)
+	defer cleanup()
+	if _, err := New([]string{"gpup", "--gpupconfig", name, "--profile", "unknown", "a.jpg"}, "test"); err == nil {
+		t.Errorf("New wants error but nil")
+	}
+}
+
+func TestCLI_updateConfig_Profile(t *testing.T) {
+	name, cleanup := writeProfileConfig(t)
+	defer cleanup()
+	c, err := New([]string{"gpup", "--gpupconfig", name, "--profile", "work", "a.jpg"}, "test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := c.updateConfig(func(cfg *ExternalConfig) { cfg.EncodedToken = "TOKEN" }); err != nil {
+		t.Fatalf("updateConfig returned error: %s", err)
+	}
+	var cfg ExternalConfig
+	if err := cfg.Read(name); err != nil {
+		t.Fatal(err)
+	}
+	if cfg.EncodedToken != "" {
+		t.Errorf("EncodedToken of top level wants empty but %s", cfg.EncodedToken)
+	}
+	if cfg.Profiles["work"].EncodedToken != "TOKEN" {
+		t.Errorf("EncodedToken of work wants TOKEN but %s", cfg.Profiles["work"].EncodedToken)
+	}
+	if cfg.Profiles["work"].Album != "Team" {
+		t.Errorf("Album of work wants Team but %s", cfg.Profiles["work"].Album)
+	}
+	if cfg.Profiles["personal"] == nil {
+		t.Errorf("profile personal wants preserved")
+	}
+}
@@ -0,0 +1,56 @@
+package cli
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+
+	"golang.org/x/oauth2"
+)
+
+// persistentTokenSource saves the token to the store whenever it is refreshed,
+// so that the next run can reuse it.
+type persistentTokenSource struct {
+	ctx      context.Context
+	source   oauth2.TokenSource
+	store    tokenStore
+	lockName string // lock file held while saving the token
+
+	mu   sync.Mutex
+	last *oauth2.Token
+}
+
+func newPersistentTokenSource(ctx context.Context, source oauth2.TokenSource, store tokenStore, lockName string, token *oauth2.Token) *persistentTokenSource {
+	return &persistentTokenSource{ctx: ctx, source: source, store: store, lockName: lockName, last: token}
+}
+
+func (s *persistentTokenSource) Token() (*oauth2.Token, error) {
+	token, err := s.source.Token()
+	if err != nil {
+		if isInvalidGrant(err) {
+			return nil, fmt.Errorf("The token has been expired or revoked, run gpup again to authorize: %s", err)
+		}
+		return nil, err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.last != nil && s.last.AccessToken == token.AccessToken {
+		return token, nil
+	}
+	if err := saveToken(s.ctx, s.store, s.lockName, token); err != nil {
+		// the token is still available in memory
+		logger.Warn("Could not save the refreshed token", "error", err)
+		return token, nil
+	}
+	s.last = token
+	return token, nil
+}
+
+// isInvalidGrant returns true if the refresh token is no longer valid.
+func isInvalidGrant(err error) bool {
+	if e, ok := err.(*oauth2.RetrieveError); ok {
+		return strings.Contains(string(e.Body), "invalid_grant")
+	}
+	return false
+}
@@ -0,0 +1,96 @@
+package cli
+
+import (
+	"context"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"golang.org/x/oauth2"
+)
+
+type tokenStoreMock struct {
+	saved []*oauth2.Token
+}
+
+func (s *tokenStoreMock) Load() (*oauth2.Token, error) { return nil, nil }
+func (s *tokenStoreMock) Save(token *oauth2.Token) error {
+	s.saved = append(s.saved, token)
+	return nil
+}
+func (s *tokenStoreMock) String() string { return "mock" }
+
+type tokenSourceMock struct {
+	tokens []*oauth2.Token
+	err    error
+}
+
+func (s *tokenSourceMock) Token() (*oauth2.Token, error) {
+	if s.err != nil {
+		return nil, s.err
+	}
+	token := s.tokens[0]
+	if len(s.tokens) > 1 {
+		s.tokens = s.tokens[1:]
+	}
+	return token, nil
+}
+
+func TestPersistentTokenSource(t *testing.T) {
+	dir, err := ioutil.TempDir("", "token")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	initial := &oauth2.Token{AccessToken: "ACCESS1"}
+	refreshed := &oauth2.Token{AccessToken: "ACCESS2"}
+	source := &tokenSourceMock{tokens: []*oauth2.Token{initial, initial, refreshed, refreshed}}
+	store := &tokenStoreMock{}
+	s := newPersistentTokenSource(context.Background(), source, store, filepath.Join(dir, "lock"), initial)
+	for i := 0; i < 4; i++ {
+		if _, err := s.Token(); err != nil {
+			t.Fatalf("Token returned error: %s", err)
+		}
+	}
+	if len(store.saved) != 1 || store.saved[0] != refreshed {
+		t.Errorf("saved wants only the refreshed token but %+v", store.saved)
+	}
+}
+
+func TestPersistentTokenSource_InvalidGrant(t *testing.T) {
+	source := &tokenSourceMock{err: &oauth2.RetrieveError{
+		Response: &http.Response{Status: "400 Bad Request"},
+		Body:     []byte(`{"error":"invalid_grant","error_description":"Token has been expired or revoked."}`),
+	}}
+	s := newPersistentTokenSource(context.Background(), source, &tokenStoreMock{}, "/nonexistent/lock", nil)
+	_, err := s.Token()
+	if err == nil || !strings.Contains(err.Error(), "run gpup again") {
+		t.Errorf("Token wants error to authorize again but %v", err)
+	}
+}
+
+func TestSaveToken_NoConfigDir(t *testing.T) {
+	defer setEmptyHome(t)()
+	lockName := userConfigName() + ".token.lock"
+	store := &tokenStoreMock{}
+	token := &oauth2.Token{AccessToken: "ACCESS1"}
+	if err := saveToken(context.Background(), store, lockName, token); err != nil {
+		t.Fatalf("saveToken returned error: %s", err)
+	}
+	if len(store.saved) != 1 || store.saved[0] != token {
+		t.Errorf("saved wants the token but %+v", store.saved)
+	}
+
+	// the refreshed token is saved as well
+	refreshed := &oauth2.Token{AccessToken: "ACCESS2"}
+	s := newPersistentTokenSource(context.Background(), &tokenSourceMock{tokens: []*oauth2.Token{refreshed}}, store, lockName, token)
+	if _, err := s.Token(); err != nil {
+		t.Fatalf("Token returned error: %s", err)
+	}
+	if len(store.saved) != 2 || store.saved[1] != refreshed {
+		t.Errorf("saved wants the refreshed token but %+v", store.saved)
+	}
+}
@@ -0,0 +1,434 @@
+package cli
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/int128/gpup/photos"
+	homedir "github.com/mitchellh/go-homedir"
+)
+
+// ServeCommand represents the serve command.
+type ServeCommand struct {
+	Listen    string `long:"listen" value-name:"HOST:PORT" default:"localhost:7680" description:"Address to listen on"`
+	Socket    string `long:"socket" value-name:"FILE" description:"Path to the unix socket to listen on instead of the address"`
+	Token     string `long:"token" env:"GPUP_SERVE_TOKEN" value-name:"TOKEN" description:"Token to access the API (default: content of --token-file)"`
+	TokenFile string `long:"token-file" value-name:"FILE" default:"~/.gpupservetoken" description:"Path to the token, which is generated if it does not exist"`
+}
+
+func (c *CLI) serve(ctx context.Context) error {
+	token, err := c.serveToken()
+	if err != nil {
+		return err
+	}
+	l, err := c.listen()
+	if err != nil {
+		return err
+	}
+	service, err := c.newPhotos(ctx)
+	if err != nil {
+		l.Close()
+		return err
+	}
+	src, err := c.newHTTPSource()
+	if err != nil {
+		l.Close()
+		return err
+	}
+	s := newServer(token, func(ctx context.Context, req *jobRequest) ([]*jobResult, error) {
+		return c.runJob(ctx, service, src, req)
+	})
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+	defer signal.Stop(signals)
+	go func() {
+		select {
+		case <-signals:
+			logger.Info("Stopping the server")
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+
+	hs := &http.Server{Handler: s}
+	go func() {
+		<-ctx.Done()
+		shutdownCtx, shutdownCancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer shutdownCancel()
+		if err := hs.Shutdown(shutdownCtx); err != nil {
+			logger.Warn("Could not stop the server", "error", err)
+		}
+	}()
+	go s.run(ctx)
+	logger.Info("Listening", "address", l.Addr().String())
+	if err := hs.Serve(l); err != http.ErrServerClosed {
+		return fmt.Errorf("Could not serve: %s", err)
+	}
+	return nil
+}
+
+// serveToken returns the token of the API.
+// If no token is given, it reads or generates the token file.
+func (c *CLI) serveToken() (string, error) {
+	if c.Serve.Token != "" {
+		return c.Serve.Token, nil
+	}
+	p, err := homedir.Expand(c.Serve.TokenFile)
+	if err != nil {
+		return "", fmt.Errorf("Could not expand %s: %s", c.Serve.TokenFile, err)
+	}
+	b, err := ioutil.ReadFile(p)
+	switch {
+	case err == nil:
+		token := strings.TrimSpace(string(b))
+		if token == "" {
+			return "", fmt.Errorf("Token file %s is empty", c.Serve.TokenFile)
+		}
+		return token, nil
+	case !os.IsNotExist(err):
+		return "", fmt.Errorf("Could not read %s: %s", c.Serve.TokenFile, err)
+	}
+	token, err := newState()
+	if err != nil {
+		return "", fmt.Errorf("Could not generate a token: %s", err)
+	}
+	if err := writeFileAtomic(p, []byte(token+"\n"), 0600); err != nil {
+		return "", fmt.Errorf("Could not write to %s: %s", c.Serve.TokenFile, err)
+	}
+	logger.Info("Generated a token", "path", c.Serve.TokenFile)
+	return token, nil
+}
+
+// listen opens the unix socket or the TCP port.
+func (c *CLI) listen() (net.Listener, error) {
+	if c.Serve.Socket == "" {
+		l, err := net.Listen("tcp", c.Serve.Listen)
+		if err != nil {
+			return nil, fmt.Errorf("Could not listen on %s: %s", c.Serve.Listen, err)
+		}
+		return l, nil
+	}
+	p, err := homedir.Expand(c.Serve.Socket)
+	if err != nil {
+		return nil, fmt.Errorf("Could not expand %s: %s", c.Serve.Socket, err)
+	}
+	// remove the socket left by a previous run
+	if info, err := os.Stat(p); err == nil && info.Mode()&os.ModeSocket != 0 {
+		os.Remove(p)
+	}
+	l, err := net.Listen("unix", p)
+	if err != nil {
+		return nil, fmt.Errorf("Could not listen on %s: %s", c.Serve.Socket, err)
+	}
+	if err := os.Chmod(p, 0600); err != nil {
+		l.Close()
+		return nil, fmt.Errorf("Could not change the mode of %s: %s", c.Serve.Socket, err)
+	}
+	return l, nil
+}
+
+// runJob finds the items of the job and uploads them.
+func (c *CLI) runJob(ctx context.Context, service *photos.Photos, src *httpSource, req *jobRequest) ([]*jobResult, error) {
+	var uploadItems []photos.UploadItem
+	for _, path := range req.Paths {
+		items, err := c.findUploadItemsOf(ctx, src, manifestEntry{Path: path, Album: req.Album, Description: req.Description})
+		if err != nil {
+			return nil, err
+		}
+		uploadItems = append(uploadItems, items...)
+	}
+	if len(uploadItems) == 0 {
+		return nil, fmt.Errorf("Nothing to upload in %s", strings.Join(req.Paths, ", "))
+	}
+	if err := sortUploadItems(uploadItems, c.Sort); err != nil {
+		return nil, err
+	}
+	results := make([]*jobResult, len(uploadItems))
+	for _, g := range groupByAlbum(uploadItems) {
+		r, err := c.add(ctx, service, g.album, g.items)
+		if err != nil {
+			return nil, err
+		}
+		for i, index := range g.indexes {
+			results[index] = newJobResult(uploadItems[index], r[i])
+		}
+	}
+	return results, nil
+}
+
+// jobRequest represents the body of a job submission.
+type jobRequest struct {
+	Paths       []string `json:"paths"`
+	Album       string   `json:"album,omitempty"`
+	Description string   `json:"description,omitempty"`
+}
+
+// jobResult represents the result of an item in a job.
+type jobResult struct {
+	Item        string `json:"item"`
+	MediaItemID string `json:"media-item-id,omitempty"`
+	Error       string `json:"error,omitempty"`
+}
+
+func newJobResult(item photos.UploadItem, r *photos.AddResult) *jobResult {
+	jr := &jobResult{Item: item.String()}
+	switch {
+	case r.Error != nil:
+		jr.Error = r.Error.Error()
+	case r.MediaItem != nil:
+		jr.MediaItemID = r.MediaItem.Id
+	}
+	return jr
+}
+
+// Job states.
+const (
+	jobQueued    = "queued"
+	jobRunning   = "running"
+	jobSucceeded = "succeeded"
+	jobPartial   = "partial" // some items failed
+	jobFailed    = "failed"
+)
+
+// job represents an upload job and its status.
+type job struct {
+	ID         string       `json:"id"`
+	State      string       `json:"state"`
+	Request    jobRequest   `json:"request"`
+	Results    []*jobResult `json:"results,omitempty"`
+	Error      string       `json:"error,omitempty"`
+	CreatedAt  time.Time    `json:"created-at"`
+	FinishedAt *time.Time   `json:"finished-at,omitempty"`
+}
+
+// server provides the API to submit upload jobs and get their status.
+// Jobs are processed one by one, while items in a job are uploaded concurrently.
+type server struct {
+	token  string
+	upload func(ctx context.Context, req *jobRequest) ([]*jobResult, error)
+	mux    *http.ServeMux
+
+	maxFinished int           // limit of finished jobs to keep
+	retention   time.Duration // finished jobs are removed after the duration
+
+	mu    sync.Mutex
+	jobs  map[string]*job
+	order []string // IDs of jobs in order of submission
+	queue chan *job
+}
+
+const (
+	// serveQueueSize is the limit of jobs waiting in the queue.
+	serveQueueSize = 100
+	// serveMaxFinishedJobs is the limit of finished jobs kept for the status.
+	serveMaxFinishedJobs = 1000
+	// serveJobRetention is the duration to keep a finished job for the status.
+	serveJobRetention = 24 * time.Hour
+)
+
+func newServer(token string, upload func(ctx context.Context, req *jobRequest) ([]*jobResult, error)) *server {
+	s := &server{
+		token:       token,
+		upload:      upload,
+		mux:         http.NewServeMux(),
+		maxFinished: serveMaxFinishedJobs,
+		retention:   serveJobRetention,
+		jobs:        make(map[string]*job),
+		queue:       make(chan *job, serveQueueSize),
+	}
+	s.mux.HandleFunc("/v1/jobs", s.handleJobs)
+	s.mux.HandleFunc("/v1/jobs/", s.handleJob)
+	return s
+}
+
+func (s *server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if !s.authorized(r) {
+		w.Header().Set("WWW-Authenticate", "Bearer")
+		writeJSONError(w, http.StatusUnauthorized, "invalid token")
+		return
+	}
+	s.mux.ServeHTTP(w, r)
+}
+
+func (s *server) authorized(r *http.Request) bool {
+	h := r.Header.Get("Authorization")
+	if !strings.HasPrefix(h, "Bearer ") {
+		return false
+	}
+	return subtle.ConstantTimeCompare([]byte(strings.TrimPrefix(h, "Bearer ")), []byte(s.token)) == 1
+}
+
+// handleJobs handles GET to list jobs and POST to submit a job.
+func (s *server) handleJobs(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		s.mu.Lock()
+		jobs := make([]job, 0, len(s.order))
+		for _, id := range s.order {
+			jobs = append(jobs, *s.jobs[id])
+		}
+		s.mu.Unlock()
+		writeJSON(w, http.StatusOK, jobs)
+	case http.MethodPost:
+		var req jobRequest
+		d := json.NewDecoder(http.MaxBytesReader(w, r.Body, 1<<20))
+		d.DisallowUnknownFields()
+		if err := d.Decode(&req); err != nil {
+			writeJSONError(w, http.StatusBadRequest, fmt.Sprintf("invalid request: %s", err))
+			return
+		}
+		if len(req.Paths) == 0 {
+			writeJSONError(w, http.StatusBadRequest, "paths must not be empty")
+			return
+		}
+		j, err := s.submit(req)
+		if err != nil {
+			writeJSONError(w, http.StatusServiceUnavailable, err.Error())
+			return
+		}
+		w.Header().Set("Location", "/v1/jobs/"+j.ID)
+		writeJSON(w, http.StatusAccepted, j)
+	default:
+		w.Header().Set("Allow", "GET, POST")
+		writeJSONError(w, http.StatusMethodNotAllowed, "method not allowed")
+	}
+}
+
+// handleJob handles GET to get the status of a job.
+func (s *server) handleJob(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		w.Header().Set("Allow", "GET")
+		writeJSONError(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+	id := strings.TrimPrefix(r.URL.Path, "/v1/jobs/")
+	s.mu.Lock()
+	j, ok := s.jobs[id]
+	var snapshot job
+	if ok {
+		snapshot = *j
+	}
+	s.mu.Unlock()
+	if !ok {
+		writeJSONError(w, http.StatusNotFound, "job not found")
+		return
+	}
+	writeJSON(w, http.StatusOK, snapshot)
+}
+
+// submit adds a job to the queue and returns a snapshot of it.
+func (s *server) submit(req jobRequest) (job, error) {
+	id, err := newState()
+	if err != nil {
+		return job{}, fmt.Errorf("Could not generate an ID: %s", err)
+	}
+	j := &job{ID: id, State: jobQueued, Request: req, CreatedAt: time.Now()}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	select {
+	case s.queue <- j:
+	default:
+		return job{}, fmt.Errorf("Too many jobs in the queue")
+	}
+	s.jobs[id] = j
+	s.order = append(s.order, id)
+	return *j, nil
+}
+
+// run processes the jobs in the queue until the context is canceled.
+func (s *server) run(ctx context.Context) {
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case j := <-s.queue:
+			s.process(ctx, j)
+		}
+	}
+}
+
+func (s *server) process(ctx context.Context, j *job) {
+	s.mu.Lock()
+	j.State = jobRunning
+	req := j.Request
+	s.mu.Unlock()
+	logger.Info("Running the job", "job", j.ID, "paths", strings.Join(req.Paths, ","), "album", req.Album)
+	start := time.Now()
+	results, err := s.upload(ctx, &req)
+	now := time.Now()
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	defer s.evict(now)
+	j.FinishedAt = &now
+	j.Results = results
+	if err != nil {
+		j.State, j.Error = jobFailed, err.Error()
+		logger.Error("Job failed", "job", j.ID, "error", err, "duration", now.Sub(start))
+		return
+	}
+	var failed int
+	for _, r := range results {
+		if r.Error != "" {
+			failed++
+		}
+	}
+	switch {
+	case failed == 0:
+		j.State = jobSucceeded
+		logger.Info("Job finished", "job", j.ID, "duration", now.Sub(start))
+	case failed == len(results):
+		j.State, j.Error = jobFailed, fmt.Sprintf("All of %d items failed", failed)
+		logger.Error("Job failed", "job", j.ID, "error", j.Error, "duration", now.Sub(start))
+	default:
+		j.State, j.Error = jobPartial, fmt.Sprintf("%d of %d items failed", failed, len(results))
+		logger.Warn("Job finished with errors", "job", j.ID, "error", j.Error, "duration", now.Sub(start))
+	}
+}
+
+// evict removes the finished jobs older than the retention or beyond the limit.
+// It must be called with the lock.
+func (s *server) evict(now time.Time) {
+	var finished int
+	for _, id := range s.order {
+		if s.jobs[id].FinishedAt != nil {
+			finished++
+		}
+	}
+	order := s.order[:0]
+	for _, id := range s.order {
+		j := s.jobs[id]
+		if j.FinishedAt != nil && (finished > s.maxFinished || now.Sub(*j.FinishedAt) > s.retention) {
+			delete(s.jobs, id)
+			finished--
+			continue
+		}
+		order = append(order, id)
+	}
+	s.order = order
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		logger.Warn("Could not write the response", "error", err)
+	}
+}
+
+func writeJSONError(w http.ResponseWriter, status int, message string) {
+	writeJSON(w, status, map[string]string{"error": message})
+}
@@ -0,0 +1,214 @@
+package cli
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func newServeTestServer(t *testing.T, upload func(ctx context.Context, req *jobRequest) ([]*jobResult, error)) (*httptest.Server, func()) {
+	s := newServer("TOKEN", upload)
+	ctx, cancel := context.WithCancel(context.Background())
+	go s.run(ctx)
+	ts := httptest.NewServer(s)
+	return ts, func() {
+		ts.Close()
+		cancel()
+	}
+}
+
+func doServeRequest(t *testing.T, method, url, token, body string, v interface{}) int {
+	req, err := http.NewRequest(method, url, strings.NewReader(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	res, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer res.Body.Close()
+	if v != nil {
+		if err := json.NewDecoder(res.Body).Decode(v); err != nil {
+			t.Fatalf("Could not decode the response: %s", err)
+		}
+	}
+	return res.StatusCode
+}
+
+func TestServer_Auth(t *testing.T) {
+	ts, cleanup := newServeTestServer(t, nil)
+	defer cleanup()
+	for _, c := range []struct {
+		token  string
+		status int
+	}{
+		{"", http.StatusUnauthorized},
+		{"WRONG", http.StatusUnauthorized},
+		{"TOKEN", http.StatusOK},
+	} {
+		t.Run(c.token, func(t *testing.T) {
+			status := doServeRequest(t, "GET", ts.URL+"/v1/jobs", c.token, "", nil)
+			if c.status != status {
+				t.Errorf("status wants %d but %d", c.status, status)
+			}
+		})
+	}
+}
+
+func TestServer_BadRequest(t *testing.T) {
+	ts, cleanup := newServeTestServer(t, nil)
+	defer cleanup()
+	for _, c := range []struct {
+		method string
+		path   string
+		body   string
+		status int
+	}{
+		{"POST", "/v1/jobs", `{`, http.StatusBadRequest},
+		{"POST", "/v1/jobs", `{"paths":[]}`, http.StatusBadRequest},
+		{"POST", "/v1/jobs", `{"paths":["a.jpg"],"unknown":1}`, http.StatusBadRequest},
+		{"DELETE", "/v1/jobs", ``, http.StatusMethodNotAllowed},
+		{"GET", "/v1/jobs/NOTFOUND", ``, http.StatusNotFound},
+	} {
+		t.Run(c.method+" "+c.path+" "+c.body, func(t *testing.T) {
+			status := doServeRequest(t, c.method, ts.URL+c.path, "TOKEN", c.body, nil)
+			if c.status != status {
+				t.Errorf("status wants %d but %d", c.status, status)
+			}
+		})
+	}
+}
+
+func TestServer_Job(t *testing.T) {
+	var requests []jobRequest
+	ts, cleanup := newServeTestServer(t, func(ctx context.Context, req *jobRequest) ([]*jobResult, error) {
+		requests = append(requests, *req)
+		if req.Album == "FAIL" {
+			return nil, fmt.Errorf("album not found")
+		}
+		var results []*jobResult
+		for _, path := range req.Paths {
+			results = append(results, &jobResult{Item: path, MediaItemID: "ID-" + path})
+		}
+		return results, nil
+	})
+	defer cleanup()
+
+	var submitted, failed job
+	if status := doServeRequest(t, "POST", ts.URL+"/v1/jobs", "TOKEN",
+		`{"paths":["a.jpg","https://example.com/b.jpg"],"album":"Album","description":"Desc"}`, &submitted); status != http.StatusAccepted {
+		t.Fatalf("status wants %d but %d", http.StatusAccepted, status)
+	}
+	if status := doServeRequest(t, "POST", ts.URL+"/v1/jobs", "TOKEN", `{"paths":["c.jpg"],"album":"FAIL"}`, &failed); status != http.StatusAccepted {
+		t.Fatalf("status wants %d but %d", http.StatusAccepted, status)
+	}
+	if submitted.State != jobQueued && submitted.State != jobRunning {
+		t.Errorf("State wants %s but %s", jobQueued, submitted.State)
+	}
+
+	waitJob := func(id string) job {
+		var j job
+		for i := 0; i < 100; i++ {
+			if status := doServeRequest(t, "GET", ts.URL+"/v1/jobs/"+id, "TOKEN", "", &j); status != http.StatusOK {
+				t.Fatalf("status wants %d but %d", http.StatusOK, status)
+			}
+			if j.FinishedAt != nil {
+				return j
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+		t.Fatalf("job %s did not finish: %+v", id, j)
+		return j
+	}
+	j := waitJob(submitted.ID)
+	if j.State != jobSucceeded {
+		t.Errorf("State wants %s but %s", jobSucceeded, j.State)
+	}
+	wantResults := []*jobResult{
+		{Item: "a.jpg", MediaItemID: "ID-a.jpg"},
+		{Item: "https://example.com/b.jpg", MediaItemID: "ID-https://example.com/b.jpg"},
+	}
+	if !reflect.DeepEqual(wantResults, j.Results) {
+		t.Errorf("Results wants %+v but %+v", wantResults, j.Results)
+	}
+	if j.FinishedAt == nil {
+		t.Errorf("FinishedAt wants non-nil")
+	}
+	j = waitJob(failed.ID)
+	if j.State != jobFailed || j.Error != "album not found" {
+		t.Errorf("wants failed job but %+v", j)
+	}
+
+	wantRequests := []jobRequest{
+		{Paths: []string{"a.jpg", "https://example.com/b.jpg"}, Album: "Album", Description: "Desc"},
+		{Paths: []string{"c.jpg"}, Album: "FAIL"},
+	}
+	if !reflect.DeepEqual(wantRequests, requests) {
+		t.Errorf("requests wants %+v but %+v", wantRequests, requests)
+	}
+	var jobs []job
+	doServeRequest(t, "GET", ts.URL+"/v1/jobs", "TOKEN", "", &jobs)
+	if len(jobs) != 2 || jobs[0].ID != submitted.ID || jobs[1].ID != failed.ID {
+		t.Errorf("jobs wants [%s %s] but %+v", submitted.ID, failed.ID, jobs)
+	}
+}
+
+func TestServer_PartialJob(t *testing.T) {
+	s := newServer("TOKEN", func(ctx context.Context, req *jobRequest) ([]*jobResult, error) {
+		var results []*jobResult
+		for _, path := range req.Paths {
+			if strings.HasPrefix(path, "bad") {
+				results = append(results, &jobResult{Item: path, Error: "error"})
+				continue
+			}
+			results = append(results, &jobResult{Item: path, MediaItemID: "ID-" + path})
+		}
+		return results, nil
+	})
+	for _, c := range []struct {
+		paths []string
+		state string
+	}{
+		{[]string{"a.jpg", "b.jpg"}, jobSucceeded},
+		{[]string{"a.jpg", "bad.jpg"}, jobPartial},
+		{[]string{"bad1.jpg", "bad2.jpg"}, jobFailed},
+	} {
+		j, err := s.submit(jobRequest{Paths: c.paths})
+		if err != nil {
+			t.Fatal(err)
+		}
+		s.process(context.Background(), s.jobs[j.ID])
+		if got := s.jobs[j.ID]; got.State != c.state {
+			t.Errorf("State of %v wants %s but %s", c.paths, c.state, got.State)
+		}
+	}
+}
+
+func TestServer_evict(t *testing.T) {
+	s := newServer("TOKEN", nil)
+	s.maxFinished = 2
+	s.retention = time.Hour
+	now := time.Now()
+	old := now.Add(-2 * time.Hour)
+	for i, finishedAt := range []*time.Time{&old, &now, &now, &now, nil} {
+		id := fmt.Sprintf("job%d", i)
+		s.jobs[id] = &job{ID: id, FinishedAt: finishedAt}
+		s.order = append(s.order, id)
+	}
+	s.evict(now)
+	if want := []string{"job2", "job3", "job4"}; !reflect.DeepEqual(want, s.order) {
+		t.Errorf("order wants %v but %v", want, s.order)
+	}
+	if len(s.jobs) != 3 {
+		t.Errorf("len(jobs) wants 3 but %d", len(s.jobs))
+	}
+}
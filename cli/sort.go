@@ -0,0 +1,123 @@
+package cli
+
+import (
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/int128/gpup/photos"
+	photoslibrary "google.golang.org/api/photoslibrary/v1"
+)
+
+// sortUploadItems sorts the items by the key of --sort option.
+// Items without the time, such as URLs, are placed at the end in the original order.
+// URLs are sorted by the last path component, because the uploaded name is known only after fetching.
+func sortUploadItems(items []photos.UploadItem, key string) error {
+	switch key {
+	case "", "none":
+		return nil
+	case "name":
+		sort.SliceStable(items, func(i, j int) bool {
+			return items[i].Name() < items[j].Name()
+		})
+		return nil
+	case "mtime":
+		sortUploadItemsByTime(items, modTimeOf)
+		return nil
+	case "exif-date":
+		sortUploadItemsByTime(items, func(item photos.UploadItem) time.Time {
+			if t := exifDateOf(item); !t.IsZero() {
+				return t
+			}
+			return modTimeOf(item)
+		})
+		return nil
+	}
+	return fmt.Errorf("Unknown --sort=%s: wants one of name, mtime, exif-date or none", key)
+}
+
+func sortUploadItemsByTime(items []photos.UploadItem, timeOf func(photos.UploadItem) time.Time) {
+	times := make(map[photos.UploadItem]time.Time, len(items))
+	for _, item := range items {
+		times[item] = timeOf(item)
+	}
+	sort.SliceStable(items, func(i, j int) bool {
+		ti, tj := times[items[i]], times[items[j]]
+		if ti.IsZero() || tj.IsZero() {
+			return !ti.IsZero() && tj.IsZero()
+		}
+		return ti.Before(tj)
+	})
+}
+
+// fileOf returns the local file of the item if available.
+func fileOf(item photos.UploadItem) (photos.FileUploadItem, bool) {
+	for {
+		switch v := item.(type) {
+		case photos.FileUploadItem:
+			return v, true
+		case *manifestUploadItem:
+			item = v.UploadItem
+		case *photos.ChecksumUploadItem:
+			item = v.UploadItem
+		default:
+			return "", false
+		}
+	}
+}
+
+func modTimeOf(item photos.UploadItem) time.Time {
+	f, ok := fileOf(item)
+	if !ok {
+		return time.Time{}
+	}
+	fi, err := os.Stat(f.String())
+	if err != nil {
+		logger.Warn("Could not get the modified time", "item", f.String(), "error", err)
+		return time.Time{}
+	}
+	return fi.ModTime()
+}
+
+func exifDateOf(item photos.UploadItem) time.Time {
+	f, ok := fileOf(item)
+	if !ok {
+		return time.Time{}
+	}
+	r, err := os.Open(f.String())
+	if err != nil {
+		logger.Warn("Could not open", "item", f.String(), "error", err)
+		return time.Time{}
+	}
+	defer r.Close()
+	t, err := readExifDate(r)
+	if err != nil {
+		return time.Time{}
+	}
+	return t
+}
+
+// parseAlbumPosition returns the position for --position option.
+func parseAlbumPosition(s string) (*photoslibrary.AlbumPosition, error) {
+	switch {
+	case s == "" || s == "last":
+		return &photoslibrary.AlbumPosition{Position: "LAST_IN_ALBUM"}, nil
+	case s == "first":
+		return &photoslibrary.AlbumPosition{Position: "FIRST_IN_ALBUM"}, nil
+	case strings.HasPrefix(s, "after-item:"):
+		id := strings.TrimPrefix(s, "after-item:")
+		if id == "" {
+			return nil, fmt.Errorf("Invalid --position=%s: wants after-item:ID", s)
+		}
+		return &photoslibrary.AlbumPosition{Position: "AFTER_MEDIA_ITEM", RelativeMediaItemId: id}, nil
+	case strings.HasPrefix(s, "after-enrichment:"):
+		id := strings.TrimPrefix(s, "after-enrichment:")
+		if id == "" {
+			return nil, fmt.Errorf("Invalid --position=%s: wants after-enrichment:ID", s)
+		}
+		return &photoslibrary.AlbumPosition{Position: "AFTER_ENRICHMENT_ITEM", RelativeEnrichmentItemId: id}, nil
+	}
+	return nil, fmt.Errorf("Unknown --position=%s: wants one of first, last, after-item:ID or after-enrichment:ID", s)
+}
@@ -0,0 +1,160 @@
+package cli
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/int128/gpup/photos"
+	photoslibrary "google.golang.org/api/photoslibrary/v1"
+)
+
+// makeExifJPEG returns a JPEG header with the EXIF DateTimeOriginal.
+func makeExifJPEG(date string) []byte {
+	value := append([]byte(date), 0)
+	var tiff bytes.Buffer
+	entry := func(tag, typ uint16, count, value uint32) {
+		binary.Write(&tiff, binary.LittleEndian, tag)
+		binary.Write(&tiff, binary.LittleEndian, typ)
+		binary.Write(&tiff, binary.LittleEndian, count)
+		binary.Write(&tiff, binary.LittleEndian, value)
+	}
+	tiff.WriteString("II")
+	binary.Write(&tiff, binary.LittleEndian, uint16(42))
+	binary.Write(&tiff, binary.LittleEndian, uint32(8))
+	// IFD0 at 8: 1 entry pointing to the Exif IFD at 26
+	binary.Write(&tiff, binary.LittleEndian, uint16(1))
+	entry(exifTagExifIFD, 4, 1, 26)
+	binary.Write(&tiff, binary.LittleEndian, uint32(0))
+	// Exif IFD at 26: 1 entry pointing to the value at 44
+	binary.Write(&tiff, binary.LittleEndian, uint16(1))
+	entry(exifTagDateTimeOriginal, exifTypeASCII, uint32(len(value)), 44)
+	binary.Write(&tiff, binary.LittleEndian, uint32(0))
+	tiff.Write(value)
+
+	var b bytes.Buffer
+	b.Write([]byte{0xff, 0xd8, 0xff, 0xe1})
+	binary.Write(&b, binary.BigEndian, uint16(2+6+tiff.Len()))
+	b.WriteString("Exif\x00\x00")
+	b.Write(tiff.Bytes())
+	b.Write([]byte{0xff, 0xda})
+	return b.Bytes()
+}
+
+func TestReadExifDate(t *testing.T) {
+	got, err := readExifDate(bytes.NewReader(makeExifJPEG("2019:06:01 12:34:56")))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := time.Date(2019, 6, 1, 12, 34, 56, 0, time.Local)
+	if !want.Equal(got) {
+		t.Errorf("readExifDate wants %s but %s", want, got)
+	}
+	if _, err := readExifDate(bytes.NewReader([]byte("GIF89a"))); err == nil {
+		t.Errorf("readExifDate wants error for non-JPEG but nil")
+	}
+}
+
+func TestSortUploadItems(t *testing.T) {
+	dir, err := ioutil.TempDir("", "sort")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	now := time.Now()
+	for _, f := range []struct {
+		name  string
+		mtime time.Time
+		exif  string
+	}{
+		{"a.jpg", now.Add(-1 * time.Hour), "2019:06:03 00:00:00"},
+		{"b.jpg", now.Add(-3 * time.Hour), ""},
+		{"c.jpg", now.Add(-2 * time.Hour), "2019:06:01 00:00:00"},
+	} {
+		name := filepath.Join(dir, f.name)
+		var content []byte
+		if f.exif != "" {
+			content = makeExifJPEG(f.exif)
+		}
+		if err := ioutil.WriteFile(name, content, 0644); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.Chtimes(name, f.mtime, f.mtime); err != nil {
+			t.Fatal(err)
+		}
+	}
+	req, err := http.NewRequest("GET", "http://example.com/0.jpg", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	newItems := func() []photos.UploadItem {
+		return []photos.UploadItem{
+			photos.FileUploadItem(filepath.Join(dir, "c.jpg")),
+			&photos.HTTPUploadItem{Request: req},
+			&manifestUploadItem{UploadItem: photos.FileUploadItem(filepath.Join(dir, "a.jpg"))},
+			photos.FileUploadItem(filepath.Join(dir, "b.jpg")),
+		}
+	}
+	for _, c := range []struct {
+		key  string
+		want []string
+	}{
+		{"none", []string{"c.jpg", "", "a.jpg", "b.jpg"}},
+		{"name", []string{"", "a.jpg", "b.jpg", "c.jpg"}},
+		{"mtime", []string{"b.jpg", "c.jpg", "a.jpg", ""}},
+		// b.jpg has no EXIF and falls back to mtime
+		{"exif-date", []string{"c.jpg", "a.jpg", "b.jpg", ""}},
+	} {
+		t.Run(c.key, func(t *testing.T) {
+			items := newItems()
+			if err := sortUploadItems(items, c.key); err != nil {
+				t.Fatal(err)
+			}
+			got := make([]string, len(items))
+			for i, item := range items {
+				if f, ok := fileOf(item); ok {
+					got[i] = filepath.Base(f.String())
+				}
+			}
+			if !reflect.DeepEqual(c.want, got) {
+				t.Errorf("sortUploadItems wants %v but %v", c.want, got)
+			}
+		})
+	}
+	if err := sortUploadItems(newItems(), "size"); err == nil {
+		t.Errorf("sortUploadItems wants error for unknown key but nil")
+	}
+}
+
+func TestParseAlbumPosition(t *testing.T) {
+	for _, c := range []struct {
+		input string
+		want  photoslibrary.AlbumPosition
+	}{
+		{"last", photoslibrary.AlbumPosition{Position: "LAST_IN_ALBUM"}},
+		{"first", photoslibrary.AlbumPosition{Position: "FIRST_IN_ALBUM"}},
+		{"after-item:ITEM", photoslibrary.AlbumPosition{Position: "AFTER_MEDIA_ITEM", RelativeMediaItemId: "ITEM"}},
+		{"after-enrichment:E", photoslibrary.AlbumPosition{Position: "AFTER_ENRICHMENT_ITEM", RelativeEnrichmentItemId: "E"}},
+	} {
+		t.Run(c.input, func(t *testing.T) {
+			got, err := parseAlbumPosition(c.input)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(c.want, *got) {
+				t.Errorf("parseAlbumPosition wants %+v but %+v", c.want, *got)
+			}
+		})
+	}
+	for _, input := range []string{"middle", "after-item:", "after-enrichment:"} {
+		if _, err := parseAlbumPosition(input); err == nil {
+			t.Errorf("parseAlbumPosition(%s) wants error but nil", input)
+		}
+	}
+}
@@ -0,0 +1,437 @@
+package cli
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"strings"
+	"sync"
+
+	homedir "github.com/mitchellh/go-homedir"
+	"golang.org/x/crypto/nacl/secretbox"
+	"golang.org/x/crypto/scrypt"
+	"golang.org/x/crypto/ssh/terminal"
+	"golang.org/x/oauth2"
+)
+
+// tokenStore loads and saves the token.
+type tokenStore interface {
+	// Load returns the token, or nil if not stored.
+	Load() (*oauth2.Token, error)
+	// Save stores the token.
+	Save(token *oauth2.Token) error
+	// String returns the location for logging.
+	String() string
+}
+
+// newTokenStore returns the token store of --token-storage option.
+func (c *CLI) newTokenStore() (tokenStore, error) {
+	cfg := c.ExternalConfig
+	switch cfg.TokenStorage {
+	case "", "config":
+		return &configTokenStore{c}, nil
+	case "keyring":
+		account := cfg.ClientID
+		if c.profile != "" {
+			account = c.profile + ":" + account
+		}
+		return &keyringTokenStore{account: account, run: runCommand}, nil
+	case "encrypted-file":
+		name := cfg.TokenFile
+		if name == "" {
+			name = "~/.gpuptoken"
+			if c.profile != "" {
+				name += "." + c.profile
+			}
+		}
+		return &encryptedFileTokenStore{name: name, passphrase: readPassphrase}, nil
+	case "helper":
+		if cfg.TokenHelper == "" {
+			return nil, fmt.Errorf("token-helper is required for token-storage=helper")
+		}
+		return &helperTokenStore{command: cfg.TokenHelper, clientID: cfg.ClientID, profile: c.profile}, nil
+	}
+	return nil, fmt.Errorf("Unknown token-storage=%s: wants one of config, keyring, encrypted-file or helper", cfg.TokenStorage)
+}
+
+// loadToken returns the token in the store.
+// If the store is not gpupconfig and gpupconfig has a token,
+// it moves the token to the store and removes it from gpupconfig.
+func (c *CLI) loadToken(store tokenStore) (*oauth2.Token, error) {
+	token, err := store.Load()
+	if err != nil {
+		return nil, fmt.Errorf("Could not load the token from %s: %s", store, err)
+	}
+	if _, ok := store.(*configTokenStore); ok || c.ExternalConfig.EncodedToken == "" {
+		return token, nil
+	}
+	if token == nil {
+		token, err = c.ExternalConfig.EncodedToken.Decode()
+		if err != nil {
+			return nil, fmt.Errorf("Invalid config: %s", err)
+		}
+		if err := store.Save(token); err != nil {
+			return nil, fmt.Errorf("Could not save the token to %s: %s", store, err)
+		}
+		logger.Info("Moved the token", "from", c.ConfigName, "to", store)
+	}
+	if err := c.updateConfig(func(cfg *ExternalConfig) { cfg.EncodedToken = "" }); err != nil {
+		return nil, fmt.Errorf("Could not remove the token from %s: %s", c.ConfigName, err)
+	}
+	return token, nil
+}
+
+// configTokenStore stores the token in gpupconfig.
+type configTokenStore struct {
+	c *CLI
+}
+
+func (s *configTokenStore) Load() (*oauth2.Token, error) {
+	return s.c.ExternalConfig.EncodedToken.Decode()
+}
+
+func (s *configTokenStore) Save(token *oauth2.Token) error {
+	encoded, err := EncodeToken(token)
+	if err != nil {
+		return fmt.Errorf("Could not encode the token: %s", err)
+	}
+	return s.c.updateConfig(func(cfg *ExternalConfig) { cfg.EncodedToken = encoded })
+}
+
+func (s *configTokenStore) String() string {
+	return s.c.ConfigName
+}
+
+const keyringService = "gpup"
+
+// keyringTokenStore stores the token in the keyring of the OS.
+// It uses secret-tool of libsecret on Linux and security command on macOS.
+type keyringTokenStore struct {
+	account string
+	run     func(stdin string, name string, arg ...string) (string, error)
+	goos    string // default to runtime.GOOS
+}
+
+func (s *keyringTokenStore) platform() string {
+	if s.goos != "" {
+		return s.goos
+	}
+	return runtime.GOOS
+}
+
+func (s *keyringTokenStore) Load() (*oauth2.Token, error) {
+	var out string
+	var err error
+	switch s.platform() {
+	case "darwin":
+		out, err = s.run("", "security", "find-generic-password", "-s", keyringService, "-a", s.account, "-w")
+	case "windows":
+		return nil, fmt.Errorf("Keyring is not supported on Windows, use encrypted-file or helper instead")
+	default:
+		out, err = s.run("", "secret-tool", "lookup", "service", keyringService, "account", s.account)
+	}
+	if err != nil {
+		// both commands exit with non-zero status if the item is not found
+		if _, ok := err.(*exec.ExitError); ok {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return decodeTokenJSON(strings.TrimSpace(out))
+}
+
+func (s *keyringTokenStore) Save(token *oauth2.Token) error {
+	b, err := json.Marshal(token)
+	if err != nil {
+		return fmt.Errorf("Could not encode the token: %s", err)
+	}
+	switch s.platform() {
+	case "darwin":
+		// pass the token in the interactive mode on stdin, because arguments can be seen by other users
+		command := fmt.Sprintf("add-generic-password -U -s %s -a %s -X %s\n",
+			keyringService, strconv.Quote(s.account), hex.EncodeToString(b))
+		_, err = s.run(command, "security", "-i")
+	case "windows":
+		return fmt.Errorf("Keyring is not supported on Windows, use encrypted-file or helper instead")
+	default:
+		_, err = s.run(string(b), "secret-tool", "store", "--label=gpup token", "service", keyringService, "account", s.account)
+	}
+	return err
+}
+
+func (s *keyringTokenStore) String() string {
+	return "keyring"
+}
+
+func runCommand(stdin string, name string, arg ...string) (string, error) {
+	cmd := exec.Command(name, arg...)
+	cmd.Stdin = strings.NewReader(stdin)
+	cmd.Stderr = os.Stderr
+	out, err := cmd.Output()
+	if err != nil {
+		return "", err
+	}
+	return string(out), nil
+}
+
+func decodeTokenJSON(s string) (*oauth2.Token, error) {
+	if s == "" {
+		return nil, nil
+	}
+	var token oauth2.Token
+	if err := json.Unmarshal([]byte(s), &token); err != nil {
+		return nil, fmt.Errorf("Invalid json: %s", err)
+	}
+	return &token, nil
+}
+
+// encryptedFile represents the content of the encrypted token file.
+// The key is derived from the passphrase by scrypt and the token is sealed by NaCl secretbox.
+type encryptedFile struct {
+	Salt  []byte `json:"salt"`
+	Nonce []byte `json:"nonce"`
+	Box   []byte `json:"box"`
+}
+
+// scrypt parameters recommended for interactive logins.
+var scryptN, scryptR, scryptP = 32768, 8, 1
+
+func deriveKey(passphrase, salt []byte) (*[32]byte, error) {
+	b, err := scrypt.Key(passphrase, salt, scryptN, scryptR, scryptP, 32)
+	if err != nil {
+		return nil, err
+	}
+	var key [32]byte
+	copy(key[:], b)
+	return &key, nil
+}
+
+// encryptedFileTokenStore stores the token in the file encrypted with the passphrase.
+// The passphrase is read once and kept for the process.
+type encryptedFileTokenStore struct {
+	name       string
+	passphrase func(name string) ([]byte, error)
+
+	mu     sync.Mutex
+	cached []byte
+}
+
+// getPassphrase returns the cached passphrase or reads it.
+func (s *encryptedFileTokenStore) getPassphrase() ([]byte, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.cached != nil {
+		return s.cached, nil
+	}
+	b, err := s.passphrase(s.name)
+	if err != nil {
+		return nil, err
+	}
+	s.cached = b
+	return b, nil
+}
+
+// forgetPassphrase removes the cached passphrase, e.g. it is wrong.
+func (s *encryptedFileTokenStore) forgetPassphrase() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.cached = nil
+}
+
+func (s *encryptedFileTokenStore) Load() (*oauth2.Token, error) {
+	p, err := homedir.Expand(s.name)
+	if err != nil {
+		return nil, fmt.Errorf("Could not expand %s: %s", s.name, err)
+	}
+	b, err := ioutil.ReadFile(p)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	var f encryptedFile
+	if err := json.Unmarshal(b, &f); err != nil {
+		return nil, fmt.Errorf("Invalid json: %s", err)
+	}
+	if len(f.Nonce) != 24 {
+		return nil, fmt.Errorf("Invalid nonce")
+	}
+	passphrase, err := s.getPassphrase()
+	if err != nil {
+		return nil, err
+	}
+	key, err := deriveKey(passphrase, f.Salt)
+	if err != nil {
+		return nil, fmt.Errorf("Could not derive the key: %s", err)
+	}
+	var nonce [24]byte
+	copy(nonce[:], f.Nonce)
+	plain, ok := secretbox.Open(nil, f.Box, &nonce, key)
+	if !ok {
+		s.forgetPassphrase()
+		return nil, fmt.Errorf("Could not decrypt %s: wrong passphrase or broken file", s.name)
+	}
+	return decodeTokenJSON(string(plain))
+}
+
+func (s *encryptedFileTokenStore) Save(token *oauth2.Token) error {
+	p, err := homedir.Expand(s.name)
+	if err != nil {
+		return fmt.Errorf("Could not expand %s: %s", s.name, err)
+	}
+	plain, err := json.Marshal(token)
+	if err != nil {
+		return fmt.Errorf("Could not encode the token: %s", err)
+	}
+	passphrase, err := s.getPassphrase()
+	if err != nil {
+		return err
+	}
+	f := encryptedFile{Salt: make([]byte, 16), Nonce: make([]byte, 24)}
+	if _, err := io.ReadFull(rand.Reader, f.Salt); err != nil {
+		return err
+	}
+	if _, err := io.ReadFull(rand.Reader, f.Nonce); err != nil {
+		return err
+	}
+	key, err := deriveKey(passphrase, f.Salt)
+	if err != nil {
+		return fmt.Errorf("Could not derive the key: %s", err)
+	}
+	var nonce [24]byte
+	copy(nonce[:], f.Nonce)
+	f.Box = secretbox.Seal(nil, plain, &nonce, key)
+	b, err := json.Marshal(&f)
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(p, b, 0600)
+}
+
+func (s *encryptedFileTokenStore) String() string {
+	return s.name
+}
+
+// readPassphrase returns GPUP_TOKEN_PASSPHRASE or reads it from the terminal.
+func readPassphrase(name string) ([]byte, error) {
+	if v, ok := os.LookupEnv("GPUP_TOKEN_PASSPHRASE"); ok {
+		return []byte(v), nil
+	}
+	fd := int(os.Stdin.Fd())
+	if !terminal.IsTerminal(fd) {
+		return nil, fmt.Errorf("Set GPUP_TOKEN_PASSPHRASE to decrypt %s", name)
+	}
+	fmt.Fprintf(os.Stderr, "Passphrase for %s: ", name)
+	b, err := terminal.ReadPassword(fd)
+	fmt.Fprintln(os.Stderr)
+	if err != nil {
+		return nil, fmt.Errorf("Could not read the passphrase: %s", err)
+	}
+	return b, nil
+}
+
+// writeFileAtomic writes the content to a temporary file and renames it to the name.
+func writeFileAtomic(name string, b []byte, perm os.FileMode) error {
+	f, err := ioutil.TempFile(filepath.Dir(name), "."+filepath.Base(name)+".tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name())
+	if _, err := f.Write(b); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Sync(); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	if err := os.Chmod(f.Name(), perm); err != nil {
+		return err
+	}
+	return os.Rename(f.Name(), name)
+}
+
+// helperTokenStore stores the token by the external command like git credential helper.
+//
+// The command is called with an action and key=value lines on stdin:
+//
+//	COMMAND get    reads client-id (and profile) and prints token=JSON, or nothing if not stored
+//	COMMAND store  reads client-id (and profile) and token=JSON
+type helperTokenStore struct {
+	command  string
+	clientID string
+	profile  string
+}
+
+// keys returns the lines to identify the token.
+func (s *helperTokenStore) keys() []string {
+	keys := []string{"client-id=" + s.clientID}
+	if s.profile != "" {
+		keys = append(keys, "profile="+s.profile)
+	}
+	return keys
+}
+
+func (s *helperTokenStore) run(action string, lines ...string) (map[string]string, error) {
+	var stdin bytes.Buffer
+	for _, line := range lines {
+		fmt.Fprintln(&stdin, line)
+	}
+	var cmd *exec.Cmd
+	if runtime.GOOS == "windows" {
+		cmd = exec.Command("cmd", "/C", s.command+" "+action)
+	} else {
+		cmd = exec.Command("sh", "-c", s.command+" "+action)
+	}
+	cmd.Stdin = &stdin
+	cmd.Stderr = os.Stderr
+	out, err := cmd.Output()
+	if err != nil {
+		return nil, fmt.Errorf("Error while running the helper %s %s: %s", s.command, action, err)
+	}
+	output := make(map[string]string)
+	scanner := bufio.NewScanner(bytes.NewReader(out))
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		kv := strings.SplitN(scanner.Text(), "=", 2)
+		if len(kv) == 2 {
+			output[kv[0]] = kv[1]
+		}
+	}
+	return output, scanner.Err()
+}
+
+func (s *helperTokenStore) Load() (*oauth2.Token, error) {
+	out, err := s.run("get", s.keys()...)
+	if err != nil {
+		return nil, err
+	}
+	return decodeTokenJSON(out["token"])
+}
+
+func (s *helperTokenStore) Save(token *oauth2.Token) error {
+	b, err := json.Marshal(token)
+	if err != nil {
+		return fmt.Errorf("Could not encode the token: %s", err)
+	}
+	_, err = s.run("store", append(s.keys(), "token="+string(b))...)
+	return err
+}
+
+func (s *helperTokenStore) String() string {
+	return "helper " + s.command
+}
@@ -0,0 +1,305 @@
+package cli
+
+import (
+	"context"
+	"encoding/hex"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"reflect"
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+
+	"golang.org/x/oauth2"
+)
+
+func init() {
+	// make tests fast
+	scryptN = 1024
+}
+
+var testToken = &oauth2.Token{
+	AccessToken:  "ACCESS",
+	TokenType:    "Bearer",
+	RefreshToken: "REFRESH",
+	Expiry:       time.Date(2019, 1, 2, 3, 4, 5, 0, time.UTC),
+}
+
+func fixedPassphrase(p string) func(string) ([]byte, error) {
+	return func(string) ([]byte, error) { return []byte(p), nil }
+}
+
+func TestEncryptedFileTokenStore(t *testing.T) {
+	dir, err := ioutil.TempDir("", "token")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	name := filepath.Join(dir, "token")
+
+	s := &encryptedFileTokenStore{name: name, passphrase: fixedPassphrase("secret")}
+	token, err := s.Load()
+	if err != nil {
+		t.Fatalf("Load returned error: %s", err)
+	}
+	if token != nil {
+		t.Errorf("token wants nil but %+v", token)
+	}
+	if err := s.Save(testToken); err != nil {
+		t.Fatalf("Save returned error: %s", err)
+	}
+	b, err := ioutil.ReadFile(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(string(b), "REFRESH") {
+		t.Errorf("file wants encrypted but %s", string(b))
+	}
+	token, err = s.Load()
+	if err != nil {
+		t.Fatalf("Load returned error: %s", err)
+	}
+	if !reflect.DeepEqual(token, testToken) {
+		t.Errorf("token wants %+v but %+v", testToken, token)
+	}
+
+	wrong := &encryptedFileTokenStore{name: name, passphrase: fixedPassphrase("wrong")}
+	if _, err := wrong.Load(); err == nil {
+		t.Errorf("Load wants error for wrong passphrase")
+	}
+}
+
+func TestEncryptedFileTokenStore_CachePassphrase(t *testing.T) {
+	dir, err := ioutil.TempDir("", "token")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	var calls int
+	s := &encryptedFileTokenStore{name: filepath.Join(dir, "token"), passphrase: func(string) ([]byte, error) {
+		calls++
+		return []byte("secret"), nil
+	}}
+	for i := 0; i < 3; i++ {
+		if err := s.Save(testToken); err != nil {
+			t.Fatalf("Save returned error: %s", err)
+		}
+	}
+	if _, err := s.Load(); err != nil {
+		t.Fatalf("Load returned error: %s", err)
+	}
+	if calls != 1 {
+		t.Errorf("passphrase wants 1 call but %d", calls)
+	}
+}
+
+func TestKeyringTokenStore(t *testing.T) {
+	if runtime.GOOS != "linux" {
+		t.Skip("secret-tool is used only on Linux")
+	}
+	var stored string
+	s := &keyringTokenStore{
+		account: "CLIENT_ID",
+		run: func(stdin string, name string, arg ...string) (string, error) {
+			cmd := name + " " + strings.Join(arg, " ")
+			switch cmd {
+			case "secret-tool lookup service gpup account CLIENT_ID":
+				if stored == "" {
+					return "", &exec.ExitError{}
+				}
+				return stored + "\n", nil
+			case "secret-tool store --label=gpup token service gpup account CLIENT_ID":
+				stored = stdin
+				return "", nil
+			}
+			t.Fatalf("Unexpected command %s", cmd)
+			return "", nil
+		},
+	}
+	token, err := s.Load()
+	if err != nil {
+		t.Fatalf("Load returned error: %s", err)
+	}
+	if token != nil {
+		t.Errorf("token wants nil but %+v", token)
+	}
+	if err := s.Save(testToken); err != nil {
+		t.Fatalf("Save returned error: %s", err)
+	}
+	token, err = s.Load()
+	if err != nil {
+		t.Fatalf("Load returned error: %s", err)
+	}
+	if !reflect.DeepEqual(token, testToken) {
+		t.Errorf("token wants %+v but %+v", testToken, token)
+	}
+}
+
+func TestKeyringTokenStore_Darwin(t *testing.T) {
+	var stored string
+	s := &keyringTokenStore{
+		account: "work:CLIENT_ID",
+		goos:    "darwin",
+		run: func(stdin string, name string, arg ...string) (string, error) {
+			cmd := name + " " + strings.Join(arg, " ")
+			if strings.Contains(cmd, "REFRESH") {
+				t.Errorf("arguments must not contain the token: %s", cmd)
+			}
+			switch cmd {
+			case "security find-generic-password -s gpup -a work:CLIENT_ID -w":
+				if stored == "" {
+					return "", &exec.ExitError{}
+				}
+				return stored + "\n", nil
+			case "security -i":
+				var hexToken string
+				if _, err := fmt.Sscanf(stdin, "add-generic-password -U -s gpup -a \"work:CLIENT_ID\" -X %s\n", &hexToken); err != nil {
+					t.Fatalf("Unexpected stdin %q: %s", stdin, err)
+				}
+				b, err := hex.DecodeString(hexToken)
+				if err != nil {
+					t.Fatalf("Invalid hex %s: %s", hexToken, err)
+				}
+				stored = string(b)
+				return "", nil
+			}
+			t.Fatalf("Unexpected command %s", cmd)
+			return "", nil
+		},
+	}
+	if err := s.Save(testToken); err != nil {
+		t.Fatalf("Save returned error: %s", err)
+	}
+	token, err := s.Load()
+	if err != nil {
+		t.Fatalf("Load returned error: %s", err)
+	}
+	if !reflect.DeepEqual(token, testToken) {
+		t.Errorf("token wants %+v but %+v", testToken, token)
+	}
+}
+
+func TestHelperTokenStore(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("helper script is written in sh")
+	}
+	dir, err := ioutil.TempDir("", "token")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	helper := filepath.Join(dir, "helper")
+	script := `#!/bin/sh
+case "$1" in
+get) grep -q client-id=CLIENT_ID && cat "` + dir + `/stored" 2>/dev/null; true ;;
+store) grep '^token=' > "` + dir + `/stored" ;;
+esac
+`
+	if err := ioutil.WriteFile(helper, []byte(script), 0700); err != nil {
+		t.Fatal(err)
+	}
+
+	s := &helperTokenStore{command: helper, clientID: "CLIENT_ID"}
+	token, err := s.Load()
+	if err != nil {
+		t.Fatalf("Load returned error: %s", err)
+	}
+	if token != nil {
+		t.Errorf("token wants nil but %+v", token)
+	}
+	if err := s.Save(testToken); err != nil {
+		t.Fatalf("Save returned error: %s", err)
+	}
+	token, err = s.Load()
+	if err != nil {
+		t.Fatalf("Load returned error: %s", err)
+	}
+	if !reflect.DeepEqual(token, testToken) {
+		t.Errorf("token wants %+v but %+v", testToken, token)
+	}
+}
+
+func TestCLI_loadToken_Migrate(t *testing.T) {
+	dir, err := ioutil.TempDir("", "token")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	encoded, err := EncodeToken(testToken)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var c CLI
+	c.ConfigName = filepath.Join(dir, "gpupconfig")
+	c.ExternalConfig.EncodedToken = encoded
+	store := &encryptedFileTokenStore{name: filepath.Join(dir, "token"), passphrase: fixedPassphrase("secret")}
+
+	token, err := c.loadToken(store)
+	if err != nil {
+		t.Fatalf("loadToken returned error: %s", err)
+	}
+	if !reflect.DeepEqual(token, testToken) {
+		t.Errorf("token wants %+v but %+v", testToken, token)
+	}
+	if c.ExternalConfig.EncodedToken != "" {
+		t.Errorf("EncodedToken wants empty but %s", c.ExternalConfig.EncodedToken)
+	}
+	var cfg ExternalConfig
+	if err := cfg.Read(c.ConfigName); err != nil {
+		t.Fatal(err)
+	}
+	if cfg.EncodedToken != "" {
+		t.Errorf("EncodedToken in gpupconfig wants empty but %s", cfg.EncodedToken)
+	}
+	token, err = store.Load()
+	if err != nil {
+		t.Fatalf("Load returned error: %s", err)
+	}
+	if !reflect.DeepEqual(token, testToken) {
+		t.Errorf("token in store wants %+v but %+v", testToken, token)
+	}
+}
+
+func TestCLI_newTokenStore(t *testing.T) {
+	for _, storage := range []string{"", "config", "keyring", "encrypted-file"} {
+		var c CLI
+		c.ExternalConfig.TokenStorage = storage
+		if _, err := c.newTokenStore(); err != nil {
+			t.Errorf("newTokenStore(%s) returned error: %s", storage, err)
+		}
+	}
+	for _, storage := range []string{"helper", "unknown"} {
+		var c CLI
+		c.ExternalConfig.TokenStorage = storage
+		if _, err := c.newTokenStore(); err == nil {
+			t.Errorf("newTokenStore(%s) wants error", storage)
+		}
+	}
+}
+
+func TestCLI_newOAuth2Client_ReadPassphraseFirst(t *testing.T) {
+	if v, ok := os.LookupEnv("GPUP_TOKEN_PASSPHRASE"); ok {
+		defer os.Setenv("GPUP_TOKEN_PASSPHRASE", v)
+		os.Unsetenv("GPUP_TOKEN_PASSPHRASE")
+	}
+	dir, err := ioutil.TempDir("", "token")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	var c CLI
+	c.ConfigName = filepath.Join(dir, "gpupconfig")
+	c.ExternalConfig.TokenStorage = "encrypted-file"
+	c.ExternalConfig.TokenFile = filepath.Join(dir, "token")
+	c.ExternalConfig.AuthFlow = "unknown"
+	// stdin of the test is not a terminal
+	_, err = c.newOAuth2Client(context.Background())
+	if err == nil || !strings.Contains(err.Error(), "GPUP_TOKEN_PASSPHRASE") {
+		t.Errorf("newOAuth2Client wants error of the passphrase before the auth flow but %v", err)
+	}
+}
@@ -0,0 +1,484 @@
+package cli
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"mime"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	homedir "github.com/mitchellh/go-homedir"
+)
+
+// Limits of bodies in the debug logs and HAR file.
+const (
+	debugBodyLimit = 4 * 1024
+	harBodyLimit   = 64 * 1024
+)
+
+const redacted = "REDACTED"
+
+// secretHeaders is the set of headers redacted in traces.
+// In addition, a header is redacted if the name contains one of secretHeaderWords.
+var secretHeaders = map[string]bool{
+	"Authorization":        true,
+	"Proxy-Authorization":  true,
+	"Cookie":               true,
+	"Set-Cookie":           true,
+	"X-Amz-Security-Token": true,
+}
+
+// secretHeaderWords is the words of custom headers redacted in traces,
+// e.g. X-API-Key or Private-Token given by --request-header or http-credentials.
+var secretHeaderWords = []string{"token", "key", "secret", "auth", "password", "session"}
+
+// isSecretHeader returns true if the header should be redacted.
+func isSecretHeader(name string) bool {
+	if secretHeaders[http.CanonicalHeaderKey(name)] {
+		return true
+	}
+	lower := strings.ToLower(name)
+	for _, word := range secretHeaderWords {
+		if strings.Contains(lower, word) {
+			return true
+		}
+	}
+	return false
+}
+
+// secretParams is the set of query parameters and form values redacted in traces.
+var secretParams = map[string]bool{
+	"access_token":         true,
+	"refresh_token":        true,
+	"id_token":             true,
+	"client_secret":        true,
+	"code":                 true,
+	"device_code":          true,
+	"code_verifier":        true,
+	"password":             true,
+	"key":                  true,
+	"token":                true,
+	"shareToken":           true,
+	"X-Amz-Credential":     true,
+	"X-Amz-Signature":      true,
+	"X-Amz-Security-Token": true,
+}
+
+// secretJSONKeys is the set of keys redacted in JSON bodies.
+// Note that code is not here, because it is a status code in the API.
+var secretJSONKeys = map[string]bool{
+	"access_token":  true,
+	"refresh_token": true,
+	"id_token":      true,
+	"client_secret": true,
+	"device_code":   true,
+	"password":      true,
+	"shareToken":    true,
+}
+
+// traceTransport logs and records requests and responses with the secrets redacted.
+type traceTransport struct {
+	transport http.RoundTripper
+	log       bool         // log requests and responses
+	body      bool         // include JSON bodies in the logs
+	har       *harRecorder // record requests and responses if set
+}
+
+func (t *traceTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	start := time.Now()
+	reqBody, err := readRequestBody(req)
+	if err != nil {
+		logger.Warn("Could not read the request body", "error", err)
+	}
+	if t.log {
+		args := []interface{}{"method", req.Method, "url", redactURL(req.URL), "headers", formatHeader(req.Header)}
+		if t.body && reqBody != nil {
+			args = append(args, "body", truncate(redactBody(req.Header.Get("Content-Type"), reqBody), debugBodyLimit))
+		}
+		logger.Debug("Request", args...)
+	}
+
+	res, err := t.transport.RoundTrip(req)
+	elapsed := time.Since(start)
+	var resBody []byte
+	if res != nil {
+		var readErr error
+		resBody, readErr = readResponseBody(res)
+		if readErr != nil {
+			logger.Warn("Could not read the response body", "error", readErr)
+		}
+	}
+	if t.log {
+		switch {
+		case err != nil:
+			logger.Debug("Response", "method", req.Method, "url", redactURL(req.URL), "error", err, "duration", elapsed)
+		default:
+			args := []interface{}{"method", req.Method, "url", redactURL(req.URL), "status", res.Status, "headers", formatHeader(res.Header), "duration", elapsed}
+			if t.body && resBody != nil {
+				args = append(args, "body", truncate(redactBody(res.Header.Get("Content-Type"), resBody), debugBodyLimit))
+			}
+			logger.Debug("Response", args...)
+		}
+	}
+	if t.har != nil {
+		t.har.add(newHAREntry(start, elapsed, req, reqBody, res, resBody))
+	}
+	return res, err
+}
+
+// isTextBody returns true if the body can be shown, i.e. JSON or form.
+func isTextBody(contentType string) bool {
+	mediaType, _, _ := mime.ParseMediaType(contentType)
+	return mediaType == "application/json" || mediaType == "application/x-www-form-urlencoded" ||
+		strings.HasSuffix(mediaType, "+json")
+}
+
+// readRequestBody returns the body if it is JSON or form.
+// It does not consume the body of the request.
+func readRequestBody(req *http.Request) ([]byte, error) {
+	if req.Body == nil || req.GetBody == nil || !isTextBody(req.Header.Get("Content-Type")) {
+		return nil, nil
+	}
+	r, err := req.GetBody()
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+	return ioutil.ReadAll(r)
+}
+
+// readResponseBody returns the body if it is JSON.
+// It replaces the body of the response with the buffer.
+func readResponseBody(res *http.Response) ([]byte, error) {
+	if res.Body == nil || !isTextBody(res.Header.Get("Content-Type")) {
+		return nil, nil
+	}
+	b, err := ioutil.ReadAll(res.Body)
+	res.Body.Close()
+	res.Body = ioutil.NopCloser(bytes.NewReader(b))
+	return b, err
+}
+
+// redactHeader returns a copy of the header with the secrets redacted.
+// The scheme of the authorization header is kept, e.g. Bearer REDACTED.
+func redactHeader(h http.Header) http.Header {
+	r := make(http.Header, len(h))
+	for k, values := range h {
+		if !isSecretHeader(k) {
+			r[k] = values
+			continue
+		}
+		for _, v := range values {
+			if i := strings.Index(v, " "); i > 0 && strings.HasSuffix(k, "Authorization") {
+				r[k] = append(r[k], v[:i+1]+redacted)
+			} else {
+				r[k] = append(r[k], redacted)
+			}
+		}
+	}
+	return r
+}
+
+// formatHeader returns the redacted header in lines of KEY: VALUE.
+func formatHeader(h http.Header) string {
+	var lines []string
+	for _, nv := range harHeaders(h) {
+		lines = append(lines, nv.Name+": "+nv.Value)
+	}
+	return strings.Join(lines, "\n")
+}
+
+// redactURL returns the URL with the secret query parameters redacted.
+func redactURL(u *url.URL) string {
+	if u.RawQuery == "" {
+		return u.String()
+	}
+	r := *u
+	r.RawQuery = redactValues(u.Query()).Encode()
+	return r.String()
+}
+
+func redactValues(values url.Values) url.Values {
+	r := make(url.Values, len(values))
+	for k, v := range values {
+		if secretParams[k] {
+			r[k] = []string{redacted}
+		} else {
+			r[k] = v
+		}
+	}
+	return r
+}
+
+// redactBody returns the JSON or form body with the secrets redacted.
+func redactBody(contentType string, b []byte) string {
+	mediaType, _, _ := mime.ParseMediaType(contentType)
+	if mediaType == "application/x-www-form-urlencoded" {
+		values, err := url.ParseQuery(string(b))
+		if err != nil {
+			return "(unparsable form)"
+		}
+		return redactValues(values).Encode()
+	}
+	var v interface{}
+	if err := json.Unmarshal(b, &v); err != nil {
+		return "(unparsable JSON)"
+	}
+	r, err := json.Marshal(redactJSON(v))
+	if err != nil {
+		return "(unparsable JSON)"
+	}
+	return string(r)
+}
+
+func redactJSON(v interface{}) interface{} {
+	switch v := v.(type) {
+	case map[string]interface{}:
+		for k, e := range v {
+			if secretJSONKeys[k] {
+				v[k] = redacted
+			} else {
+				v[k] = redactJSON(e)
+			}
+		}
+	case []interface{}:
+		for i, e := range v {
+			v[i] = redactJSON(e)
+		}
+	}
+	return v
+}
+
+func truncate(s string, n int) string {
+	if len(s) <= n {
+		return s
+	}
+	return fmt.Sprintf("%s...(truncated %d bytes)", s[:n], len(s)-n)
+}
+
+// harRecorder records requests and responses in HTTP Archive format.
+// See http://www.softwareishard.com/blog/har-12-spec/
+// Each entry is written to the file as soon as it is recorded,
+// so that a long-running command does not keep the entries in memory.
+// The file is a valid HAR after each entry.
+type harRecorder struct {
+	mu      sync.Mutex
+	version string
+	file    *os.File
+	end     int64 // offset of harFooter
+	count   int
+	failed  bool // logged a write error
+}
+
+// harFooter closes the entries and the log.
+const harFooter = "\n]}}\n"
+
+type harLog struct {
+	Log struct {
+		Version string      `json:"version"`
+		Creator harCreator  `json:"creator"`
+		Entries []*harEntry `json:"entries"`
+	} `json:"log"`
+}
+
+type harCreator struct {
+	Name    string `json:"name"`
+	Version string `json:"version"`
+}
+
+type harEntry struct {
+	StartedDateTime string      `json:"startedDateTime"`
+	Time            float64     `json:"time"`
+	Request         harRequest  `json:"request"`
+	Response        harResponse `json:"response"`
+	Cache           struct{}    `json:"cache"`
+	Timings         harTimings  `json:"timings"`
+}
+
+type harRequest struct {
+	Method      string         `json:"method"`
+	URL         string         `json:"url"`
+	HTTPVersion string         `json:"httpVersion"`
+	Cookies     []harNameValue `json:"cookies"`
+	Headers     []harNameValue `json:"headers"`
+	QueryString []harNameValue `json:"queryString"`
+	PostData    *harPostData   `json:"postData,omitempty"`
+	HeadersSize int64          `json:"headersSize"`
+	BodySize    int64          `json:"bodySize"`
+}
+
+type harResponse struct {
+	Status      int            `json:"status"`
+	StatusText  string         `json:"statusText"`
+	HTTPVersion string         `json:"httpVersion"`
+	Cookies     []harNameValue `json:"cookies"`
+	Headers     []harNameValue `json:"headers"`
+	Content     harContent     `json:"content"`
+	RedirectURL string         `json:"redirectURL"`
+	HeadersSize int64          `json:"headersSize"`
+	BodySize    int64          `json:"bodySize"`
+	Comment     string         `json:"comment,omitempty"`
+}
+
+type harNameValue struct {
+	Name  string `json:"name"`
+	Value string `json:"value"`
+}
+
+type harPostData struct {
+	MimeType string `json:"mimeType"`
+	Text     string `json:"text"`
+}
+
+type harContent struct {
+	Size     int64  `json:"size"`
+	MimeType string `json:"mimeType"`
+	Text     string `json:"text,omitempty"`
+}
+
+type harTimings struct {
+	Send    float64 `json:"send"`
+	Wait    float64 `json:"wait"`
+	Receive float64 `json:"receive"`
+}
+
+func newHAREntry(start time.Time, elapsed time.Duration, req *http.Request, reqBody []byte, res *http.Response, resBody []byte) *harEntry {
+	ms := float64(elapsed) / float64(time.Millisecond)
+	e := &harEntry{
+		StartedDateTime: start.Format(time.RFC3339Nano),
+		Time:            ms,
+		Request: harRequest{
+			Method:      req.Method,
+			URL:         redactURL(req.URL),
+			HTTPVersion: "HTTP/1.1",
+			Cookies:     []harNameValue{},
+			Headers:     harHeaders(req.Header),
+			QueryString: harQuery(req.URL.Query()),
+			HeadersSize: -1,
+			BodySize:    req.ContentLength,
+		},
+		Timings: harTimings{Wait: ms},
+	}
+	if req.Body != nil {
+		e.Request.PostData = &harPostData{MimeType: req.Header.Get("Content-Type")}
+		if reqBody != nil {
+			e.Request.PostData.Text = truncate(redactBody(req.Header.Get("Content-Type"), reqBody), harBodyLimit)
+		}
+	}
+	if res == nil {
+		// no response such as a network error
+		e.Response = harResponse{Cookies: []harNameValue{}, Headers: []harNameValue{}, HeadersSize: -1, BodySize: -1, Comment: "no response"}
+		return e
+	}
+	e.Response = harResponse{
+		Status:      res.StatusCode,
+		StatusText:  strings.TrimSpace(strings.TrimPrefix(res.Status, fmt.Sprint(res.StatusCode))),
+		HTTPVersion: res.Proto,
+		Cookies:     []harNameValue{},
+		Headers:     harHeaders(res.Header),
+		Content:     harContent{Size: res.ContentLength, MimeType: res.Header.Get("Content-Type")},
+		HeadersSize: -1,
+		BodySize:    res.ContentLength,
+	}
+	if resBody != nil {
+		e.Response.Content.Size = int64(len(resBody))
+		e.Response.Content.Text = truncate(redactBody(res.Header.Get("Content-Type"), resBody), harBodyLimit)
+	}
+	return e
+}
+
+func harHeaders(h http.Header) []harNameValue {
+	return harNameValues(redactHeader(h))
+}
+
+func harQuery(values url.Values) []harNameValue {
+	return harNameValues(redactValues(values))
+}
+
+func harNameValues(m map[string][]string) []harNameValue {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	nv := []harNameValue{}
+	for _, k := range keys {
+		for _, v := range m[k] {
+			nv = append(nv, harNameValue{k, v})
+		}
+	}
+	return nv
+}
+
+// Open creates the file and writes the log without entries.
+func (r *harRecorder) Open(name string) error {
+	p, err := homedir.Expand(name)
+	if err != nil {
+		return fmt.Errorf("Could not expand %s: %s", name, err)
+	}
+	creator, err := json.Marshal(harCreator{Name: "gpup", Version: r.version})
+	if err != nil {
+		return fmt.Errorf("Could not encode HAR: %s", err)
+	}
+	f, err := os.OpenFile(p, os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0600)
+	if err != nil {
+		return fmt.Errorf("Could not open %s: %s", name, err)
+	}
+	header := `{"log":{"version":"1.2","creator":` + string(creator) + `,"entries":[`
+	if _, err := f.WriteString(header + harFooter); err != nil {
+		f.Close()
+		return fmt.Errorf("Could not write to %s: %s", name, err)
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.file, r.end = f, int64(len(header))
+	return nil
+}
+
+// add writes the entry to the file in place of the footer.
+// It does nothing if the file is not open.
+func (r *harRecorder) add(e *harEntry) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.file == nil {
+		return
+	}
+	b, err := json.Marshal(e)
+	if err != nil {
+		logger.Warn("Could not encode HAR", "error", err)
+		return
+	}
+	if r.count > 0 {
+		b = append([]byte(",\n"), b...)
+	} else {
+		b = append([]byte("\n"), b...)
+	}
+	if _, err := r.file.WriteAt(append(b, harFooter...), r.end); err != nil {
+		if !r.failed {
+			logger.Warn("Could not write the trace", "path", r.file.Name(), "error", err)
+			r.failed = true
+		}
+		return
+	}
+	r.end += int64(len(b))
+	r.count++
+}
+
+// Close closes the file.
+func (r *harRecorder) Close() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.file == nil {
+		return nil
+	}
+	err := r.file.Close()
+	r.file = nil
+	return err
+}
@@ -0,0 +1,203 @@
+package cli
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/int128/gpup/logging"
+)
+
+func TestRedactHeader(t *testing.T) {
+	h := http.Header{
+		"Authorization": {"Bearer SECRET"},
+		"Cookie":        {"SID=SECRET"},
+		"Content-Type":  {"application/json"},
+		"X-Api-Key":     {"SECRET"},
+		"Private-Token": {"SECRET"},
+		"X-Auth-User":   {"SECRET"},
+	}
+	want := http.Header{
+		"Authorization": {"Bearer REDACTED"},
+		"Cookie":        {"REDACTED"},
+		"Content-Type":  {"application/json"},
+		"X-Api-Key":     {"REDACTED"},
+		"Private-Token": {"REDACTED"},
+		"X-Auth-User":   {"REDACTED"},
+	}
+	if got := redactHeader(h); !reflect.DeepEqual(want, got) {
+		t.Errorf("redactHeader wants %v but %v", want, got)
+	}
+	if h.Get("Authorization") != "Bearer SECRET" {
+		t.Errorf("redactHeader must not modify the header but %v", h)
+	}
+}
+
+func TestRedactURL(t *testing.T) {
+	for _, c := range []struct {
+		url  string
+		want string
+	}{
+		{"https://example.com/v1/albums", "https://example.com/v1/albums"},
+		{"https://example.com/v1/albums?pageSize=50&access_token=SECRET", "https://example.com/v1/albums?access_token=REDACTED&pageSize=50"},
+		{"https://bucket.s3.amazonaws.com/a.jpg?X-Amz-Signature=SECRET", "https://bucket.s3.amazonaws.com/a.jpg?X-Amz-Signature=REDACTED"},
+	} {
+		u, err := url.Parse(c.url)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := redactURL(u); c.want != got {
+			t.Errorf("redactURL(%s) wants %s but %s", c.url, c.want, got)
+		}
+	}
+}
+
+func TestRedactBody(t *testing.T) {
+	for _, c := range []struct {
+		contentType string
+		body        string
+		want        string
+	}{
+		{"application/json; charset=UTF-8", `{"access_token":"SECRET","expires_in":3600}`, `{"access_token":"REDACTED","expires_in":3600}`},
+		{"application/json", `{"error":{"code":400,"message":"Bad"}}`, `{"error":{"code":400,"message":"Bad"}}`},
+		{"application/json", `{"albums":[{"shareInfo":{"shareToken":"SECRET"}}]}`, `{"albums":[{"shareInfo":{"shareToken":"REDACTED"}}]}`},
+		{"application/x-www-form-urlencoded", "code=SECRET&grant_type=authorization_code", "code=REDACTED&grant_type=authorization_code"},
+		{"application/json", `{`, "(unparsable JSON)"},
+	} {
+		if got := redactBody(c.contentType, []byte(c.body)); c.want != got {
+			t.Errorf("redactBody(%s) wants %s but %s", c.body, c.want, got)
+		}
+	}
+}
+
+func TestTruncate(t *testing.T) {
+	if got := truncate("abcdef", 10); got != "abcdef" {
+		t.Errorf("truncate wants abcdef but %s", got)
+	}
+	if got := truncate("abcdef", 4); got != "abcd...(truncated 2 bytes)" {
+		t.Errorf("truncate wants abcd...(truncated 2 bytes) but %s", got)
+	}
+}
+
+func TestTraceTransport(t *testing.T) {
+	defer func(restore logging.Logger) { logger = restore }(logger)
+	var logs bytes.Buffer
+	logger = logging.Must(logging.New(&logs, logging.Text, logging.LevelDebug))
+
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		b, _ := ioutil.ReadAll(r.Body)
+		if string(b) != "client_secret=SECRET&grant_type=refresh_token" {
+			t.Errorf("body wants the form but %s", b)
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"access_token":"SECRET","token_type":"Bearer"}`))
+	}))
+	defer s.Close()
+
+	dir, err := ioutil.TempDir("", "trace")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	name := filepath.Join(dir, "trace.har")
+	har := &harRecorder{version: "1.0.0"}
+	if err := har.Open(name); err != nil {
+		t.Fatalf("Open returns error: %s", err)
+	}
+	client := &http.Client{Transport: &traceTransport{transport: http.DefaultTransport, log: true, body: true, har: har}}
+	req, err := http.NewRequest("POST", s.URL+"/token?access_token=SECRET", strings.NewReader("client_secret=SECRET&grant_type=refresh_token"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("Authorization", "Bearer SECRET")
+	req.Header.Set("X-API-Key", "SECRET")
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	res, err := client.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := ioutil.ReadAll(res.Body)
+	res.Body.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := `{"access_token":"SECRET","token_type":"Bearer"}`; string(b) != want {
+		t.Errorf("response body wants %s but %s", want, b)
+	}
+
+	if strings.Contains(logs.String(), "SECRET") {
+		t.Errorf("logs must not contain the secret:\n%s", logs.String())
+	}
+	for _, want := range []string{"Bearer REDACTED", "client_secret=REDACTED", `\"access_token\":\"REDACTED\"`} {
+		if !strings.Contains(logs.String(), want) {
+			t.Errorf("logs wants %s but:\n%s", want, logs.String())
+		}
+	}
+
+	if err := har.Close(); err != nil {
+		t.Fatalf("Close returns error: %s", err)
+	}
+	content, err := ioutil.ReadFile(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(string(content), "SECRET") {
+		t.Errorf("HAR must not contain the secret:\n%s", content)
+	}
+	var h harLog
+	if err := json.Unmarshal(content, &h); err != nil {
+		t.Fatalf("Could not decode HAR: %s", err)
+	}
+	if h.Log.Version != "1.2" || h.Log.Creator.Version != "1.0.0" || len(h.Log.Entries) != 1 {
+		t.Fatalf("HAR wants 1 entry but %+v", h.Log)
+	}
+	e := h.Log.Entries[0]
+	if e.Request.Method != "POST" || e.Request.URL != s.URL+"/token?access_token=REDACTED" {
+		t.Errorf("request wants POST %s/token but %s %s", s.URL, e.Request.Method, e.Request.URL)
+	}
+	if e.Request.PostData == nil || e.Request.PostData.Text != "client_secret=REDACTED&grant_type=refresh_token" {
+		t.Errorf("postData wants the redacted form but %+v", e.Request.PostData)
+	}
+	if e.Response.Status != 200 || e.Response.Content.Text != `{"access_token":"REDACTED","token_type":"Bearer"}` {
+		t.Errorf("response wants the redacted body but %+v", e.Response)
+	}
+}
+
+func TestHARRecorder_Stream(t *testing.T) {
+	dir, err := ioutil.TempDir("", "trace")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	name := filepath.Join(dir, "trace.har")
+	har := &harRecorder{version: "1.0.0"}
+	har.add(&harEntry{}) // ignored before Open
+	if err := har.Open(name); err != nil {
+		t.Fatalf("Open returns error: %s", err)
+	}
+	defer har.Close()
+	for i := 0; i <= 3; i++ {
+		// the file is valid after each entry
+		content, err := ioutil.ReadFile(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var h harLog
+		if err := json.Unmarshal(content, &h); err != nil {
+			t.Fatalf("Could not decode HAR: %s\n%s", err, content)
+		}
+		if len(h.Log.Entries) != i || h.Log.Version != "1.2" {
+			t.Fatalf("HAR wants %d entries but %+v", i, h.Log)
+		}
+		har.add(&harEntry{Request: harRequest{Method: "GET", URL: fmt.Sprintf("https://example.com/%d", i)}})
+	}
+}
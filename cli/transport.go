@@ -0,0 +1,99 @@
+package cli
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+
+	homedir "github.com/mitchellh/go-homedir"
+)
+
+// newTransport returns a transport with the proxy and TLS options in gpupconfig.
+// It returns http.DefaultTransport if no option is given.
+func (c *CLI) newTransport() (http.RoundTripper, error) {
+	cfg := c.ExternalConfig
+	if cfg.Proxy == "" && cfg.CABundle == "" && cfg.ClientCert == "" && cfg.ClientKey == "" {
+		return http.DefaultTransport, nil
+	}
+	t := http.DefaultTransport.(*http.Transport).Clone()
+	if cfg.Proxy != "" {
+		u, err := parseProxyURL(cfg.Proxy)
+		if err != nil {
+			return nil, fmt.Errorf("Invalid proxy: %s", err)
+		}
+		t.Proxy = http.ProxyURL(u)
+	}
+	var tlsConfig tls.Config
+	if cfg.CABundle != "" {
+		pool, err := loadCABundle(cfg.CABundle)
+		if err != nil {
+			return nil, fmt.Errorf("Could not load the CA bundle: %s", err)
+		}
+		tlsConfig.RootCAs = pool
+	}
+	if cfg.ClientCert != "" || cfg.ClientKey != "" {
+		if cfg.ClientCert == "" || cfg.ClientKey == "" {
+			return nil, fmt.Errorf("Both client certificate and key are required")
+		}
+		cert, err := loadClientCert(cfg.ClientCert, cfg.ClientKey)
+		if err != nil {
+			return nil, fmt.Errorf("Could not load the client certificate: %s", err)
+		}
+		tlsConfig.Certificates = []tls.Certificate{cert}
+	}
+	t.TLSClientConfig = &tlsConfig
+	return t, nil
+}
+
+// parseProxyURL parses the proxy URL.
+// The credentials in the URL are sent in Proxy-Authorization header.
+func parseProxyURL(rawurl string) (*url.URL, error) {
+	u, err := url.Parse(rawurl)
+	if err != nil {
+		return nil, fmt.Errorf("Could not parse the URL")
+	}
+	switch u.Scheme {
+	case "http", "https", "socks5":
+	default:
+		return nil, fmt.Errorf("Scheme must be http, https or socks5 but %s", u.Scheme)
+	}
+	if u.Host == "" {
+		return nil, fmt.Errorf("Host is missing")
+	}
+	return u, nil
+}
+
+// loadCABundle returns the system certificates and the certificates in the PEM file.
+func loadCABundle(name string) (*x509.CertPool, error) {
+	p, err := homedir.Expand(name)
+	if err != nil {
+		return nil, fmt.Errorf("Could not expand %s: %s", name, err)
+	}
+	b, err := ioutil.ReadFile(p)
+	if err != nil {
+		return nil, err
+	}
+	pool, err := x509.SystemCertPool()
+	if err != nil {
+		pool = x509.NewCertPool()
+	}
+	if !pool.AppendCertsFromPEM(b) {
+		return nil, fmt.Errorf("No certificate found in %s", name)
+	}
+	return pool, nil
+}
+
+func loadClientCert(certName, keyName string) (tls.Certificate, error) {
+	certFile, err := homedir.Expand(certName)
+	if err != nil {
+		return tls.Certificate{}, fmt.Errorf("Could not expand %s: %s", certName, err)
+	}
+	keyFile, err := homedir.Expand(keyName)
+	if err != nil {
+		return tls.Certificate{}, fmt.Errorf("Could not expand %s: %s", keyName, err)
+	}
+	return tls.LoadX509KeyPair(certFile, keyFile)
+}
@@ -0,0 +1,158 @@
+package cli
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io/ioutil"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func writePEM(t *testing.T, name, typ string, b []byte) {
+	t.Helper()
+	if err := ioutil.WriteFile(name, pem.EncodeToMemory(&pem.Block{Type: typ, Bytes: b}), 0600); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func get(t *testing.T, c *CLI, url string) (string, error) {
+	t.Helper()
+	client, err := c.newHTTPClient()
+	if err != nil {
+		t.Fatalf("newHTTPClient returns error: %s", err)
+	}
+	res, err := client.Get(url)
+	if err != nil {
+		return "", err
+	}
+	defer res.Body.Close()
+	b, err := ioutil.ReadAll(res.Body)
+	return string(b), err
+}
+
+func TestCLI_newTransport_Proxy(t *testing.T) {
+	proxy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if user, pass, ok := parseProxyAuthorization(r); !ok || user != "user" || pass != "pass" {
+			w.WriteHeader(http.StatusProxyAuthRequired)
+			return
+		}
+		w.Write([]byte("proxied " + r.URL.String()))
+	}))
+	defer proxy.Close()
+
+	c := CLI{ExternalConfig: ExternalConfig{Proxy: "http://user:pass@" + proxy.Listener.Addr().String()}}
+	body, err := get(t, &c, "http://photos.example.com/a.jpg")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if body != "proxied http://photos.example.com/a.jpg" {
+		t.Errorf("body wants proxied but %s", body)
+	}
+}
+
+func parseProxyAuthorization(r *http.Request) (string, string, bool) {
+	h := r.Header.Get("Proxy-Authorization")
+	r2 := &http.Request{Header: http.Header{"Authorization": {h}}}
+	return r2.BasicAuth()
+}
+
+func TestCLI_newTransport_TLS(t *testing.T) {
+	dir, err := ioutil.TempDir("", "transport")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	// self-signed client certificate
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "gpup"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, &template, &template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	clientCert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	writePEM(t, filepath.Join(dir, "client.crt"), "CERTIFICATE", der)
+	writePEM(t, filepath.Join(dir, "client.key"), "EC PRIVATE KEY", keyDER)
+
+	server := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(r.TLS.PeerCertificates[0].Subject.CommonName))
+	}))
+	clientCAs := x509.NewCertPool()
+	clientCAs.AddCert(clientCert)
+	server.TLS = &tls.Config{ClientAuth: tls.RequireAndVerifyClientCert, ClientCAs: clientCAs}
+	server.StartTLS()
+	defer server.Close()
+	writePEM(t, filepath.Join(dir, "ca.crt"), "CERTIFICATE", server.Certificate().Raw)
+
+	for _, c := range []struct {
+		name    string
+		config  ExternalConfig
+		success bool
+	}{
+		{"no CA", ExternalConfig{}, false},
+		{"no client certificate", ExternalConfig{CABundle: filepath.Join(dir, "ca.crt")}, false},
+		{"mTLS", ExternalConfig{
+			CABundle:   filepath.Join(dir, "ca.crt"),
+			ClientCert: filepath.Join(dir, "client.crt"),
+			ClientKey:  filepath.Join(dir, "client.key"),
+		}, true},
+	} {
+		t.Run(c.name, func(t *testing.T) {
+			cli := CLI{ExternalConfig: c.config}
+			body, err := get(t, &cli, server.URL)
+			if c.success {
+				if err != nil {
+					t.Fatal(err)
+				}
+				if body != "gpup" {
+					t.Errorf("body wants gpup but %s", body)
+				}
+			}
+			if !c.success && err == nil {
+				t.Errorf("Get wants error but nil")
+			}
+		})
+	}
+}
+
+func TestCLI_newTransport_Invalid(t *testing.T) {
+	for _, config := range []ExternalConfig{
+		{Proxy: "ftp://proxy.example.com"},
+		{Proxy: "http://"},
+		{CABundle: "/nonexistent"},
+		{ClientCert: "client.crt"},
+		{ClientKey: "client.key"},
+	} {
+		c := CLI{ExternalConfig: config}
+		if _, err := c.newTransport(); err == nil {
+			t.Errorf("newTransport(%+v) wants error but nil", config)
+		}
+	}
+}
@@ -3,50 +3,54 @@ package cli
 import (
 	"context"
 	"fmt"
-	"log"
 	"net/http"
+	"net/url"
 	"os"
 	"path/filepath"
 	"strings"
 
 	"github.com/int128/gpup/photos"
+	"github.com/int128/gpup/source"
 )
 
 func (c *CLI) upload(ctx context.Context) error {
-	if len(c.Paths) == 0 {
+	if len(c.Paths) == 0 && len(c.FromFiles) == 0 && len(c.Manifests) == 0 {
 		return fmt.Errorf("Nothing to upload")
 	}
-	uploadItems, err := c.findUploadItems()
+	if err := validateDryRun(c.DryRun); err != nil {
+		return err
+	}
+	uploadItems, err := c.findUploadItems(ctx)
 	if err != nil {
 		return err
 	}
 	if len(uploadItems) == 0 {
 		return fmt.Errorf("Nothing to upload in %s", strings.Join(c.Paths, ", "))
 	}
-	log.Printf("The following %d items will be uploaded:", len(uploadItems))
+	if err := sortUploadItems(uploadItems, c.Sort); err != nil {
+		return err
+	}
+	logger.Info("Found items to upload", "count", len(uploadItems))
 	for i, uploadItem := range uploadItems {
-		fmt.Fprintf(os.Stderr, "#%d: %s\n", i+1, uploadItem)
+		logger.Info("Found an item", "index", i+1, "item", uploadItem.String())
 	}
 
-	client, err := c.newOAuth2Client(ctx)
-	if err != nil {
-		return err
-	}
-	service, err := photos.New(client)
+	service, err := c.newPhotos(ctx)
 	if err != nil {
 		return err
 	}
-	var results []*photos.AddResult
-	switch {
-	case c.AlbumTitle != "":
-		results, err = service.AddToAlbum(ctx, c.AlbumTitle, uploadItems)
-	case c.NewAlbum != "":
-		results, err = service.CreateAlbum(ctx, c.NewAlbum, uploadItems)
-	default:
-		results = service.AddToLibrary(ctx, uploadItems)
+	if c.DryRun != "" {
+		return c.dryRun(ctx, service, uploadItems)
 	}
-	if err != nil {
-		return err
+	results := make([]*photos.AddResult, len(uploadItems))
+	for _, g := range groupByAlbum(uploadItems) {
+		r, err := c.add(ctx, service, g.album, g.items)
+		if err != nil {
+			return err
+		}
+		for i, index := range g.indexes {
+			results[index] = r[i]
+		}
 	}
 	for i, r := range results {
 		if r.Error != nil {
@@ -58,41 +62,273 @@ func (c *CLI) upload(ctx context.Context) error {
 	return nil
 }
 
-func (c *CLI) findUploadItems() ([]photos.UploadItem, error) {
-	client := c.newHTTPClient()
+func (c *CLI) add(ctx context.Context, service *photos.Photos, album string, uploadItems []photos.UploadItem) ([]*photos.AddResult, error) {
+	switch {
+	case album != "":
+		return service.AddToAlbum(ctx, album, uploadItems)
+	case c.AlbumID != "":
+		return service.AddToAlbumByID(ctx, c.AlbumID, uploadItems)
+	case c.ShareToken != "":
+		return service.AddToSharedAlbum(ctx, c.ShareToken, uploadItems)
+	case c.AlbumTitle != "":
+		return service.AddToAlbum(ctx, c.AlbumTitle, uploadItems)
+	case c.NewAlbum != "":
+		return service.CreateAlbum(ctx, c.NewAlbum, uploadItems)
+	default:
+		return service.AddToLibrary(ctx, uploadItems), nil
+	}
+}
+
+// albumGroup represents a set of items to be added to the same album.
+type albumGroup struct {
+	album   string
+	items   []photos.UploadItem
+	indexes []int
+}
+
+// groupByAlbum splits the items by album, preserving the order.
+func groupByAlbum(uploadItems []photos.UploadItem) []*albumGroup {
+	var groups []*albumGroup
+	m := make(map[string]*albumGroup)
+	for i, item := range uploadItems {
+		album := albumOf(item)
+		g, ok := m[album]
+		if !ok {
+			g = &albumGroup{album: album}
+			m[album] = g
+			groups = append(groups, g)
+		}
+		g.items = append(g.items, item)
+		g.indexes = append(g.indexes, i)
+	}
+	return groups
+}
+
+func (c *CLI) findUploadItems(ctx context.Context) ([]photos.UploadItem, error) {
+	entries, err := c.findManifestEntries()
+	if err != nil {
+		return nil, err
+	}
+	src, err := c.newHTTPSource()
+	if err != nil {
+		return nil, err
+	}
 	uploadItems := make([]photos.UploadItem, 0)
-	for _, arg := range c.Paths {
-		switch {
-		case strings.HasPrefix(arg, "http://") || strings.HasPrefix(arg, "https://"):
-			r, err := http.NewRequest("GET", arg, nil)
-			if err != nil {
-				return nil, fmt.Errorf("Could not parse URL: %s", err)
-			}
-			if c.RequestBasicAuth != "" {
-				kv := strings.SplitN(c.RequestBasicAuth, ":", 2)
-				r.SetBasicAuth(kv[0], kv[1])
-			}
-			for _, header := range c.RequestHeaders {
-				kv := strings.SplitN(header, ":", 2)
-				r.Header.Add(strings.TrimSpace(kv[0]), strings.TrimSpace(kv[1]))
+	for _, entry := range entries {
+		items, err := c.findUploadItemsOf(ctx, src, entry)
+		if err != nil {
+			return nil, err
+		}
+		uploadItems = append(uploadItems, items...)
+	}
+	return uploadItems, nil
+}
+
+// httpSource represents the client and credentials for fetching URLs.
+type httpSource struct {
+	client      *http.Client
+	credentials *httpCredentials
+	basicAuth   []string // USER, PASS
+	headers     [][]string
+}
+
+func (c *CLI) newHTTPSource() (*httpSource, error) {
+	var src httpSource
+	if c.RequestBasicAuth != "" {
+		user, pass, err := parseBasicAuth(c.RequestBasicAuth)
+		if err != nil {
+			return nil, fmt.Errorf("Invalid --request-auth: %s", err)
+		}
+		src.basicAuth = []string{user, pass}
+	}
+	for _, header := range c.RequestHeaders {
+		k, v, err := parseRequestHeader(header)
+		if err != nil {
+			return nil, fmt.Errorf("Invalid --request-header: %s", err)
+		}
+		src.headers = append(src.headers, []string{k, v})
+	}
+	credentials, err := newHTTPCredentials(c.ExternalConfig.HTTPCredentials)
+	if err != nil {
+		return nil, err
+	}
+	src.credentials = credentials
+	src.client, err = c.newHTTPClient()
+	if err != nil {
+		return nil, err
+	}
+	if credentials.jar != nil {
+		src.client = &http.Client{Transport: src.client.Transport, Jar: credentials.jar}
+	}
+	return &src, nil
+}
+
+// newRequest returns a request with the credentials.
+// The global options take precedence over gpupconfig.
+func (src *httpSource) newRequest(ctx context.Context, rawurl string) (*http.Request, error) {
+	r, err := src.newCredentialRequest(ctx, rawurl)
+	if err != nil {
+		return nil, err
+	}
+	if src.basicAuth != nil {
+		r.SetBasicAuth(src.basicAuth[0], src.basicAuth[1])
+	}
+	for _, kv := range src.headers {
+		r.Header.Add(kv[0], kv[1])
+	}
+	return r, nil
+}
+
+// newRequestFuncOf returns a function to create requests for the URLs found from the root,
+// such as links of a page or hrefs of a WebDAV collection.
+// The global options and headers are sent only to the host of the root,
+// and other hosts need an entry of http-credentials in gpupconfig.
+func (src *httpSource) newRequestFuncOf(root *url.URL, headers map[string]string) source.NewRequestFunc {
+	return func(ctx context.Context, rawurl string) (*http.Request, error) {
+		if u, err := url.Parse(rawurl); err == nil && !strings.EqualFold(u.Host, root.Host) {
+			return src.newCredentialRequest(ctx, rawurl)
+		}
+		r, err := src.newRequest(ctx, rawurl)
+		if err != nil {
+			return nil, err
+		}
+		for k, v := range headers {
+			r.Header.Set(k, v)
+		}
+		return r, nil
+	}
+}
+
+// newCredentialRequest returns a request with only the credentials of gpupconfig matched to the URL.
+func (src *httpSource) newCredentialRequest(ctx context.Context, rawurl string) (*http.Request, error) {
+	r, err := http.NewRequest("GET", rawurl, nil)
+	if err != nil {
+		return nil, fmt.Errorf("Could not parse URL: %s", err)
+	}
+	r = r.WithContext(ctx)
+	src.credentials.Apply(r)
+	return r, nil
+}
+
+// newS3 returns a client of S3-compatible storage configured by gpupconfig or environment variables.
+func (c *CLI) newS3(client *http.Client) *source.S3 {
+	var config S3Config
+	if c.ExternalConfig.S3 != nil {
+		config = *c.ExternalConfig.S3
+	}
+	s3 := source.S3{
+		Client:          client,
+		Endpoint:        config.Endpoint,
+		Region:          firstNonEmpty(config.Region, os.Getenv("AWS_REGION"), os.Getenv("AWS_DEFAULT_REGION"), "us-east-1"),
+		AccessKeyID:     firstNonEmpty(config.AccessKeyID, os.Getenv("AWS_ACCESS_KEY_ID")),
+		SecretAccessKey: firstNonEmpty(config.SecretAccessKey, os.Getenv("AWS_SECRET_ACCESS_KEY")),
+		SessionToken:    firstNonEmpty(config.SessionToken, os.Getenv("AWS_SESSION_TOKEN")),
+	}
+	if s3.Endpoint == "" {
+		s3.Endpoint = fmt.Sprintf("https://s3.%s.amazonaws.com", s3.Region)
+	}
+	return &s3
+}
+
+func firstNonEmpty(values ...string) string {
+	for _, v := range values {
+		if v != "" {
+			return v
+		}
+	}
+	return ""
+}
+
+func (c *CLI) findUploadItemsOf(ctx context.Context, src *httpSource, entry manifestEntry) ([]photos.UploadItem, error) {
+	uploadItems := make([]photos.UploadItem, 0)
+	arg := entry.Path
+	switch {
+	case c.Crawl && (strings.HasPrefix(arg, "http://") || strings.HasPrefix(arg, "https://")):
+		root, err := url.Parse(arg)
+		if err != nil {
+			return nil, fmt.Errorf("Could not parse URL: %s", err)
+		}
+		crawler := source.Crawler{
+			Client:     src.client,
+			NewRequest: src.newRequestFuncOf(root, entry.Headers),
+			MaxDepth:   c.CrawlDepth,
+			AnyHost:    c.CrawlAnyHost,
+			Patterns:   c.CrawlPatterns,
+			Logger:     logger,
+		}
+		items, err := crawler.Crawl(ctx, arg)
+		if err != nil {
+			return nil, err
+		}
+		uploadItems = append(uploadItems, items...)
+	case strings.HasPrefix(arg, "s3://"):
+		bucket, prefix, err := source.ParseS3URL(arg)
+		if err != nil {
+			return nil, err
+		}
+		items, err := c.newS3(src.client).List(ctx, bucket, prefix)
+		if err != nil {
+			return nil, err
+		}
+		uploadItems = append(uploadItems, items...)
+	case strings.HasPrefix(arg, "webdav://") || strings.HasPrefix(arg, "webdav+http://") || strings.HasPrefix(arg, "webdav+https://"):
+		u, err := source.ParseWebDAVURL(arg)
+		if err != nil {
+			return nil, err
+		}
+		root, err := url.Parse(u)
+		if err != nil {
+			return nil, fmt.Errorf("Could not parse URL: %s", err)
+		}
+		dav := source.WebDAV{Client: src.client, NewRequest: src.newRequestFuncOf(root, nil)}
+		items, err := dav.List(ctx, u)
+		if err != nil {
+			return nil, err
+		}
+		uploadItems = append(uploadItems, items...)
+	case strings.HasPrefix(arg, "http://") || strings.HasPrefix(arg, "https://"):
+		r, err := src.newRequest(ctx, arg)
+		if err != nil {
+			return nil, err
+		}
+		for k, v := range entry.Headers {
+			r.Header.Set(k, v)
+		}
+		uploadItems = append(uploadItems, &photos.HTTPUploadItem{
+			Client:  src.client,
+			Request: r,
+		})
+	default:
+		if err := filepath.Walk(arg, func(name string, info os.FileInfo, err error) error {
+			switch {
+			case err != nil:
+				return err
+			case info.Mode().IsRegular():
+				uploadItems = append(uploadItems, photos.FileUploadItem(name))
+				return nil
+			default:
+				return nil
 			}
-			uploadItems = append(uploadItems, &photos.HTTPUploadItem{
-				Client:  client,
-				Request: r,
-			})
-		default:
-			if err := filepath.Walk(arg, func(name string, info os.FileInfo, err error) error {
-				switch {
-				case err != nil:
-					return err
-				case info.Mode().IsRegular():
-					uploadItems = append(uploadItems, photos.FileUploadItem(name))
-					return nil
-				default:
-					return nil
-				}
-			}); err != nil {
-				return nil, fmt.Errorf("Error while finding files in %s: %s", arg, err)
+		}); err != nil {
+			return nil, fmt.Errorf("Error while finding files in %s: %s", arg, err)
+		}
+	}
+	if entry.SHA256 != "" {
+		if len(uploadItems) != 1 {
+			return nil, fmt.Errorf("sha256 is given but %s contains %d items", arg, len(uploadItems))
+		}
+		sum, err := decodeSHA256(entry.SHA256)
+		if err != nil {
+			return nil, err
+		}
+		uploadItems[0] = &photos.ChecksumUploadItem{UploadItem: uploadItems[0], SHA256: sum}
+	}
+	if entry.Album != "" || entry.Description != "" {
+		for i, item := range uploadItems {
+			uploadItems[i] = &manifestUploadItem{
+				UploadItem:  item,
+				album:       entry.Album,
+				description: entry.Description,
 			}
 		}
 	}
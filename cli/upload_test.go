@@ -1,7 +1,11 @@
 package cli
 
 import (
+	"context"
+	"fmt"
 	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
 	"os"
 	"testing"
 
@@ -38,7 +42,7 @@ func TestCLI_findUploadItems(t *testing.T) {
 			"http://www.example.com/image.jpg",
 		},
 	}
-	uploadItems, err := c.findUploadItems()
+	uploadItems, err := c.findUploadItems(context.Background())
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -70,7 +74,7 @@ func TestCLI_findUploadItems_Headers(t *testing.T) {
 		RequestHeaders:   []string{"Cookie: foo"},
 		RequestBasicAuth: "alice:bob",
 	}
-	uploadItems, err := c.findUploadItems()
+	uploadItems, err := c.findUploadItems(context.Background())
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -93,3 +97,141 @@ func TestCLI_findUploadItems_Headers(t *testing.T) {
 		t.Errorf("[0].BasicAuth.password wants bob but %s", password)
 	}
 }
+
+func TestCLI_findUploadItems_CrawlAnyHost(t *testing.T) {
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html")
+		fmt.Fprint(w, `<a href="a.jpg">a</a> <a href="https://cdn.example.com/b.jpg">b</a>`)
+	}))
+	defer s.Close()
+	c := CLI{
+		Paths:            []string{s.URL + "/"},
+		RequestHeaders:   []string{"X-Token: SECRET"},
+		RequestBasicAuth: "alice:bob",
+		Crawl:            true,
+		CrawlAnyHost:     true,
+		ExternalConfig: ExternalConfig{
+			HTTPCredentials: []HTTPCredential{{Match: "cdn.example.com", BearerToken: "CDN"}},
+		},
+	}
+	uploadItems, err := c.findUploadItems(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(uploadItems) != 2 {
+		t.Fatalf("wants size 2 but %d", len(uploadItems))
+	}
+	root := uploadItems[0].(*photos.HTTPUploadItem).Request
+	if v := root.Header.Get("X-Token"); v != "SECRET" {
+		t.Errorf("[0].Header(X-Token) wants SECRET but %s", v)
+	}
+	if _, _, ok := root.BasicAuth(); !ok {
+		t.Errorf("[0].BasicAuth wants ok but not")
+	}
+	foreign := uploadItems[1].(*photos.HTTPUploadItem).Request
+	if v := foreign.Header.Get("X-Token"); v != "" {
+		t.Errorf("[1].Header(X-Token) wants empty but %s", v)
+	}
+	if v := foreign.Header.Get("Authorization"); v != "Bearer CDN" {
+		t.Errorf("[1].Header(Authorization) wants Bearer CDN but %s", v)
+	}
+}
+
+func TestCLI_findUploadItems_WebDAVOtherHost(t *testing.T) {
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if u, _, ok := r.BasicAuth(); !ok || u != "alice" {
+			t.Errorf("PROPFIND wants the basic auth but %v", r.Header)
+		}
+		w.Header().Set("Content-Type", "application/xml")
+		w.WriteHeader(207)
+		fmt.Fprint(w, `<?xml version="1.0"?><multistatus xmlns="DAV:">
+<response><href>/photos/</href><propstat><prop><resourcetype><collection/></resourcetype></prop><status>HTTP/1.1 200 OK</status></propstat></response>
+<response><href>/photos/a.jpg</href><propstat><prop><resourcetype/></prop><status>HTTP/1.1 200 OK</status></propstat></response>
+<response><href>https://evil.example.com/b.jpg</href><propstat><prop><resourcetype/></prop><status>HTTP/1.1 200 OK</status></propstat></response>
+</multistatus>`)
+	}))
+	defer s.Close()
+	c := CLI{
+		Paths:            []string{"webdav+" + s.URL + "/photos/"},
+		RequestHeaders:   []string{"X-Token: SECRET"},
+		RequestBasicAuth: "alice:bob",
+	}
+	uploadItems, err := c.findUploadItems(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(uploadItems) != 2 {
+		t.Fatalf("wants size 2 but %d", len(uploadItems))
+	}
+	root := uploadItems[0].(*photos.HTTPUploadItem).Request
+	if v := root.Header.Get("X-Token"); v != "SECRET" {
+		t.Errorf("[0].Header(X-Token) wants SECRET but %s", v)
+	}
+	foreign := uploadItems[1].(*photos.HTTPUploadItem).Request
+	if v := foreign.Header.Get("X-Token"); v != "" {
+		t.Errorf("[1].Header(X-Token) wants empty but %s", v)
+	}
+	if _, _, ok := foreign.BasicAuth(); ok {
+		t.Errorf("[1].BasicAuth wants none but set")
+	}
+}
+
+func TestCLI_findUploadItems_Manifest(t *testing.T) {
+	tempdir, err := ioutil.TempDir("", "Manifest")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tempdir)
+	if err := ioutil.WriteFile(tempdir+"/a.jpg", []byte{}, 0644); err != nil {
+		t.Fatal(err)
+	}
+	manifest := tempdir + "/manifest.jsonl"
+	if err := ioutil.WriteFile(manifest, []byte(`
+{"path": "`+tempdir+`/a.jpg", "album": "Trip", "description": "Lunch", "sha256": "e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855"}
+{"path": "http://www.example.com/image.jpg", "headers": {"Cookie": "foo"}}
+`), 0644); err != nil {
+		t.Fatal(err)
+	}
+	c := CLI{Manifests: []string{manifest}}
+	uploadItems, err := c.findUploadItems(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(uploadItems) != 2 {
+		t.Fatalf("wants size 2 but %d", len(uploadItems))
+	}
+	if uploadItem, ok := uploadItems[0].(*manifestUploadItem); !ok {
+		t.Errorf("[0] wants manifestUploadItem but %+v", uploadItems[0])
+	} else if uploadItem.Description() != "Lunch" {
+		t.Errorf("[0].Description wants Lunch but %s", uploadItem.Description())
+	} else if _, ok := uploadItem.UploadItem.(*photos.ChecksumUploadItem); !ok {
+		t.Errorf("[0].UploadItem wants ChecksumUploadItem but %+v", uploadItem.UploadItem)
+	}
+	if uploadItem, ok := uploadItems[1].(*photos.HTTPUploadItem); !ok {
+		t.Errorf("[1] wants HTTPUploadItem but %+v", uploadItems[1])
+	} else if v := uploadItem.Request.Header.Get("Cookie"); v != "foo" {
+		t.Errorf("[1].Header(Cookie) wants foo but %s", v)
+	}
+
+	groups := groupByAlbum(uploadItems)
+	if len(groups) != 2 {
+		t.Fatalf("len(groups) wants 2 but %d", len(groups))
+	}
+	if groups[0].album != "Trip" || groups[0].indexes[0] != 0 {
+		t.Errorf("groups[0] wants album Trip at #0 but %+v", groups[0])
+	}
+	if groups[1].album != "" || groups[1].indexes[0] != 1 {
+		t.Errorf("groups[1] wants no album at #1 but %+v", groups[1])
+	}
+}
+
+func TestCLI_findUploadItems_InvalidOptions(t *testing.T) {
+	for _, c := range []CLI{
+		{Paths: []string{"http://www.example.com/image.jpg"}, RequestHeaders: []string{"Cookie"}},
+		{Paths: []string{"http://www.example.com/image.jpg"}, RequestBasicAuth: "alice"},
+	} {
+		if _, err := c.findUploadItems(context.Background()); err == nil {
+			t.Errorf("findUploadItems wants error but nil")
+		}
+	}
+}
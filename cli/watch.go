@@ -0,0 +1,425 @@
+package cli
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"sort"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+	"github.com/int128/gpup/photos"
+	homedir "github.com/mitchellh/go-homedir"
+)
+
+const (
+	// watchBatchSize is the limit of items in a BatchCreate call.
+	watchBatchSize = 50
+	// watchMaxAttempts is the limit of uploading a file.
+	// The file is uploaded again on the next run if it still fails.
+	watchMaxAttempts = 5
+)
+
+// WatchCommand represents the watch command.
+type WatchCommand struct {
+	Settle        time.Duration `long:"settle" value-name:"DURATION" default:"3s" description:"Wait until a file stops changing for the duration"`
+	BatchInterval time.Duration `long:"batch-interval" value-name:"DURATION" default:"30s" description:"Upload new files at the interval unless a batch is full"`
+	Journal       string        `long:"journal" value-name:"FILE" default:"~/.gpupjournal" description:"Path to the record of uploaded files"`
+	Args          struct {
+		Dirs []string `positional-arg-name:"DIR" required:"1"`
+	} `positional-args:"yes"`
+}
+
+func (c *CLI) watch(ctx context.Context) error {
+	if c.NewAlbum != "" {
+		return fmt.Errorf("--new-album is not supported in watch, use -a instead")
+	}
+	journal, err := openJournal(c.Watch.Journal)
+	if err != nil {
+		return err
+	}
+	defer journal.Close()
+	service, err := c.newPhotos(ctx)
+	if err != nil {
+		return err
+	}
+	w := &watcher{
+		dirs:          c.Watch.Args.Dirs,
+		settle:        c.Watch.Settle,
+		interval:      c.Watch.BatchInterval,
+		retryInterval: 10 * time.Second,
+		journal:       journal,
+		upload: func(ctx context.Context, paths []string) []*photos.AddResult {
+			items := make([]photos.UploadItem, len(paths))
+			for i, path := range paths {
+				items[i] = photos.FileUploadItem(path)
+			}
+			results, err := c.add(ctx, service, "", items)
+			if err != nil {
+				results = make([]*photos.AddResult, len(paths))
+				for i := range results {
+					results[i] = &photos.AddResult{Error: err}
+				}
+			}
+			return results
+		},
+	}
+
+	// stop watching on the first signal and abort the upload on the second
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	stop := make(chan struct{})
+	signals := make(chan os.Signal, 2)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+	defer signal.Stop(signals)
+	go func() {
+		select {
+		case <-signals:
+			logger.Info("Stopping after the current batch, send the signal again to abort")
+			close(stop)
+		case <-ctx.Done():
+			return
+		}
+		select {
+		case <-signals:
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+	return w.run(ctx, stop)
+}
+
+// watcher uploads files which appear in the directories.
+// A batch is uploaded in background so that events are handled during the upload.
+type watcher struct {
+	dirs          []string
+	settle        time.Duration // a file is ready if not changed for the duration
+	interval      time.Duration // pending files are uploaded at the interval
+	retryInterval time.Duration // initial interval to upload a failed file again
+	journal       *journal
+	upload        func(ctx context.Context, paths []string) []*photos.AddResult
+
+	fsw          *fsnotify.Watcher
+	candidates   map[string]*candidate
+	pending      []string
+	pendingSince time.Time
+	uploading    bool
+	results      chan *batchResult
+	scheduled    map[string]bool      // files pending, being uploaded or to retry
+	failures     map[string]int       // attempts of the failed files
+	retries      map[string]time.Time // failed files and the time to upload again
+}
+
+// batchResult represents the results of an uploaded batch.
+type batchResult struct {
+	paths   []string
+	results []*photos.AddResult
+}
+
+// candidate represents a file which may be still written.
+type candidate struct {
+	size    int64
+	modTime time.Time
+	since   time.Time // last time the file changed
+}
+
+func (w *watcher) run(ctx context.Context, stop <-chan struct{}) error {
+	fsw, err := fsnotify.NewWatcher()
+	if err != nil {
+		return fmt.Errorf("Could not watch files: %s", err)
+	}
+	defer fsw.Close()
+	w.fsw = fsw
+	w.candidates = make(map[string]*candidate)
+	w.results = make(chan *batchResult, 1)
+	w.scheduled = make(map[string]bool)
+	w.failures = make(map[string]int)
+	w.retries = make(map[string]time.Time)
+	for _, dir := range w.dirs {
+		if err := w.addDir(dir); err != nil {
+			return err
+		}
+	}
+	logger.Info("Watching", "dirs", strings.Join(w.dirs, ","))
+
+	tick := w.settle / 2
+	if tick < 100*time.Millisecond {
+		tick = 100 * time.Millisecond
+	}
+	ticker := time.NewTicker(tick)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-stop:
+			w.check(time.Now())
+			for w.uploading || len(w.pending) > 0 {
+				if !w.uploading {
+					w.flush(ctx)
+				}
+				select {
+				case r := <-w.results:
+					w.complete(r, false)
+				case <-ctx.Done():
+					return ctx.Err()
+				}
+			}
+			if len(w.candidates) > 0 {
+				logger.Warn("Skipped files being written, they will be uploaded on the next run", "count", len(w.candidates))
+			}
+			if len(w.retries) > 0 {
+				logger.Warn("Skipped files to retry, they will be uploaded on the next run", "count", len(w.retries))
+			}
+			return ctx.Err()
+		case <-ctx.Done():
+			return ctx.Err()
+		case event := <-fsw.Events:
+			w.handle(event)
+		case err := <-fsw.Errors:
+			logger.Warn("Error while watching files", "error", err)
+		case r := <-w.results:
+			w.complete(r, true)
+		case now := <-ticker.C:
+			w.check(now)
+			if w.uploading {
+				continue
+			}
+			if len(w.pending) >= watchBatchSize || (len(w.pending) > 0 && now.Sub(w.pendingSince) >= w.interval) {
+				w.flush(ctx)
+			}
+		}
+	}
+}
+
+// addDir watches the directory and its subdirectories,
+// and adds the files not in the journal.
+func (w *watcher) addDir(dir string) error {
+	return filepath.Walk(dir, func(name string, info os.FileInfo, err error) error {
+		switch {
+		case err != nil:
+			return err
+		case info.IsDir():
+			if err := w.fsw.Add(name); err != nil {
+				return fmt.Errorf("Could not watch %s: %s", name, err)
+			}
+		case info.Mode().IsRegular():
+			w.touch(name, info)
+		}
+		return nil
+	})
+}
+
+func (w *watcher) handle(event fsnotify.Event) {
+	if event.Op&(fsnotify.Create|fsnotify.Write) == 0 {
+		return
+	}
+	info, err := os.Stat(event.Name)
+	if err != nil {
+		return
+	}
+	if info.IsDir() {
+		if err := w.addDir(event.Name); err != nil {
+			logger.Warn("Error while watching files", "error", err)
+		}
+		return
+	}
+	if info.Mode().IsRegular() {
+		w.touch(event.Name, info)
+	}
+}
+
+// touch adds the file to the candidates if it is not uploaded or scheduled yet.
+func (w *watcher) touch(name string, info os.FileInfo) {
+	if isTemporaryFile(name) || w.scheduled[name] || w.journal.Contains(name, info) {
+		return
+	}
+	if c, ok := w.candidates[name]; ok {
+		if c.size != info.Size() || !c.modTime.Equal(info.ModTime()) {
+			c.size, c.modTime, c.since = info.Size(), info.ModTime(), time.Now()
+		}
+		return
+	}
+	w.candidates[name] = &candidate{size: info.Size(), modTime: info.ModTime(), since: time.Now()}
+}
+
+// check moves the files which stopped changing and the files to retry to the pending list.
+func (w *watcher) check(now time.Time) {
+	var ready []string
+	for name, at := range w.retries {
+		if now.Before(at) {
+			continue
+		}
+		delete(w.retries, name)
+		ready = append(ready, name)
+	}
+	for name, c := range w.candidates {
+		info, err := os.Stat(name)
+		if err != nil {
+			delete(w.candidates, name)
+			continue
+		}
+		if c.size != info.Size() || !c.modTime.Equal(info.ModTime()) {
+			c.size, c.modTime, c.since = info.Size(), info.ModTime(), now
+			continue
+		}
+		if now.Sub(c.since) < w.settle || info.Size() == 0 {
+			continue
+		}
+		delete(w.candidates, name)
+		w.scheduled[name] = true
+		ready = append(ready, name)
+	}
+	if len(ready) == 0 {
+		return
+	}
+	sort.Strings(ready)
+	if len(w.pending) == 0 {
+		w.pendingSince = now
+	}
+	w.pending = append(w.pending, ready...)
+}
+
+// flush starts uploading a batch of the pending files in background.
+// The results are sent to w.results.
+func (w *watcher) flush(ctx context.Context) {
+	n := len(w.pending)
+	if n > watchBatchSize {
+		n = watchBatchSize
+	}
+	batch := w.pending[:n]
+	w.pending = w.pending[n:]
+	w.pendingSince = time.Now()
+	w.uploading = true
+	logger.Info("Uploading a batch", "count", len(batch))
+	go func() {
+		w.results <- &batchResult{paths: batch, results: w.upload(ctx, batch)}
+	}()
+}
+
+// complete records the uploaded files to the journal.
+// If retry is true, the failed files are uploaded again with exponential backoff.
+func (w *watcher) complete(r *batchResult, retry bool) {
+	w.uploading = false
+	for i, result := range r.results {
+		name := r.paths[i]
+		if result.Error != nil {
+			w.failures[name]++
+			attempts := w.failures[name]
+			if !retry || attempts >= watchMaxAttempts {
+				logger.Error("Could not upload, it will be uploaded on the next run", "item", name, "attempts", attempts, "error", result.Error)
+				delete(w.failures, name)
+				delete(w.scheduled, name)
+				continue
+			}
+			delay := w.retryInterval << uint(attempts-1)
+			logger.Warn("Could not upload, retrying", "item", name, "attempts", attempts, "delay", delay, "error", result.Error)
+			w.retries[name] = time.Now().Add(delay)
+			continue
+		}
+		delete(w.failures, name)
+		delete(w.scheduled, name)
+		fmt.Printf("%s: OK\n", name)
+		if err := w.journal.Add(name, result); err != nil {
+			logger.Warn("Could not write to the journal", "item", name, "error", err)
+		}
+	}
+}
+
+// isTemporaryFile returns true if the file seems to be written by another tool.
+func isTemporaryFile(name string) bool {
+	base := filepath.Base(name)
+	return strings.HasPrefix(base, ".") ||
+		strings.HasSuffix(base, "~") ||
+		strings.HasSuffix(base, ".tmp") ||
+		strings.HasSuffix(base, ".part") ||
+		strings.HasSuffix(base, ".crdownload")
+}
+
+// journal records the uploaded files, in order not to upload them again after restart.
+type journal struct {
+	file    *os.File
+	entries map[string]*journalEntry
+}
+
+type journalEntry struct {
+	Path        string    `json:"path"`
+	Size        int64     `json:"size"`
+	ModTime     time.Time `json:"mtime"`
+	MediaItemID string    `json:"media-item-id,omitempty"`
+	UploadedAt  time.Time `json:"uploaded-at"`
+}
+
+// openJournal reads the journal and opens it for appending.
+func openJournal(name string) (*journal, error) {
+	p, err := homedir.Expand(name)
+	if err != nil {
+		return nil, fmt.Errorf("Could not expand %s: %s", name, err)
+	}
+	f, err := os.OpenFile(p, os.O_RDWR|os.O_CREATE|os.O_APPEND, 0600)
+	if err != nil {
+		return nil, fmt.Errorf("Could not open %s: %s", name, err)
+	}
+	j := &journal{file: f, entries: make(map[string]*journalEntry)}
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		var e journalEntry
+		if err := json.Unmarshal(scanner.Bytes(), &e); err != nil {
+			// a line may be broken by crash
+			continue
+		}
+		j.entries[e.Path] = &e
+	}
+	if err := scanner.Err(); err != nil {
+		f.Close()
+		return nil, fmt.Errorf("Could not read %s: %s", name, err)
+	}
+	return j, nil
+}
+
+// Contains returns true if the file has been uploaded and not changed.
+func (j *journal) Contains(name string, info os.FileInfo) bool {
+	p, err := filepath.Abs(name)
+	if err != nil {
+		return false
+	}
+	e, ok := j.entries[p]
+	return ok && e.Size == info.Size() && e.ModTime.Equal(info.ModTime())
+}
+
+// Add appends the uploaded file.
+func (j *journal) Add(name string, r *photos.AddResult) error {
+	p, err := filepath.Abs(name)
+	if err != nil {
+		return err
+	}
+	info, err := os.Stat(p)
+	if err != nil {
+		return err
+	}
+	e := &journalEntry{Path: p, Size: info.Size(), ModTime: info.ModTime(), UploadedAt: time.Now()}
+	if r.MediaItem != nil {
+		e.MediaItemID = r.MediaItem.Id
+	}
+	b, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+	if _, err := j.file.Write(append(b, '\n')); err != nil {
+		return err
+	}
+	if err := j.file.Sync(); err != nil {
+		return err
+	}
+	j.entries[p] = e
+	return nil
+}
+
+// Close closes the journal.
+func (j *journal) Close() error {
+	return j.file.Close()
+}
@@ -0,0 +1,250 @@
+package cli
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/int128/gpup/photos"
+	photoslibrary "google.golang.org/api/photoslibrary/v1"
+)
+
+type uploadRecorder struct {
+	mu      sync.Mutex
+	batches [][]string
+}
+
+func (r *uploadRecorder) upload(ctx context.Context, paths []string) []*photos.AddResult {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.batches = append(r.batches, append([]string{}, paths...))
+	results := make([]*photos.AddResult, len(paths))
+	for i, path := range paths {
+		results[i] = &photos.AddResult{MediaItem: &photoslibrary.MediaItem{Id: filepath.Base(path)}}
+	}
+	return results
+}
+
+func (r *uploadRecorder) uploaded() []string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	var names []string
+	for _, batch := range r.batches {
+		for _, path := range batch {
+			names = append(names, filepath.Base(path))
+		}
+	}
+	sort.Strings(names)
+	return names
+}
+
+func newWatchDir(t *testing.T) (string, func()) {
+	dir, err := ioutil.TempDir("", "watch")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return dir, func() { os.RemoveAll(dir) }
+}
+
+func TestWatcher(t *testing.T) {
+	dir, cleanup := newWatchDir(t)
+	defer cleanup()
+	writeFile(t, filepath.Join(dir, "photos", "a.jpg"), "A")
+	writeFile(t, filepath.Join(dir, "photos", "b.jpg"), "B")
+	j, err := openJournal(filepath.Join(dir, "journal"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer j.Close()
+	if err := j.Add(filepath.Join(dir, "photos", "b.jpg"), &photos.AddResult{}); err != nil {
+		t.Fatal(err)
+	}
+
+	var r uploadRecorder
+	w := &watcher{
+		dirs:     []string{filepath.Join(dir, "photos")},
+		settle:   200 * time.Millisecond,
+		interval: 100 * time.Millisecond,
+		journal:  j,
+		upload:   r.upload,
+	}
+	stop := make(chan struct{})
+	done := make(chan error)
+	go func() { done <- w.run(context.Background(), stop) }()
+	time.Sleep(100 * time.Millisecond)
+	writeFile(t, filepath.Join(dir, "photos", "sub", "c.jpg"), "C")
+	writeFile(t, filepath.Join(dir, "photos", "d.jpg.part"), "D")
+	time.Sleep(1 * time.Second)
+	close(stop)
+	if err := <-done; err != nil {
+		t.Fatalf("run returned error: %s", err)
+	}
+
+	if want := []string{"a.jpg", "c.jpg"}; !reflect.DeepEqual(want, r.uploaded()) {
+		t.Errorf("uploaded wants %v but %v", want, r.uploaded())
+	}
+	j2, err := openJournal(filepath.Join(dir, "journal"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer j2.Close()
+	for _, name := range []string{"a.jpg", "b.jpg", filepath.Join("sub", "c.jpg")} {
+		p := filepath.Join(dir, "photos", name)
+		info, err := os.Stat(p)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !j2.Contains(p, info) {
+			t.Errorf("journal wants %s", name)
+		}
+	}
+}
+
+func TestWatcher_Batch(t *testing.T) {
+	dir, cleanup := newWatchDir(t)
+	defer cleanup()
+	for i := 0; i < 60; i++ {
+		writeFile(t, filepath.Join(dir, fmt.Sprintf("%02d.jpg", i)), "X")
+	}
+	j, err := openJournal(filepath.Join(dir, ".journal"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer j.Close()
+
+	var r uploadRecorder
+	w := &watcher{
+		dirs:     []string{dir},
+		settle:   100 * time.Millisecond,
+		interval: time.Hour,
+		journal:  j,
+		upload:   r.upload,
+	}
+	stop := make(chan struct{})
+	done := make(chan error)
+	go func() { done <- w.run(context.Background(), stop) }()
+	time.Sleep(500 * time.Millisecond)
+	close(stop)
+	if err := <-done; err != nil {
+		t.Fatalf("run returned error: %s", err)
+	}
+	var sizes []int
+	for _, batch := range r.batches {
+		sizes = append(sizes, len(batch))
+	}
+	if want := []int{50, 10}; !reflect.DeepEqual(want, sizes) {
+		t.Errorf("batch sizes wants %v but %v", want, sizes)
+	}
+}
+
+func TestWatcher_Retry(t *testing.T) {
+	dir, cleanup := newWatchDir(t)
+	defer cleanup()
+	writeFile(t, filepath.Join(dir, "a.jpg"), "A")
+	j, err := openJournal(filepath.Join(dir, ".journal"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer j.Close()
+
+	var r uploadRecorder
+	var mu sync.Mutex
+	var attempts int
+	release := make(chan struct{})
+	w := &watcher{
+		dirs:          []string{dir},
+		settle:        100 * time.Millisecond,
+		interval:      100 * time.Millisecond,
+		retryInterval: 100 * time.Millisecond,
+		journal:       j,
+		upload: func(ctx context.Context, paths []string) []*photos.AddResult {
+			mu.Lock()
+			attempts++
+			n := attempts
+			mu.Unlock()
+			if n == 1 {
+				// block the first upload to see events are handled meanwhile
+				<-release
+				results := make([]*photos.AddResult, len(paths))
+				for i := range results {
+					results[i] = &photos.AddResult{Error: fmt.Errorf("error")}
+				}
+				return results
+			}
+			return r.upload(ctx, paths)
+		},
+	}
+	stop := make(chan struct{})
+	done := make(chan error)
+	go func() { done <- w.run(context.Background(), stop) }()
+	time.Sleep(500 * time.Millisecond)
+	writeFile(t, filepath.Join(dir, "b.jpg"), "B")
+	time.Sleep(500 * time.Millisecond)
+	close(release)
+	time.Sleep(1 * time.Second)
+	close(stop)
+	if err := <-done; err != nil {
+		t.Fatalf("run returned error: %s", err)
+	}
+
+	if want := []string{"a.jpg", "b.jpg"}; !reflect.DeepEqual(want, r.uploaded()) {
+		t.Errorf("uploaded wants %v but %v", want, r.uploaded())
+	}
+	info, err := os.Stat(filepath.Join(dir, "a.jpg"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !j.Contains(filepath.Join(dir, "a.jpg"), info) {
+		t.Errorf("journal wants a.jpg")
+	}
+}
+
+func TestWatcher_WriteDuringUpload(t *testing.T) {
+	dir, cleanup := newWatchDir(t)
+	defer cleanup()
+	writeFile(t, filepath.Join(dir, "a.jpg"), "A")
+	j, err := openJournal(filepath.Join(dir, ".journal"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer j.Close()
+
+	var r uploadRecorder
+	started := make(chan struct{}, 10)
+	release := make(chan struct{})
+	w := &watcher{
+		dirs:          []string{dir},
+		settle:        100 * time.Millisecond,
+		interval:      100 * time.Millisecond,
+		retryInterval: 100 * time.Millisecond,
+		journal:       j,
+		upload: func(ctx context.Context, paths []string) []*photos.AddResult {
+			started <- struct{}{}
+			<-release
+			return r.upload(ctx, paths)
+		},
+	}
+	stop := make(chan struct{})
+	done := make(chan error)
+	go func() { done <- w.run(context.Background(), stop) }()
+	<-started
+	// the file is touched while it is being uploaded
+	writeFile(t, filepath.Join(dir, "a.jpg"), "AA")
+	time.Sleep(500 * time.Millisecond)
+	close(release)
+	time.Sleep(500 * time.Millisecond)
+	close(stop)
+	if err := <-done; err != nil {
+		t.Fatalf("run returned error: %s", err)
+	}
+	if want := []string{"a.jpg"}; !reflect.DeepEqual(want, r.uploaded()) {
+		t.Errorf("uploaded wants %v but %v", want, r.uploaded())
+	}
+}
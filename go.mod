@@ -2,14 +2,17 @@ module github.com/int128/gpup
 
 require (
 	cloud.google.com/go v0.31.0 // indirect
+	github.com/fsnotify/fsnotify v1.4.9
 	github.com/int128/oauth2cli v1.0.0
 	github.com/jessevdk/go-flags v1.4.0
 	github.com/lestrrat-go/backoff v0.0.0-20180409035020-828830ec1d9a
 	github.com/mitchellh/go-homedir v1.0.0
 	github.com/pkg/errors v0.8.0 // indirect
+	golang.org/x/crypto v0.0.0-20190308221718-c2843e01d9a2
+	golang.org/x/net v0.0.0-20181029044818-c44066c5c816
 	golang.org/x/oauth2 v0.0.0-20181031022657-8527f56f7107
 	google.golang.org/api v0.0.0-20181101000641-61ce27ee8154
-	gopkg.in/yaml.v2 v2.2.1
+	gopkg.in/yaml.v3 v3.0.1
 )
 
 go 1.13
@@ -0,0 +1,198 @@
+// Package logging provides leveled and structured logging.
+//
+// Logger has the same methods as log/slog.Logger,
+// so that you can pass a *slog.Logger if available.
+package logging
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Logger writes a message with key-value pairs at the level.
+type Logger interface {
+	Debug(msg string, args ...interface{})
+	Info(msg string, args ...interface{})
+	Warn(msg string, args ...interface{})
+	Error(msg string, args ...interface{})
+}
+
+// Level represents the severity of a log.
+type Level int
+
+// Levels in the same values as log/slog.
+const (
+	LevelDebug Level = -4
+	LevelInfo  Level = 0
+	LevelWarn  Level = 4
+	LevelError Level = 8
+)
+
+func (l Level) String() string {
+	switch l {
+	case LevelDebug:
+		return "DEBUG"
+	case LevelInfo:
+		return "INFO"
+	case LevelWarn:
+		return "WARN"
+	case LevelError:
+		return "ERROR"
+	}
+	return fmt.Sprintf("LEVEL(%d)", int(l))
+}
+
+// Formats of New.
+const (
+	Text = "text"
+	JSON = "json"
+)
+
+// Default writes text logs at the info level to stderr.
+var Default = Must(New(os.Stderr, Text, LevelInfo))
+
+// Discard writes nothing.
+var Discard Logger = discard{}
+
+// New returns a logger which writes lines in the format (text or json) at or above the level.
+func New(w io.Writer, format string, level Level) (Logger, error) {
+	switch format {
+	case Text, JSON:
+		return &writer{w: w, json: format == JSON, level: level, now: time.Now}, nil
+	}
+	return nil, fmt.Errorf("Unknown log format %s (text or json)", format)
+}
+
+// Must returns the logger or panics if err is not nil.
+func Must(l Logger, err error) Logger {
+	if err != nil {
+		panic(err)
+	}
+	return l
+}
+
+type writer struct {
+	mu    sync.Mutex
+	w     io.Writer
+	json  bool
+	level Level
+	now   func() time.Time
+}
+
+func (l *writer) Debug(msg string, args ...interface{}) { l.log(LevelDebug, msg, args) }
+func (l *writer) Info(msg string, args ...interface{})  { l.log(LevelInfo, msg, args) }
+func (l *writer) Warn(msg string, args ...interface{})  { l.log(LevelWarn, msg, args) }
+func (l *writer) Error(msg string, args ...interface{}) { l.log(LevelError, msg, args) }
+
+func (l *writer) log(level Level, msg string, args []interface{}) {
+	if level < l.level {
+		return
+	}
+	var line string
+	if l.json {
+		line = formatJSON(l.now(), level, msg, args)
+	} else {
+		line = formatText(l.now(), level, msg, args)
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	io.WriteString(l.w, line)
+}
+
+type pair struct {
+	key   string
+	value interface{}
+}
+
+// pairs returns the key-value pairs of the args.
+// A key without value is reported as !BADKEY like log/slog.
+func pairs(args []interface{}) []pair {
+	var kvs []pair
+	for i := 0; i < len(args); i += 2 {
+		if i+1 >= len(args) {
+			kvs = append(kvs, pair{"!BADKEY", args[i]})
+			break
+		}
+		kvs = append(kvs, pair{fmt.Sprint(args[i]), args[i+1]})
+	}
+	return kvs
+}
+
+func formatText(t time.Time, level Level, msg string, args []interface{}) string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "%s %-5s %s", t.Format("2006/01/02 15:04:05"), level, msg)
+	for _, kv := range pairs(args) {
+		fmt.Fprintf(&b, " %s=%s", kv.key, quote(textValue(kv.value)))
+	}
+	b.WriteString("\n")
+	return b.String()
+}
+
+func textValue(v interface{}) string {
+	switch v := v.(type) {
+	case error:
+		return v.Error()
+	case fmt.Stringer:
+		return v.String()
+	}
+	return fmt.Sprint(v)
+}
+
+func quote(s string) string {
+	if s == "" || strings.ContainsAny(s, " \t\r\n\"=") {
+		return strconv.Quote(s)
+	}
+	return s
+}
+
+func formatJSON(t time.Time, level Level, msg string, args []interface{}) string {
+	var b strings.Builder
+	b.WriteString("{")
+	writeJSONField(&b, "time", t.Format(time.RFC3339Nano))
+	b.WriteString(",")
+	writeJSONField(&b, "level", level.String())
+	b.WriteString(",")
+	writeJSONField(&b, "msg", msg)
+	for _, kv := range pairs(args) {
+		b.WriteString(",")
+		writeJSONField(&b, kv.key, jsonValue(kv.value))
+	}
+	b.WriteString("}\n")
+	return b.String()
+}
+
+func jsonValue(v interface{}) interface{} {
+	switch v := v.(type) {
+	case error:
+		return v.Error()
+	case time.Duration:
+		return v.Seconds()
+	case fmt.Stringer:
+		return v.String()
+	}
+	return v
+}
+
+func writeJSONField(b *strings.Builder, key string, v interface{}) {
+	k, _ := json.Marshal(key)
+	j, err := json.Marshal(v)
+	if err != nil {
+		j, _ = json.Marshal(fmt.Sprint(v))
+	}
+	b.Write(k)
+	b.WriteString(":")
+	b.Write(j)
+}
+
+type discard struct{}
+
+func (discard) Debug(string, ...interface{}) {}
+func (discard) Info(string, ...interface{})  {}
+func (discard) Warn(string, ...interface{})  {}
+func (discard) Error(string, ...interface{}) {}
@@ -0,0 +1,49 @@
+package logging
+
+import (
+	"bytes"
+	"fmt"
+	"testing"
+	"time"
+)
+
+func newTestLogger(t *testing.T, format string, level Level) (Logger, *bytes.Buffer) {
+	var b bytes.Buffer
+	l, err := New(&b, format, level)
+	if err != nil {
+		t.Fatal(err)
+	}
+	l.(*writer).now = func() time.Time { return time.Date(2019, 1, 2, 3, 4, 5, 0, time.UTC) }
+	return l, &b
+}
+
+func TestNew_Text(t *testing.T) {
+	l, b := newTestLogger(t, Text, LevelInfo)
+	l.Debug("Hidden")
+	l.Info("Uploading", "item", "a.jpg", "size", 1024)
+	l.Warn("Error while uploading", "item", "my photo.jpg", "attempt", 2, "error", fmt.Errorf("Got 503"))
+	l.Error("Odd", "key")
+	want := `2019/01/02 03:04:05 INFO  Uploading item=a.jpg size=1024
+2019/01/02 03:04:05 WARN  Error while uploading item="my photo.jpg" attempt=2 error="Got 503"
+2019/01/02 03:04:05 ERROR Odd !BADKEY=key
+`
+	if b.String() != want {
+		t.Errorf("output wants\n%s\nbut\n%s", want, b.String())
+	}
+}
+
+func TestNew_JSON(t *testing.T) {
+	l, b := newTestLogger(t, JSON, LevelDebug)
+	l.Debug("Uploaded", "item", "a.jpg", "duration", 1500*time.Millisecond, "error", fmt.Errorf("x"))
+	want := `{"time":"2019-01-02T03:04:05Z","level":"DEBUG","msg":"Uploaded","item":"a.jpg","duration":1.5,"error":"x"}
+`
+	if b.String() != want {
+		t.Errorf("output wants\n%s\nbut\n%s", want, b.String())
+	}
+}
+
+func TestNew_UnknownFormat(t *testing.T) {
+	if _, err := New(&bytes.Buffer{}, "xml", LevelInfo); err == nil {
+		t.Errorf("New wants error but nil")
+	}
+}
@@ -18,6 +18,7 @@ func main() {
 	}
 	ctx := context.Background()
 	if err := c.Run(ctx); err != nil {
-		log.Fatalf("Error: %s", err)
+		c.Logger().Error("Error", "error", err)
+		os.Exit(1)
 	}
 }
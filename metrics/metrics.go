@@ -0,0 +1,197 @@
+// Package metrics provides the Prometheus metrics of uploads.
+//
+// It implements photos.Metrics and writes the text exposition format,
+// so that you can expose it without the Prometheus client library.
+package metrics
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/int128/gpup/photos"
+)
+
+var _ photos.Metrics = &Registry{}
+
+// LatencyBuckets is the upper bounds of the latency histogram in seconds.
+var LatencyBuckets = []float64{0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10, 30, 60, 120}
+
+// Registry holds the metrics.
+type Registry struct {
+	mu            sync.Mutex
+	uploadedBytes float64
+	items         map[string]float64 // by state
+	retries       map[[2]string]float64
+	quotaExceeded map[string]float64 // by operation
+	latency       map[string]*histogram
+	inFlight      float64
+}
+
+type histogram struct {
+	counts []float64 // by bucket, not cumulative
+	sum    float64
+	count  float64
+}
+
+// New returns an empty registry.
+func New() *Registry {
+	return &Registry{
+		items:         make(map[string]float64),
+		retries:       make(map[[2]string]float64),
+		quotaExceeded: make(map[string]float64),
+		latency:       make(map[string]*histogram),
+	}
+}
+
+// ObserveLatency implements photos.Metrics.
+func (r *Registry) ObserveLatency(operation string, d time.Duration) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	h, ok := r.latency[operation]
+	if !ok {
+		h = &histogram{counts: make([]float64, len(LatencyBuckets))}
+		r.latency[operation] = h
+	}
+	s := d.Seconds()
+	for i, le := range LatencyBuckets {
+		if s <= le {
+			h.counts[i]++
+			break
+		}
+	}
+	h.sum += s
+	h.count++
+}
+
+// IncRetries implements photos.Metrics.
+func (r *Registry) IncRetries(operation, cause string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.retries[[2]string{operation, cause}]++
+}
+
+// IncQuotaExceeded implements photos.Metrics.
+func (r *Registry) IncQuotaExceeded(operation string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.quotaExceeded[operation]++
+}
+
+// AddUploadedBytes implements photos.Metrics.
+func (r *Registry) AddUploadedBytes(n int64) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.uploadedBytes += float64(n)
+}
+
+// IncItems implements photos.Metrics.
+func (r *Registry) IncItems(state string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.items[state]++
+}
+
+// AddInFlight implements photos.Metrics.
+func (r *Registry) AddInFlight(delta int) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.inFlight += float64(delta)
+}
+
+// ServeHTTP writes the metrics in the Prometheus text format.
+func (r *Registry) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+	r.WriteTo(w)
+}
+
+// WriteTo writes the metrics in the Prometheus text format.
+func (r *Registry) WriteTo(w io.Writer) (int64, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	var b strings.Builder
+
+	header(&b, "gpup_uploaded_bytes_total", "counter", "Total bytes of uploaded items.")
+	sample(&b, "gpup_uploaded_bytes_total", "", r.uploadedBytes)
+
+	header(&b, "gpup_items_total", "counter", "Number of items by state (uploaded, added or failed).")
+	for _, state := range sortedKeys(r.items) {
+		sample(&b, "gpup_items_total", labels("state", state), r.items[state])
+	}
+
+	header(&b, "gpup_api_duration_seconds", "histogram", "Latency of API calls by operation.")
+	for _, op := range sortedKeys(r.latency) {
+		h := r.latency[op]
+		var cumulative float64
+		for i, le := range LatencyBuckets {
+			cumulative += h.counts[i]
+			sample(&b, "gpup_api_duration_seconds_bucket", labels("operation", op, "le", fmt.Sprint(le)), cumulative)
+		}
+		sample(&b, "gpup_api_duration_seconds_bucket", labels("operation", op, "le", "+Inf"), h.count)
+		sample(&b, "gpup_api_duration_seconds_sum", labels("operation", op), h.sum)
+		sample(&b, "gpup_api_duration_seconds_count", labels("operation", op), h.count)
+	}
+
+	header(&b, "gpup_api_retries_total", "counter", "Number of retryable failures of API calls by operation and cause (network or server-error).")
+	retries := make([][2]string, 0, len(r.retries))
+	for k := range r.retries {
+		retries = append(retries, k)
+	}
+	sort.Slice(retries, func(i, j int) bool {
+		if retries[i][0] != retries[j][0] {
+			return retries[i][0] < retries[j][0]
+		}
+		return retries[i][1] < retries[j][1]
+	})
+	for _, k := range retries {
+		sample(&b, "gpup_api_retries_total", labels("operation", k[0], "cause", k[1]), r.retries[k])
+	}
+
+	header(&b, "gpup_api_quota_exceeded_total", "counter", "Number of 429 responses of API calls by operation.")
+	for _, op := range sortedKeys(r.quotaExceeded) {
+		sample(&b, "gpup_api_quota_exceeded_total", labels("operation", op), r.quotaExceeded[op])
+	}
+
+	header(&b, "gpup_upload_workers_in_flight", "gauge", "Number of workers uploading an item.")
+	sample(&b, "gpup_upload_workers_in_flight", "", r.inFlight)
+
+	n, err := io.WriteString(w, b.String())
+	return int64(n), err
+}
+
+func header(b *strings.Builder, name, typ, help string) {
+	fmt.Fprintf(b, "# HELP %s %s\n# TYPE %s %s\n", name, help, name, typ)
+}
+
+func sample(b *strings.Builder, name, labels string, v float64) {
+	fmt.Fprintf(b, "%s%s %g\n", name, labels, v)
+}
+
+// labels returns the label set of the key-value pairs.
+func labels(kv ...string) string {
+	var pairs []string
+	for i := 0; i+1 < len(kv); i += 2 {
+		pairs = append(pairs, fmt.Sprintf("%s=%q", kv[i], kv[i+1]))
+	}
+	return "{" + strings.Join(pairs, ",") + "}"
+}
+
+func sortedKeys(m interface{}) []string {
+	var keys []string
+	switch m := m.(type) {
+	case map[string]float64:
+		for k := range m {
+			keys = append(keys, k)
+		}
+	case map[string]*histogram:
+		for k := range m {
+			keys = append(keys, k)
+		}
+	}
+	sort.Strings(keys)
+	return keys
+}
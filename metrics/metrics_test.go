@@ -0,0 +1,50 @@
+package metrics
+
+import (
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRegistry(t *testing.T) {
+	r := New()
+	r.AddUploadedBytes(1024)
+	r.IncItems("added")
+	r.IncItems("added")
+	r.IncItems("failed")
+	r.ObserveLatency("Upload", 200*time.Millisecond)
+	r.ObserveLatency("Upload", 3*time.Second)
+	r.IncRetries("Upload", "network")
+	r.IncQuotaExceeded("BatchCreate")
+	r.AddInFlight(1)
+	r.AddInFlight(1)
+	r.AddInFlight(-1)
+
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest("GET", "/metrics", nil))
+	if ct := w.Header().Get("Content-Type"); !strings.HasPrefix(ct, "text/plain") {
+		t.Errorf("Content-Type wants text/plain but %s", ct)
+	}
+	body := w.Body.String()
+	for _, line := range []string{
+		"# TYPE gpup_uploaded_bytes_total counter",
+		"gpup_uploaded_bytes_total 1024",
+		`gpup_items_total{state="added"} 2`,
+		`gpup_items_total{state="failed"} 1`,
+		"# TYPE gpup_api_duration_seconds histogram",
+		`gpup_api_duration_seconds_bucket{operation="Upload",le="0.1"} 0`,
+		`gpup_api_duration_seconds_bucket{operation="Upload",le="0.25"} 1`,
+		`gpup_api_duration_seconds_bucket{operation="Upload",le="5"} 2`,
+		`gpup_api_duration_seconds_bucket{operation="Upload",le="+Inf"} 2`,
+		`gpup_api_duration_seconds_sum{operation="Upload"} 3.2`,
+		`gpup_api_duration_seconds_count{operation="Upload"} 2`,
+		`gpup_api_retries_total{operation="Upload",cause="network"} 1`,
+		`gpup_api_quota_exceeded_total{operation="BatchCreate"} 1`,
+		"gpup_upload_workers_in_flight 1",
+	} {
+		if !strings.Contains(body, line+"\n") {
+			t.Errorf("body wants %q but:\n%s", line, body)
+		}
+	}
+}
@@ -3,10 +3,13 @@ package photos
 import (
 	"context"
 	"fmt"
-	"log"
+	"net/http"
+	"strings"
 	"sync"
 
+	"github.com/int128/gpup/logging"
 	"github.com/int128/gpup/photos/internal"
+	"google.golang.org/api/googleapi"
 
 	photoslibrary "google.golang.org/api/photoslibrary/v1"
 )
@@ -22,27 +25,164 @@ func (p *Photos) AddToLibrary(ctx context.Context, uploadItems []UploadItem) []*
 }
 
 // AddToAlbum adds the items to the album.
+// If the album does not exist, this method creates it.
 // This method tries uploading all items and ignores any error.
 // If no item could be uploaded, this method returns an error.
 func (p *Photos) AddToAlbum(ctx context.Context, title string, uploadItems []UploadItem) ([]*AddResult, error) {
-	log.Printf("Finding album %s", title)
-	album, err := p.FindAlbumByTitle(ctx, title)
+	album, created, err := p.findOrCreateAlbum(ctx, title)
 	if err != nil {
-		return nil, fmt.Errorf("Could not list albums: %s", err)
+		return nil, err
+	}
+	if created {
+		return p.add(ctx, uploadItems, photoslibrary.BatchCreateMediaItemsRequest{
+			AlbumId:       album.Id,
+			AlbumPosition: p.albumPosition(),
+		}), nil
+	}
+	return p.addToAlbum(ctx, album, uploadItems)
+}
+
+// AddToAlbumByID adds the items to the album of the ID.
+// This method tries uploading all items and ignores any error.
+// If the album is not writeable, this method returns an error.
+func (p *Photos) AddToAlbumByID(ctx context.Context, id string, uploadItems []UploadItem) ([]*AddResult, error) {
+	p.log().Info("Getting the album", "album-id", id)
+	album, err := p.GetAlbum(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	return p.addToAlbum(ctx, album, uploadItems)
+}
+
+// AddToSharedAlbum joins the shared album of the share token and adds the items to it.
+// This method tries uploading all items and ignores any error.
+// If the album is not writeable, this method returns an error.
+func (p *Photos) AddToSharedAlbum(ctx context.Context, shareToken string, uploadItems []UploadItem) ([]*AddResult, error) {
+	p.log().Info("Joining the shared album")
+	if err := p.service.JoinSharedAlbum(ctx, shareToken); err != nil {
+		if isInsufficientScope(err) {
+			return nil, fmt.Errorf("Could not join the shared album: %s (the token may have been authorized without the sharing scope, so remove the token and authorize again)", err)
+		}
+		return nil, fmt.Errorf("Could not join the shared album: %s", err)
+	}
+	album, err := p.FindSharedAlbumByShareToken(ctx, shareToken)
+	if err != nil {
+		return nil, err
 	}
 	if album == nil {
-		log.Printf("Creating album %s", title)
-		created, err := p.service.CreateAlbum(ctx, &photoslibrary.CreateAlbumRequest{
-			Album: &photoslibrary.Album{Title: title},
-		})
+		return nil, fmt.Errorf("Shared album of the share token was not found")
+	}
+	return p.addToAlbum(ctx, album, uploadItems)
+}
+
+// isInsufficientScope returns true if the error is 403 caused by the scopes of the token.
+func isInsufficientScope(err error) bool {
+	apiErr, ok := err.(*googleapi.Error)
+	return ok && apiErr.Code == http.StatusForbidden && strings.Contains(strings.ToLower(apiErr.Message), "scope")
+}
+
+// findOrCreateAlbum returns the album of the title.
+// If the album does not exist, it creates an album and returns true.
+// AlbumLocker is held while finding and creating the album.
+func (p *Photos) findOrCreateAlbum(ctx context.Context, title string) (*photoslibrary.Album, bool, error) {
+	unlock, err := p.lockAlbum(ctx, title)
+	if err != nil {
+		return nil, false, err
+	}
+	defer unlock()
+
+	p.log().Info("Finding the album", "album", title)
+	album, err := p.FindAlbumByTitle(ctx, title)
+	if err != nil {
+		return nil, false, err
+	}
+	if album != nil {
+		return album, false, nil
+	}
+	album, err = p.findRecordedAlbum(ctx, title)
+	if err != nil {
+		return nil, false, err
+	}
+	if album != nil {
+		return album, false, nil
+	}
+	album, err = p.createAlbum(ctx, title)
+	if err != nil {
+		return nil, false, err
+	}
+	return album, true, nil
+}
+
+// findRecordedAlbum returns the album of the title in AlbumRecorder.
+// This finds an album which has been created but does not appear in the list yet.
+func (p *Photos) findRecordedAlbum(ctx context.Context, title string) (*photoslibrary.Album, error) {
+	if p.AlbumRecorder == nil {
+		return nil, nil
+	}
+	var albums []*photoslibrary.Album
+	for _, id := range p.AlbumRecorder.Find(title) {
+		album, err := p.service.GetAlbum(ctx, id)
 		if err != nil {
-			return nil, fmt.Errorf("Could not create an album: %s", err)
+			p.log().Warn("Skip the recorded album", "album-id", id, "error", err)
+			continue
+		}
+		if album.Title == title {
+			albums = append(albums, album)
 		}
-		album = created
+	}
+	return p.chooseAlbum(title, albums)
+}
+
+func (p *Photos) createAlbum(ctx context.Context, title string) (*photoslibrary.Album, error) {
+	p.log().Info("Creating the album", "album", title)
+	album, err := p.service.CreateAlbum(ctx, &photoslibrary.CreateAlbumRequest{
+		Album: &photoslibrary.Album{Title: title},
+	})
+	if err != nil {
+		return nil, fmt.Errorf("Could not create an album: %s", err)
+	}
+	if p.AlbumCache != nil {
+		p.AlbumCache.Invalidate(title)
+	}
+	if p.AlbumRecorder != nil {
+		p.AlbumRecorder.Created(album)
+	}
+	return album, nil
+}
+
+func (p *Photos) lockAlbum(ctx context.Context, title string) (func(), error) {
+	if p.AlbumLocker == nil {
+		return func() {}, nil
+	}
+	unlock, err := p.AlbumLocker.LockAlbum(ctx, title)
+	if err != nil {
+		return nil, fmt.Errorf("Could not lock the album %s: %s", title, err)
+	}
+	return unlock, nil
+}
+
+func (p *Photos) albumPosition() *photoslibrary.AlbumPosition {
+	if p.AlbumPosition != nil {
+		return p.AlbumPosition
+	}
+	return &photoslibrary.AlbumPosition{Position: "LAST_IN_ALBUM"}
+}
+
+func (p *Photos) uploadConcurrency() int {
+	if p.Concurrency > 0 {
+		return p.Concurrency
+	}
+	return uploadConcurrency
+}
+
+func (p *Photos) addToAlbum(ctx context.Context, album *photoslibrary.Album, uploadItems []UploadItem) ([]*AddResult, error) {
+	p.log().Info("Found the album", "album", album.Title, "album-id", album.Id, "shared", album.ShareInfo != nil, "writeable", album.IsWriteable)
+	if !album.IsWriteable {
+		return nil, fmt.Errorf("Album %s is not writeable", album.Title)
 	}
 	return p.add(ctx, uploadItems, photoslibrary.BatchCreateMediaItemsRequest{
 		AlbumId:       album.Id,
-		AlbumPosition: &photoslibrary.AlbumPosition{Position: "LAST_IN_ALBUM"},
+		AlbumPosition: p.albumPosition(),
 	}), nil
 }
 
@@ -50,16 +190,18 @@ func (p *Photos) AddToAlbum(ctx context.Context, title string, uploadItems []Upl
 // This method tries uploading all items and ignores any error.
 // If no item could be uploaded, this method returns an error.
 func (p *Photos) CreateAlbum(ctx context.Context, title string, uploadItems []UploadItem) ([]*AddResult, error) {
-	log.Printf("Creating album %s", title)
-	album, err := p.service.CreateAlbum(ctx, &photoslibrary.CreateAlbumRequest{
-		Album: &photoslibrary.Album{Title: title},
-	})
+	unlock, err := p.lockAlbum(ctx, title)
 	if err != nil {
-		return nil, fmt.Errorf("Could not create an album: %s", err)
+		return nil, err
+	}
+	album, err := p.createAlbum(ctx, title)
+	unlock()
+	if err != nil {
+		return nil, err
 	}
 	return p.add(ctx, uploadItems, photoslibrary.BatchCreateMediaItemsRequest{
 		AlbumId:       album.Id,
-		AlbumPosition: &photoslibrary.AlbumPosition{Position: "LAST_IN_ALBUM"},
+		AlbumPosition: p.albumPosition(),
 	}), nil
 }
 
@@ -79,26 +221,46 @@ func (p *Photos) add(ctx context.Context, uploadItems []UploadItem, req photosli
 		for _, item := range batch {
 			ut := &uploadTask{wg: &bt.wg, item: item}
 			bt.uploadTasks = append(bt.uploadTasks, ut)
+			if _, err := checkUploadItem(item); err != nil {
+				ut.err = err
+				bt.wg.Done()
+				continue
+			}
 			uploadQueue <- ut
 		}
 	}
 	close(uploadQueue)
-	log.Printf("Queued %d item(s)", len(uploadQueue))
+	p.log().Info("Queued items", "count", len(uploadQueue))
 
-	for i := 0; i < uploadConcurrency; i++ {
+	metrics := p.metricsOrNop()
+	for i := 0; i < p.uploadConcurrency(); i++ {
 		go func() {
 			for ut := range uploadQueue {
+				metrics.AddInFlight(1)
 				ut.token, ut.err = p.service.Upload(ctx, ut.item)
+				metrics.AddInFlight(-1)
+				if ut.err == nil {
+					metrics.IncItems(ItemUploaded)
+				}
 				ut.wg.Done()
 			}
 		}()
 	}
+	position := req.AlbumPosition
 	for _, bt := range batchCreateTasks {
 		bt.wg.Wait()
-		req.NewMediaItems = bt.toNewMediaItems()
-		if len(req.NewMediaItems) > 0 {
-			log.Printf("Adding %d item(s)", len(req.NewMediaItems))
-			bt.res, bt.err = p.service.BatchCreate(ctx, &req)
+		r := req
+		r.NewMediaItems = bt.toNewMediaItems()
+		r.AlbumPosition = position
+		if len(r.NewMediaItems) > 0 {
+			p.log().Info("Adding items", "album-id", r.AlbumId, "count", len(r.NewMediaItems))
+			bt.res, bt.err = p.service.BatchCreate(ctx, &r)
+			// place the next batch after this batch to keep the order of items
+			if position != nil && position.Position != "LAST_IN_ALBUM" {
+				if id := bt.lastMediaItemID(); id != "" {
+					position = &photoslibrary.AlbumPosition{Position: "AFTER_MEDIA_ITEM", RelativeMediaItemId: id}
+				}
+			}
 		}
 	}
 
@@ -119,11 +281,30 @@ func (p *Photos) add(ctx context.Context, uploadItems []UploadItem, req photosli
 					r.MediaItem = mr.MediaItem
 				}
 			}
+			if r.Error != nil {
+				metrics.IncItems(ItemFailed)
+			} else {
+				metrics.IncItems(ItemAdded)
+			}
 		}
 	}
 	return results
 }
 
+func (p *Photos) log() logging.Logger {
+	if p.logger != nil {
+		return p.logger
+	}
+	return logging.Default
+}
+
+func (p *Photos) metricsOrNop() Metrics {
+	if p.metrics != nil {
+		return p.metrics
+	}
+	return internal.NopMetrics{}
+}
+
 func split(items []UploadItem, n int) [][]UploadItem {
 	var batch []UploadItem
 	var batches [][]UploadItem
@@ -148,15 +329,33 @@ func (bt *batchCreateTask) toNewMediaItems() []*photoslibrary.NewMediaItem {
 	ret := make([]*photoslibrary.NewMediaItem, 0)
 	for _, ut := range bt.uploadTasks {
 		if ut.token != "" {
+			description := ut.item.Name()
+			if d, ok := ut.item.(DescribedUploadItem); ok {
+				description = d.Description()
+			}
 			ret = append(ret, &photoslibrary.NewMediaItem{
 				SimpleMediaItem: &photoslibrary.SimpleMediaItem{UploadToken: string(ut.token)},
-				Description:     ut.item.Name(),
+				Description:     description,
 			})
 		}
 	}
 	return ret
 }
 
+// lastMediaItemID returns ID of the last media item created in the batch.
+func (bt *batchCreateTask) lastMediaItemID() string {
+	if bt.res == nil {
+		return ""
+	}
+	var id string
+	for _, r := range bt.res.NewMediaItemResults {
+		if r.MediaItem != nil && r.MediaItem.Id != "" && (r.Status == nil || r.Status.Code == 0) {
+			id = r.MediaItem.Id
+		}
+	}
+	return id
+}
+
 func (bt *batchCreateTask) toNewMediaItemResultMap() map[internal.UploadToken]*photoslibrary.NewMediaItemResult {
 	m := make(map[internal.UploadToken]*photoslibrary.NewMediaItemResult)
 	if bt.res == nil {
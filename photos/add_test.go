@@ -7,10 +7,15 @@ import (
 	"io"
 	"io/ioutil"
 	"log"
+	"reflect"
+	"sync"
 	"sync/atomic"
 	"testing"
+	"time"
 
 	"github.com/int128/gpup/photos/internal"
+	"github.com/int128/gpup/photos/photostest"
+	"google.golang.org/api/googleapi"
 	photoslibrary "google.golang.org/api/photoslibrary/v1"
 )
 
@@ -20,6 +25,11 @@ type serviceMock struct {
 	batchCreateErrorFunc  func(*photoslibrary.BatchCreateMediaItemsRequest) error
 	batchCreateStatusFunc func(*photoslibrary.NewMediaItem) *photoslibrary.Status
 	batchCreateCalls      []*photoslibrary.BatchCreateMediaItemsRequest
+	albums                []*photoslibrary.Album
+	sharedAlbums          []*photoslibrary.Album
+	unlistedAlbums        []*photoslibrary.Album // albums not in the list yet
+	getAlbumCalls         int
+	listAlbumsCalls       int
 }
 
 func (m *serviceMock) Upload(ctx context.Context, u internal.UploadItem) (internal.UploadToken, error) {
@@ -53,18 +63,87 @@ func (m *serviceMock) BatchCreate(ctx context.Context, r *photoslibrary.BatchCre
 		results[i] = &photoslibrary.NewMediaItemResult{
 			Status:      s,
 			UploadToken: item.SimpleMediaItem.UploadToken,
-			MediaItem:   &photoslibrary.MediaItem{Description: item.SimpleMediaItem.UploadToken},
+			MediaItem:   &photoslibrary.MediaItem{Id: item.SimpleMediaItem.UploadToken, Description: item.SimpleMediaItem.UploadToken},
 		}
 	}
 	return &photoslibrary.BatchCreateMediaItemsResponse{NewMediaItemResults: results}, nil
 }
 
-func (m *serviceMock) CreateAlbum(context.Context, *photoslibrary.CreateAlbumRequest) (*photoslibrary.Album, error) {
-	return nil, fmt.Errorf("CreateAlbum not implemented")
+func (m *serviceMock) CreateAlbum(ctx context.Context, r *photoslibrary.CreateAlbumRequest) (*photoslibrary.Album, error) {
+	album := &photoslibrary.Album{
+		Id:          fmt.Sprintf("created%d", len(m.albums)),
+		Title:       r.Album.Title,
+		IsWriteable: true,
+	}
+	m.albums = append(m.albums, album)
+	return album, nil
+}
+
+func (m *serviceMock) GetAlbum(ctx context.Context, id string) (*photoslibrary.Album, error) {
+	m.getAlbumCalls++
+	for _, album := range append(append(m.albums, m.sharedAlbums...), m.unlistedAlbums...) {
+		if album.Id == id {
+			return album, nil
+		}
+	}
+	return nil, fmt.Errorf("Album %s not found", id)
+}
+
+func (m *serviceMock) ListAlbums(ctx context.Context, pageSize int64, pageToken string, fields ...googleapi.Field) (*photoslibrary.ListAlbumsResponse, error) {
+	m.listAlbumsCalls++
+	albums, next, err := pageOf(m.albums, pageSize, pageToken)
+	if err != nil {
+		return nil, err
+	}
+	return &photoslibrary.ListAlbumsResponse{Albums: albums, NextPageToken: next}, nil
+}
+
+func (m *serviceMock) ListSharedAlbums(ctx context.Context, pageSize int64, pageToken string, fields ...googleapi.Field) (*photoslibrary.ListSharedAlbumsResponse, error) {
+	albums, next, err := pageOf(m.sharedAlbums, pageSize, pageToken)
+	if err != nil {
+		return nil, err
+	}
+	return &photoslibrary.ListSharedAlbumsResponse{SharedAlbums: albums, NextPageToken: next}, nil
+}
+
+func (m *serviceMock) JoinSharedAlbum(ctx context.Context, shareToken string) error {
+	for _, album := range m.sharedAlbums {
+		if album.ShareInfo != nil && album.ShareInfo.ShareToken == shareToken {
+			return nil
+		}
+	}
+	return fmt.Errorf("Invalid share token")
+}
+
+func TestIsInsufficientScope(t *testing.T) {
+	for _, c := range []struct {
+		err  error
+		want bool
+	}{
+		{&googleapi.Error{Code: 403, Message: "Request had insufficient authentication scopes."}, true},
+		{&googleapi.Error{Code: 403, Message: "The caller does not have permission"}, false},
+		{&googleapi.Error{Code: 400, Message: "Invalid scope"}, false},
+		{fmt.Errorf("scope"), false},
+	} {
+		if got := isInsufficientScope(c.err); c.want != got {
+			t.Errorf("isInsufficientScope(%v) wants %v but %v", c.err, c.want, got)
+		}
+	}
 }
 
-func (m *serviceMock) ListAlbums(context.Context, int64, string) (*photoslibrary.ListAlbumsResponse, error) {
-	return nil, fmt.Errorf("ListAlbums not implemented")
+// pageOf returns the page of albums where pageToken is the offset.
+func pageOf(albums []*photoslibrary.Album, pageSize int64, pageToken string) ([]*photoslibrary.Album, string, error) {
+	var offset int
+	if pageToken != "" {
+		if _, err := fmt.Sscanf(pageToken, "%d", &offset); err != nil {
+			return nil, "", fmt.Errorf("Invalid page token %s", pageToken)
+		}
+	}
+	end := offset + int(pageSize)
+	if end >= len(albums) {
+		return albums[offset:], "", nil
+	}
+	return albums[offset:end], fmt.Sprintf("%d", end), nil
 }
 
 type uploadItemMock int
@@ -127,6 +206,58 @@ func TestPhotos_add(t *testing.T) {
 	}
 }
 
+func TestPhotos_add_AlbumPosition(t *testing.T) {
+	defer func(restore int) { batchCreateSize = restore }(batchCreateSize)
+	batchCreateSize = 10
+
+	for _, c := range []struct {
+		position  *photoslibrary.AlbumPosition
+		positions []photoslibrary.AlbumPosition
+	}{
+		{
+			position: &photoslibrary.AlbumPosition{Position: "LAST_IN_ALBUM"},
+			positions: []photoslibrary.AlbumPosition{
+				{Position: "LAST_IN_ALBUM"},
+				{Position: "LAST_IN_ALBUM"},
+				{Position: "LAST_IN_ALBUM"},
+			},
+		},
+		{
+			position: &photoslibrary.AlbumPosition{Position: "FIRST_IN_ALBUM"},
+			positions: []photoslibrary.AlbumPosition{
+				{Position: "FIRST_IN_ALBUM"},
+				{Position: "AFTER_MEDIA_ITEM", RelativeMediaItemId: "UploadItem#9"},
+				{Position: "AFTER_MEDIA_ITEM", RelativeMediaItemId: "UploadItem#19"},
+			},
+		},
+		{
+			position: &photoslibrary.AlbumPosition{Position: "AFTER_ENRICHMENT_ITEM", RelativeEnrichmentItemId: "E1"},
+			positions: []photoslibrary.AlbumPosition{
+				{Position: "AFTER_ENRICHMENT_ITEM", RelativeEnrichmentItemId: "E1"},
+				{Position: "AFTER_MEDIA_ITEM", RelativeMediaItemId: "UploadItem#9"},
+				{Position: "AFTER_MEDIA_ITEM", RelativeMediaItemId: "UploadItem#19"},
+			},
+		},
+	} {
+		t.Run(c.position.Position, func(t *testing.T) {
+			var m serviceMock
+			p := &Photos{service: &m}
+			p.add(context.Background(), makeUploadItems(25), photoslibrary.BatchCreateMediaItemsRequest{
+				AlbumId:       "ALBUM",
+				AlbumPosition: c.position,
+			})
+			if len(m.batchCreateCalls) != len(c.positions) {
+				t.Fatalf("BatchCreate API call wants %d times but %d", len(c.positions), len(m.batchCreateCalls))
+			}
+			for i, r := range m.batchCreateCalls {
+				if !reflect.DeepEqual(*r.AlbumPosition, c.positions[i]) {
+					t.Errorf("AlbumPosition[%d] wants %+v but %+v", i, c.positions[i], *r.AlbumPosition)
+				}
+			}
+		})
+	}
+}
+
 func TestPhotos_add_error(t *testing.T) {
 	defer func(restore int) { batchCreateSize = restore }(batchCreateSize)
 	batchCreateSize = 10
@@ -183,3 +314,96 @@ func TestPhotos_add_error(t *testing.T) {
 		})
 	}
 }
+
+func TestPhotos_AddToAlbum_Server(t *testing.T) {
+	defer func(restore int) { batchCreateSize = restore }(batchCreateSize)
+	batchCreateSize = 10
+
+	s := photostest.NewServer()
+	defer s.Close()
+	p, err := New(s.Client())
+	if err != nil {
+		t.Fatal(err)
+	}
+	p.AlbumPosition = &photoslibrary.AlbumPosition{Position: "FIRST_IN_ALBUM"}
+	results, err := p.AddToAlbum(context.Background(), "Trip", makeUploadItems(25))
+	if err != nil {
+		t.Fatalf("AddToAlbum returns error: %s", err)
+	}
+	var ids []string
+	for i, r := range results {
+		if r.Error != nil {
+			t.Fatalf("results[%d] returns error: %s", i, r.Error)
+		}
+		ids = append(ids, r.MediaItem.Id)
+	}
+	albums := s.Albums()
+	if len(albums) != 1 || albums[0].Title != "Trip" {
+		t.Fatalf("Albums wants Trip but %+v", albums)
+	}
+	if got := s.AlbumEntries(albums[0].Id); !reflect.DeepEqual(ids, got) {
+		t.Errorf("AlbumEntries wants %v but %v", ids, got)
+	}
+}
+
+type metricsRecorder struct {
+	internal.NopMetrics
+	mu            sync.Mutex
+	latency       map[string]int
+	uploadedBytes int64
+	items         map[string]int
+	inFlight      int
+	maxInFlight   int
+}
+
+func (m *metricsRecorder) ObserveLatency(operation string, d time.Duration) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.latency[operation]++
+}
+
+func (m *metricsRecorder) AddUploadedBytes(n int64) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.uploadedBytes += n
+}
+
+func (m *metricsRecorder) IncItems(state string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.items[state]++
+}
+
+func (m *metricsRecorder) AddInFlight(delta int) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.inFlight += delta
+	if m.inFlight > m.maxInFlight {
+		m.maxInFlight = m.inFlight
+	}
+}
+
+func TestPhotos_AddToLibrary_Metrics(t *testing.T) {
+	s := photostest.NewServer()
+	defer s.Close()
+	m := &metricsRecorder{latency: make(map[string]int), items: make(map[string]int)}
+	p, err := NewWithOptions(s.Client(), Options{Metrics: m})
+	if err != nil {
+		t.Fatal(err)
+	}
+	p.Concurrency = 2
+	p.AddToLibrary(context.Background(), makeUploadItems(3))
+
+	if want := map[string]int{"Upload": 3, "BatchCreate": 1}; !reflect.DeepEqual(want, m.latency) {
+		t.Errorf("latency wants %v but %v", want, m.latency)
+	}
+	if want := map[string]int{ItemUploaded: 3, ItemAdded: 3}; !reflect.DeepEqual(want, m.items) {
+		t.Errorf("items wants %v but %v", want, m.items)
+	}
+	if want := int64(len("UploadItem#0") * 3); m.uploadedBytes != want {
+		t.Errorf("uploadedBytes wants %d but %d", want, m.uploadedBytes)
+	}
+	if m.inFlight != 0 || m.maxInFlight < 1 || m.maxInFlight > 2 {
+		t.Errorf("inFlight wants 0 and max 1..2 but %d and %d", m.inFlight, m.maxInFlight)
+	}
+}
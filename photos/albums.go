@@ -3,20 +3,46 @@ package photos
 import (
 	"context"
 	"fmt"
+	"strings"
+	"time"
 
+	"google.golang.org/api/googleapi"
 	photoslibrary "google.golang.org/api/photoslibrary/v1"
 )
 
-// ListAlbumsFunc is called for each response of 50 albums.
+// ListAlbumsFunc is called for each page of albums.
 // If this calls stop, ListAlbums stops the loop.
 type ListAlbumsFunc func(albums []*photoslibrary.Album, stop func())
 
+// ListAlbumsOptions represents options for listing albums.
+type ListAlbumsOptions struct {
+	// PageSize is the number of albums in a page. Default to 50, which is the maximum.
+	PageSize int64
+	// Fields is a field mask for partial response, e.g. "nextPageToken,albums(id,title)".
+	// Note that nextPageToken is required to get the next page.
+	// Default to all fields.
+	Fields []googleapi.Field
+}
+
+func (o ListAlbumsOptions) pageSize() int64 {
+	if o.PageSize > 0 {
+		return o.PageSize
+	}
+	return 50
+}
+
 // ListAlbums gets a list of albums.
 // It calls the function for each 50 albums.
 func (p *Photos) ListAlbums(ctx context.Context, callback ListAlbumsFunc) error {
+	return p.ListAlbumsWithOptions(ctx, ListAlbumsOptions{}, callback)
+}
+
+// ListAlbumsWithOptions gets a list of albums.
+// It calls the function for each page.
+func (p *Photos) ListAlbumsWithOptions(ctx context.Context, o ListAlbumsOptions, callback ListAlbumsFunc) error {
 	var pageToken string
 	for {
-		res, err := p.service.ListAlbums(ctx, 50, pageToken)
+		res, err := p.service.ListAlbums(ctx, o.pageSize(), pageToken, o.Fields...)
 		if err != nil {
 			return fmt.Errorf("Error while listing albums: %s", err)
 		}
@@ -32,21 +58,243 @@ func (p *Photos) ListAlbums(ctx context.Context, callback ListAlbumsFunc) error
 	}
 }
 
+// ListSharedAlbums gets a list of albums shared with you.
+// It calls the function for each 50 albums.
+func (p *Photos) ListSharedAlbums(ctx context.Context, callback ListAlbumsFunc) error {
+	return p.ListSharedAlbumsWithOptions(ctx, ListAlbumsOptions{}, callback)
+}
+
+// ListSharedAlbumsWithOptions gets a list of albums shared with you.
+// It calls the function for each page.
+// Fields of the options should be in form of "nextPageToken,sharedAlbums(...)".
+func (p *Photos) ListSharedAlbumsWithOptions(ctx context.Context, o ListAlbumsOptions, callback ListAlbumsFunc) error {
+	var pageToken string
+	for {
+		res, err := p.service.ListSharedAlbums(ctx, o.pageSize(), pageToken, o.Fields...)
+		if err != nil {
+			return fmt.Errorf("Error while listing shared albums: %s", err)
+		}
+		var stop bool
+		callback(res.SharedAlbums, func() { stop = true })
+		if stop {
+			return nil
+		}
+		if res.NextPageToken == "" {
+			return nil
+		}
+		pageToken = res.NextPageToken
+	}
+}
+
+// AlbumCache stores IDs of albums by title.
+type AlbumCache interface {
+	// Get returns IDs of the albums which have the title.
+	// It returns false if the title is not cached or has been expired.
+	// Since another client may create an album after caching,
+	// a title not in the cache should be treated as unknown rather than no album.
+	Get(title string) ([]string, bool)
+	// Replace replaces all entries with the albums, i.e. IDs by title.
+	Replace(albums map[string][]string)
+	// Invalidate removes the entry of the title.
+	Invalidate(title string)
+}
+
+const albumFields = "id,title,isWriteable,totalMediaItems,productUrl,shareInfo"
+
 // FindAlbumByTitle returns the album which has the title.
+// It finds your albums and albums shared with you.
+// A writeable album is preferred over read-only albums.
+// If multiple writeable albums have the title, DuplicateAlbumPolicy chooses one of them.
 // If the album was not found, it returns nil.
 // If any error occurred, it returns the error.
+//
+// If AlbumCache is set, it gets the cached albums instead of listing all albums.
 func (p *Photos) FindAlbumByTitle(ctx context.Context, title string) (*photoslibrary.Album, error) {
+	if p.AlbumCache != nil {
+		if ids, ok := p.AlbumCache.Get(title); ok && len(ids) > 0 {
+			albums, err := p.getAlbumsOfTitle(ctx, title, ids)
+			if err == nil {
+				return p.chooseAlbum(title, albums)
+			}
+			p.log().Info("Cache of the album is stale", "album", title, "error", err)
+		}
+	}
+
+	var albums []*photoslibrary.Album
+	titles := make(map[string][]string)
+	seen := make(map[string]bool)
+	find := func(page []*photoslibrary.Album, stop func()) {
+		for _, album := range page {
+			if seen[album.Id] {
+				continue
+			}
+			seen[album.Id] = true
+			titles[album.Title] = append(titles[album.Title], album.Id)
+			if album.Title == title {
+				albums = append(albums, album)
+			}
+		}
+	}
+	if err := p.ListAlbumsWithOptions(ctx, ListAlbumsOptions{
+		Fields: []googleapi.Field{"nextPageToken,albums(" + albumFields + ")"},
+	}, find); err != nil {
+		return nil, fmt.Errorf("Could not find the album %s: %s", title, err)
+	}
+	if err := p.ListSharedAlbumsWithOptions(ctx, ListAlbumsOptions{
+		Fields: []googleapi.Field{"nextPageToken,sharedAlbums(" + albumFields + ")"},
+	}, find); err != nil {
+		return nil, fmt.Errorf("Could not find the album %s: %s", title, err)
+	}
+	if p.AlbumCache != nil {
+		p.AlbumCache.Replace(titles)
+	}
+	return p.chooseAlbum(title, albums)
+}
+
+// getAlbumsOfTitle gets the albums of the IDs.
+// It returns an error if any album does not exist or does not have the title.
+func (p *Photos) getAlbumsOfTitle(ctx context.Context, title string, ids []string) ([]*photoslibrary.Album, error) {
+	var albums []*photoslibrary.Album
+	for _, id := range ids {
+		album, err := p.service.GetAlbum(ctx, id)
+		if err != nil {
+			return nil, fmt.Errorf("Could not get the album %s: %s", id, err)
+		}
+		if album.Title != title {
+			return nil, fmt.Errorf("Title of the album %s has been changed to %s", id, album.Title)
+		}
+		albums = append(albums, album)
+	}
+	return albums, nil
+}
+
+// chooseAlbum returns an album in the albums which have the title.
+// A writeable album takes precedence over read-only albums.
+// If multiple read-only albums have the title, it returns a DuplicateAlbumsError
+// because none of them can be chosen by the policy.
+func (p *Photos) chooseAlbum(title string, albums []*photoslibrary.Album) (*photoslibrary.Album, error) {
+	var writeable, readOnly []*photoslibrary.Album
+	for _, album := range albums {
+		if album.IsWriteable {
+			writeable = append(writeable, album)
+		} else {
+			readOnly = append(readOnly, album)
+		}
+	}
+	switch {
+	case len(writeable) == 1:
+		return writeable[0], nil
+	case len(writeable) > 1:
+		policy := p.DuplicateAlbumPolicy
+		if policy == nil {
+			policy = FailOnDuplicateAlbums
+		}
+		return policy(title, writeable)
+	case len(readOnly) == 1:
+		return readOnly[0], nil
+	case len(readOnly) > 1:
+		return nil, &DuplicateAlbumsError{Title: title, Albums: readOnly, Reason: "read-only"}
+	}
+	return nil, nil
+}
+
+// GetAlbum returns the album of the ID.
+func (p *Photos) GetAlbum(ctx context.Context, id string) (*photoslibrary.Album, error) {
+	album, err := p.service.GetAlbum(ctx, id)
+	if err != nil {
+		return nil, fmt.Errorf("Could not get the album %s: %s", id, err)
+	}
+	return album, nil
+}
+
+// DuplicateAlbumPolicy chooses an album from the albums which have the same title.
+type DuplicateAlbumPolicy func(title string, albums []*photoslibrary.Album) (*photoslibrary.Album, error)
+
+// DuplicateAlbumsError represents that multiple albums have the same title.
+type DuplicateAlbumsError struct {
+	Title  string
+	Albums []*photoslibrary.Album
+	Reason string
+}
+
+func (e *DuplicateAlbumsError) Error() string {
+	var ids []string
+	for _, album := range e.Albums {
+		ids = append(ids, album.Id)
+	}
+	return fmt.Sprintf("Found %d albums titled %s (%s): %s", len(e.Albums), e.Title, e.Reason, strings.Join(ids, ", "))
+}
+
+// FailOnDuplicateAlbums is a policy which always returns an error.
+func FailOnDuplicateAlbums(title string, albums []*photoslibrary.Album) (*photoslibrary.Album, error) {
+	return nil, &DuplicateAlbumsError{Title: title, Albums: albums, Reason: "ambiguous"}
+}
+
+// MostItemsAlbum is a policy which chooses the album having the most items.
+func MostItemsAlbum(title string, albums []*photoslibrary.Album) (*photoslibrary.Album, error) {
+	var most *photoslibrary.Album
+	for _, album := range albums {
+		if most == nil || album.TotalMediaItems > most.TotalMediaItems {
+			most = album
+		}
+	}
+	return most, nil
+}
+
+// NewestAlbum returns a policy which chooses the most recently created album.
+// Since the API does not provide creation time of albums,
+// the time must be given for the albums, e.g. albums created by this tool.
+// Albums without the time are treated as older.
+func NewestAlbum(createdAt map[string]time.Time) DuplicateAlbumPolicy {
+	return func(title string, albums []*photoslibrary.Album) (*photoslibrary.Album, error) {
+		var newest *photoslibrary.Album
+		for _, album := range albums {
+			t, ok := createdAt[album.Id]
+			if ok && (newest == nil || t.After(createdAt[newest.Id])) {
+				newest = album
+			}
+		}
+		if newest == nil {
+			return nil, &DuplicateAlbumsError{Title: title, Albums: albums, Reason: "creation time is unknown"}
+		}
+		return newest, nil
+	}
+}
+
+// CreatedAlbum returns a policy which chooses the album in the set of IDs, e.g. albums created by this tool.
+// It returns an error if no album or multiple albums are in the set.
+func CreatedAlbum(ids map[string]time.Time) DuplicateAlbumPolicy {
+	return func(title string, albums []*photoslibrary.Album) (*photoslibrary.Album, error) {
+		var created []*photoslibrary.Album
+		for _, album := range albums {
+			if _, ok := ids[album.Id]; ok {
+				created = append(created, album)
+			}
+		}
+		switch len(created) {
+		case 0:
+			return nil, &DuplicateAlbumsError{Title: title, Albums: albums, Reason: "none of them was created by this tool"}
+		case 1:
+			return created[0], nil
+		}
+		return nil, &DuplicateAlbumsError{Title: title, Albums: created, Reason: "all of them were created by this tool"}
+	}
+}
+
+// FindSharedAlbumByShareToken returns the shared album which has the share token.
+// If the album was not found, it returns nil.
+func (p *Photos) FindSharedAlbumByShareToken(ctx context.Context, shareToken string) (*photoslibrary.Album, error) {
 	var matched *photoslibrary.Album
-	if err := p.ListAlbums(ctx, func(albums []*photoslibrary.Album, stop func()) {
+	if err := p.ListSharedAlbums(ctx, func(albums []*photoslibrary.Album, stop func()) {
 		for _, album := range albums {
-			if album.Title == title {
+			if album.ShareInfo != nil && album.ShareInfo.ShareToken == shareToken {
 				stop()
 				matched = album
 				return
 			}
 		}
 	}); err != nil {
-		return nil, fmt.Errorf("Could not find the album %s: %s", title, err)
+		return nil, fmt.Errorf("Could not find the shared album: %s", err)
 	}
 	return matched, nil
 }
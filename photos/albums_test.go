@@ -0,0 +1,301 @@
+package photos
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	photoslibrary "google.golang.org/api/photoslibrary/v1"
+)
+
+func makeAlbums(n int, prefix string) []*photoslibrary.Album {
+	albums := make([]*photoslibrary.Album, n)
+	for i := range albums {
+		albums[i] = &photoslibrary.Album{
+			Id:          fmt.Sprintf("%s%d", prefix, i),
+			Title:       fmt.Sprintf("Album %d", i),
+			IsWriteable: true,
+		}
+	}
+	return albums
+}
+
+func TestPhotos_FindAlbumByTitle(t *testing.T) {
+	m := &serviceMock{
+		albums:       makeAlbums(120, "own"),
+		sharedAlbums: []*photoslibrary.Album{{Id: "shared0", Title: "Team Offsite", ShareInfo: &photoslibrary.ShareInfo{ShareToken: "TOKEN"}, IsWriteable: true}},
+	}
+	m.albums[1].IsWriteable = false
+	m.albums = append(m.albums, &photoslibrary.Album{Id: "own-dup", Title: "Album 1", IsWriteable: true})
+	p := &Photos{service: m}
+
+	for _, c := range []struct {
+		title string
+		id    string
+	}{
+		{"Album 0", "own0"},
+		{"Album 99", "own99"},
+		{"Album 1", "own-dup"},
+		{"Team Offsite", "shared0"},
+		{"Nothing", ""},
+	} {
+		t.Run(c.title, func(t *testing.T) {
+			album, err := p.FindAlbumByTitle(context.Background(), c.title)
+			if err != nil {
+				t.Fatalf("FindAlbumByTitle returns error: %s", err)
+			}
+			var id string
+			if album != nil {
+				id = album.Id
+			}
+			if c.id != id {
+				t.Errorf("id wants %s but %s", c.id, id)
+			}
+		})
+	}
+}
+
+func TestPhotos_AddToSharedAlbum(t *testing.T) {
+	m := &serviceMock{
+		sharedAlbums: []*photoslibrary.Album{
+			{Id: "shared0", Title: "Read only", ShareInfo: &photoslibrary.ShareInfo{ShareToken: "TOKEN0"}},
+			{Id: "shared1", Title: "Team Offsite", ShareInfo: &photoslibrary.ShareInfo{ShareToken: "TOKEN1"}, IsWriteable: true},
+		},
+	}
+	p := &Photos{service: m}
+	results, err := p.AddToSharedAlbum(context.Background(), "TOKEN1", makeUploadItems(3))
+	if err != nil {
+		t.Fatalf("AddToSharedAlbum returns error: %s", err)
+	}
+	if len(results) != 3 {
+		t.Errorf("len(results) wants 3 but %d", len(results))
+	}
+	if len(m.batchCreateCalls) != 1 || m.batchCreateCalls[0].AlbumId != "shared1" {
+		t.Errorf("BatchCreate wants album shared1 but %+v", m.batchCreateCalls)
+	}
+	if _, err := p.AddToSharedAlbum(context.Background(), "TOKEN0", makeUploadItems(3)); err == nil {
+		t.Errorf("AddToSharedAlbum wants error for read only album but nil")
+	}
+}
+
+func TestPhotos_FindAlbumByTitle_DuplicateReadOnly(t *testing.T) {
+	m := &serviceMock{
+		albums: []*photoslibrary.Album{
+			{Id: "a", Title: "Trip"},
+			{Id: "b", Title: "Trip"},
+		},
+	}
+	p := &Photos{service: m, DuplicateAlbumPolicy: MostItemsAlbum}
+	_, err := p.FindAlbumByTitle(context.Background(), "Trip")
+	if e, ok := err.(*DuplicateAlbumsError); !ok || len(e.Albums) != 2 {
+		t.Errorf("FindAlbumByTitle wants DuplicateAlbumsError of 2 albums but %+v", err)
+	}
+
+	m.albums = append(m.albums, &photoslibrary.Album{Id: "c", Title: "Trip", IsWriteable: true})
+	album, err := p.FindAlbumByTitle(context.Background(), "Trip")
+	if err != nil {
+		t.Fatalf("FindAlbumByTitle returned error: %s", err)
+	}
+	if album.Id != "c" {
+		t.Errorf("FindAlbumByTitle wants the writeable album c but %s", album.Id)
+	}
+}
+
+func TestPhotos_FindAlbumByTitle_Duplicate(t *testing.T) {
+	m := &serviceMock{
+		albums: []*photoslibrary.Album{
+			{Id: "a", Title: "Trip", IsWriteable: true, TotalMediaItems: 3},
+			{Id: "b", Title: "Trip", IsWriteable: true, TotalMediaItems: 10},
+			{Id: "c", Title: "Trip", IsWriteable: true, TotalMediaItems: 5},
+		},
+	}
+	m.sharedAlbums = m.albums[1:2]
+	createdAt := map[string]time.Time{
+		"a": time.Date(2018, 1, 1, 0, 0, 0, 0, time.UTC),
+		"c": time.Date(2018, 6, 1, 0, 0, 0, 0, time.UTC),
+	}
+	for _, c := range []struct {
+		name   string
+		policy DuplicateAlbumPolicy
+		id     string
+	}{
+		{"default", nil, ""},
+		{"MostItemsAlbum", MostItemsAlbum, "b"},
+		{"NewestAlbum", NewestAlbum(createdAt), "c"},
+		{"NewestAlbum/unknown", NewestAlbum(nil), ""},
+		{"CreatedAlbum", CreatedAlbum(map[string]time.Time{"b": {}}), "b"},
+		{"CreatedAlbum/multiple", CreatedAlbum(createdAt), ""},
+	} {
+		t.Run(c.name, func(t *testing.T) {
+			p := &Photos{service: m, DuplicateAlbumPolicy: c.policy}
+			album, err := p.FindAlbumByTitle(context.Background(), "Trip")
+			if c.id == "" {
+				if _, ok := err.(*DuplicateAlbumsError); !ok {
+					t.Errorf("FindAlbumByTitle wants DuplicateAlbumsError but %+v", err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("FindAlbumByTitle returns error: %s", err)
+			}
+			if album.Id != c.id {
+				t.Errorf("id wants %s but %s", c.id, album.Id)
+			}
+		})
+	}
+}
+
+type albumCacheMock struct {
+	albums map[string][]string
+}
+
+func (c *albumCacheMock) Get(title string) ([]string, bool) {
+	if c.albums == nil {
+		return nil, false
+	}
+	ids, ok := c.albums[title]
+	return ids, ok
+}
+
+func (c *albumCacheMock) Replace(albums map[string][]string) { c.albums = albums }
+
+func (c *albumCacheMock) Invalidate(title string) { delete(c.albums, title) }
+
+func TestPhotos_FindAlbumByTitle_Cache(t *testing.T) {
+	m := &serviceMock{albums: makeAlbums(120, "own")}
+	cache := &albumCacheMock{}
+	p := &Photos{service: m, AlbumCache: cache}
+	ctx := context.Background()
+
+	album, err := p.FindAlbumByTitle(ctx, "Album 100")
+	if err != nil {
+		t.Fatalf("FindAlbumByTitle returns error: %s", err)
+	}
+	if album.Id != "own100" {
+		t.Errorf("id wants own100 but %s", album.Id)
+	}
+	if m.listAlbumsCalls != 3 {
+		t.Errorf("ListAlbums wants 3 calls but %d", m.listAlbumsCalls)
+	}
+	if len(cache.albums) != 120 {
+		t.Errorf("len(cache) wants 120 but %d", len(cache.albums))
+	}
+
+	// cache hit
+	album, err = p.FindAlbumByTitle(ctx, "Album 50")
+	if err != nil {
+		t.Fatalf("FindAlbumByTitle returns error: %s", err)
+	}
+	if album.Id != "own50" {
+		t.Errorf("id wants own50 but %s", album.Id)
+	}
+	if m.listAlbumsCalls != 3 {
+		t.Errorf("ListAlbums wants 3 calls but %d", m.listAlbumsCalls)
+	}
+	if m.getAlbumCalls != 1 {
+		t.Errorf("GetAlbum wants 1 call but %d", m.getAlbumCalls)
+	}
+
+	// stale cache
+	m.albums[50].Title = "Renamed"
+	album, err = p.FindAlbumByTitle(ctx, "Album 50")
+	if err != nil {
+		t.Fatalf("FindAlbumByTitle returns error: %s", err)
+	}
+	if album != nil {
+		t.Errorf("album wants nil but %+v", album)
+	}
+	if m.listAlbumsCalls != 6 {
+		t.Errorf("ListAlbums wants 6 calls but %d", m.listAlbumsCalls)
+	}
+
+	// created by another client after caching
+	m.albums = append(m.albums, &photoslibrary.Album{Id: "other", Title: "Other", IsWriteable: true})
+	album, err = p.FindAlbumByTitle(ctx, "Other")
+	if err != nil {
+		t.Fatalf("FindAlbumByTitle returns error: %s", err)
+	}
+	if album == nil || album.Id != "other" {
+		t.Errorf("album wants other but %+v", album)
+	}
+	if m.listAlbumsCalls != 9 {
+		t.Errorf("ListAlbums wants 9 calls but %d", m.listAlbumsCalls)
+	}
+
+	// invalidated by creation
+	if _, err := p.AddToAlbum(ctx, "New Album", makeUploadItems(1)); err != nil {
+		t.Fatalf("AddToAlbum returns error: %s", err)
+	}
+	if _, ok := cache.Get("New Album"); ok {
+		t.Errorf("cache of New Album wants invalidated but exists")
+	}
+}
+
+type albumRecorderMock struct {
+	created []*photoslibrary.Album
+}
+
+func (r *albumRecorderMock) Created(album *photoslibrary.Album) { r.created = append(r.created, album) }
+
+func (r *albumRecorderMock) Find(title string) []string {
+	var ids []string
+	for _, album := range r.created {
+		if album.Title == title {
+			ids = append(ids, album.Id)
+		}
+	}
+	return ids
+}
+
+type albumLockerMock struct {
+	locked bool
+	calls  int
+}
+
+func (l *albumLockerMock) LockAlbum(ctx context.Context, title string) (func(), error) {
+	if l.locked {
+		return nil, fmt.Errorf("already locked")
+	}
+	l.locked = true
+	l.calls++
+	return func() { l.locked = false }, nil
+}
+
+func TestPhotos_AddToAlbum_Recorded(t *testing.T) {
+	// an album which has been created but does not appear in the list yet
+	recorded := &photoslibrary.Album{Id: "recorded", Title: "Trip", IsWriteable: true}
+	m := &serviceMock{}
+	recorder := &albumRecorderMock{created: []*photoslibrary.Album{recorded}}
+	locker := &albumLockerMock{}
+	p := &Photos{service: m, AlbumRecorder: recorder, AlbumLocker: locker}
+	ctx := context.Background()
+
+	// GetAlbum fails because the mock does not know the album
+	if _, err := p.AddToAlbum(ctx, "Trip", makeUploadItems(1)); err != nil {
+		t.Fatalf("AddToAlbum returns error: %s", err)
+	}
+	if len(recorder.created) != 2 {
+		t.Fatalf("len(created) wants 2 but %d", len(recorder.created))
+	}
+	created := recorder.created[1]
+
+	// the created album is reused even if it does not appear in the list
+	m.albums = nil
+	m.unlistedAlbums = []*photoslibrary.Album{created}
+	if _, err := p.AddToAlbum(ctx, "Trip", makeUploadItems(1)); err != nil {
+		t.Fatalf("AddToAlbum returns error: %s", err)
+	}
+	if len(recorder.created) != 2 {
+		t.Errorf("len(created) wants 2 but %d", len(recorder.created))
+	}
+	if last := m.batchCreateCalls[len(m.batchCreateCalls)-1]; last.AlbumId != created.Id {
+		t.Errorf("AlbumId wants %s but %s", created.Id, last.AlbumId)
+	}
+	if locker.locked {
+		t.Errorf("locker wants unlocked but locked")
+	}
+	if locker.calls != 2 {
+		t.Errorf("LockAlbum wants 2 calls but %d", locker.calls)
+	}
+}
@@ -3,27 +3,36 @@ package internal
 import (
 	"context"
 	"fmt"
+	"time"
 
 	"github.com/lestrrat-go/backoff"
+	"google.golang.org/api/googleapi"
 	photoslibrary "google.golang.org/api/photoslibrary/v1"
 )
 
 type albumsService interface {
 	CreateAlbum(context.Context, *photoslibrary.CreateAlbumRequest) (*photoslibrary.Album, error)
-	ListAlbums(ctx context.Context, pageSize int64, pageToken string) (*photoslibrary.ListAlbumsResponse, error)
+	GetAlbum(ctx context.Context, id string) (*photoslibrary.Album, error)
+	ListAlbums(ctx context.Context, pageSize int64, pageToken string, fields ...googleapi.Field) (*photoslibrary.ListAlbumsResponse, error)
+	ListSharedAlbums(ctx context.Context, pageSize int64, pageToken string, fields ...googleapi.Field) (*photoslibrary.ListSharedAlbumsResponse, error)
+	JoinSharedAlbum(ctx context.Context, shareToken string) error
 }
 
 func (p *defaultPhotos) CreateAlbum(ctx context.Context, req *photoslibrary.CreateAlbumRequest) (*photoslibrary.Album, error) {
 	create := p.service.Albums.Create(req)
-	b, cancel := defaultRetryPolicy.Start(ctx)
+	b, cancel := p.retry().Start(ctx)
 	defer cancel()
-	for backoff.Continue(b) {
-		res, err := create.Do()
+	var cause string // cause of the last failure
+	for attempt := 1; backoff.Continue(b); attempt++ {
+		p.countRetry("CreateAlbum", cause)
+		start := time.Now()
+		res, err := create.Context(ctx).Do()
+		cause = p.observe("CreateAlbum", start, err)
 		switch {
 		case err == nil:
 			return res, nil
 		case IsRetryableError(err):
-			p.log.Printf("Error while creating an album: %s", err)
+			p.log.Warn("Error while creating an album", "attempt", attempt, "error", err)
 		default:
 			return nil, err
 		}
@@ -31,20 +40,100 @@ func (p *defaultPhotos) CreateAlbum(ctx context.Context, req *photoslibrary.Crea
 	return nil, fmt.Errorf("Retry over")
 }
 
-func (p *defaultPhotos) ListAlbums(ctx context.Context, pageSize int64, pageToken string) (*photoslibrary.ListAlbumsResponse, error) {
+// ListAlbums returns a page of albums.
+// If fields are given, it returns a partial response.
+func (p *defaultPhotos) ListAlbums(ctx context.Context, pageSize int64, pageToken string, fields ...googleapi.Field) (*photoslibrary.ListAlbumsResponse, error) {
 	list := p.service.Albums.List().PageSize(pageSize).PageToken(pageToken)
-	b, cancel := defaultRetryPolicy.Start(ctx)
+	if len(fields) > 0 {
+		list = list.Fields(fields...)
+	}
+	b, cancel := p.retry().Start(ctx)
+	defer cancel()
+	var cause string // cause of the last failure
+	for attempt := 1; backoff.Continue(b); attempt++ {
+		p.countRetry("ListAlbums", cause)
+		start := time.Now()
+		res, err := list.Context(ctx).Do()
+		cause = p.observe("ListAlbums", start, err)
+		switch {
+		case err == nil:
+			return res, nil
+		case IsRetryableError(err):
+			p.log.Warn("Error while listing albums", "attempt", attempt, "error", err)
+		default:
+			return nil, err
+		}
+	}
+	return nil, fmt.Errorf("Retry over")
+}
+
+func (p *defaultPhotos) GetAlbum(ctx context.Context, id string) (*photoslibrary.Album, error) {
+	get := p.service.Albums.Get(id)
+	b, cancel := p.retry().Start(ctx)
 	defer cancel()
-	for backoff.Continue(b) {
-		res, err := list.Do()
+	var cause string // cause of the last failure
+	for attempt := 1; backoff.Continue(b); attempt++ {
+		p.countRetry("GetAlbum", cause)
+		start := time.Now()
+		res, err := get.Context(ctx).Do()
+		cause = p.observe("GetAlbum", start, err)
 		switch {
 		case err == nil:
 			return res, nil
 		case IsRetryableError(err):
-			p.log.Printf("Error while listing albums: %s", err)
+			p.log.Warn("Error while getting the album", "album", id, "attempt", attempt, "error", err)
 		default:
 			return nil, err
 		}
 	}
 	return nil, fmt.Errorf("Retry over")
 }
+
+// ListSharedAlbums returns a page of albums shared with you.
+// If fields are given, it returns a partial response.
+func (p *defaultPhotos) ListSharedAlbums(ctx context.Context, pageSize int64, pageToken string, fields ...googleapi.Field) (*photoslibrary.ListSharedAlbumsResponse, error) {
+	list := p.service.SharedAlbums.List().PageSize(pageSize).PageToken(pageToken)
+	if len(fields) > 0 {
+		list = list.Fields(fields...)
+	}
+	b, cancel := p.retry().Start(ctx)
+	defer cancel()
+	var cause string // cause of the last failure
+	for attempt := 1; backoff.Continue(b); attempt++ {
+		p.countRetry("ListSharedAlbums", cause)
+		start := time.Now()
+		res, err := list.Context(ctx).Do()
+		cause = p.observe("ListSharedAlbums", start, err)
+		switch {
+		case err == nil:
+			return res, nil
+		case IsRetryableError(err):
+			p.log.Warn("Error while listing shared albums", "attempt", attempt, "error", err)
+		default:
+			return nil, err
+		}
+	}
+	return nil, fmt.Errorf("Retry over")
+}
+
+func (p *defaultPhotos) JoinSharedAlbum(ctx context.Context, shareToken string) error {
+	join := p.service.SharedAlbums.Join(&photoslibrary.JoinSharedAlbumRequest{ShareToken: shareToken})
+	b, cancel := p.retry().Start(ctx)
+	defer cancel()
+	var cause string // cause of the last failure
+	for attempt := 1; backoff.Continue(b); attempt++ {
+		p.countRetry("JoinSharedAlbum", cause)
+		start := time.Now()
+		_, err := join.Context(ctx).Do()
+		cause = p.observe("JoinSharedAlbum", start, err)
+		switch {
+		case err == nil:
+			return nil
+		case IsRetryableError(err):
+			p.log.Warn("Error while joining the shared album", "attempt", attempt, "error", err)
+		default:
+			return err
+		}
+	}
+	return fmt.Errorf("Retry over")
+}
@@ -3,6 +3,7 @@ package internal
 import (
 	"context"
 	"fmt"
+	"time"
 
 	"github.com/lestrrat-go/backoff"
 	photoslibrary "google.golang.org/api/photoslibrary/v1"
@@ -16,15 +17,19 @@ type mediaItemsService interface {
 // If a network error occurs, this method retries and finally returns the error.
 func (p *defaultPhotos) BatchCreate(ctx context.Context, req *photoslibrary.BatchCreateMediaItemsRequest) (*photoslibrary.BatchCreateMediaItemsResponse, error) {
 	batch := p.service.MediaItems.BatchCreate(req)
-	b, cancel := defaultRetryPolicy.Start(ctx)
+	b, cancel := p.retry().Start(ctx)
 	defer cancel()
-	for backoff.Continue(b) {
-		res, err := batch.Do()
+	var cause string // cause of the last failure
+	for attempt := 1; backoff.Continue(b); attempt++ {
+		p.countRetry("BatchCreate", cause)
+		start := time.Now()
+		res, err := batch.Context(ctx).Do()
+		cause = p.observe("BatchCreate", start, err)
 		switch {
 		case err == nil:
 			return res, nil
 		case IsRetryableError(err):
-			p.log.Printf("Error while adding the item: %s", err)
+			p.log.Warn("Error while adding items", "attempt", attempt, "error", err)
 		default:
 			return nil, err
 		}
@@ -0,0 +1,66 @@
+package internal
+
+import (
+	"net/http"
+	"time"
+
+	"google.golang.org/api/googleapi"
+)
+
+// Metrics receives measurements of the API calls and uploads.
+type Metrics interface {
+	// ObserveLatency is called after each call of the operation, e.g. Upload or BatchCreate.
+	ObserveLatency(operation string, d time.Duration)
+	// IncRetries is called when the operation is retried after the retryable cause, i.e. network or server-error.
+	IncRetries(operation, cause string)
+	// IncQuotaExceeded is called when the operation got 429 Too Many Requests.
+	IncQuotaExceeded(operation string)
+	// AddUploadedBytes is called when an item has been uploaded.
+	AddUploadedBytes(n int64)
+	// IncItems is called when an item reaches the state, i.e. uploaded, added or failed.
+	IncItems(state string)
+	// AddInFlight is called when an upload worker starts (1) or finishes (-1) an item.
+	AddInFlight(delta int)
+}
+
+// NopMetrics discards all measurements.
+type NopMetrics struct{}
+
+func (NopMetrics) ObserveLatency(string, time.Duration) {}
+func (NopMetrics) IncRetries(string, string)            {}
+func (NopMetrics) IncQuotaExceeded(string)              {}
+func (NopMetrics) AddUploadedBytes(int64)               {}
+func (NopMetrics) IncItems(string)                      {}
+func (NopMetrics) AddInFlight(int)                      {}
+
+// observe records the call of the operation started at the time.
+// It returns the cause if the error is retryable, which is passed to countRetry on the next attempt.
+func (p *defaultPhotos) observe(operation string, start time.Time, err error) string {
+	p.metrics.ObserveLatency(operation, time.Since(start))
+	if err == nil {
+		return ""
+	}
+	if apiErr, ok := err.(*googleapi.Error); ok && apiErr.Code == http.StatusTooManyRequests {
+		p.metrics.IncQuotaExceeded(operation)
+	}
+	if IsRetryableError(err) {
+		return retryCause(err)
+	}
+	return ""
+}
+
+// countRetry records a retry of the operation if the last attempt failed by the cause.
+// It is called at the start of each attempt, so that the last failed attempt is not counted.
+func (p *defaultPhotos) countRetry(operation, cause string) {
+	if cause != "" {
+		p.metrics.IncRetries(operation, cause)
+	}
+}
+
+// retryCause returns the cause of the retryable error.
+func retryCause(err error) string {
+	if _, ok := err.(*googleapi.Error); ok {
+		return "server-error"
+	}
+	return "network"
+}
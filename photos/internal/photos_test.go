@@ -0,0 +1,299 @@
+package internal
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/int128/gpup/photos/photostest"
+	"github.com/lestrrat-go/backoff"
+	photoslibrary "google.golang.org/api/photoslibrary/v1"
+)
+
+type uploadItemMock string
+
+func (m uploadItemMock) Open() (io.ReadCloser, int64, error) {
+	return ioutil.NopCloser(bytes.NewReader([]byte(m))), int64(len(m)), nil
+}
+
+func (m uploadItemMock) Name() string { return string(m) + ".jpg" }
+
+func (m uploadItemMock) String() string { return string(m) }
+
+func newTestPhotos(t *testing.T) (Photos, *photostest.Server) {
+	t.Helper()
+	s := photostest.NewServer()
+	p, err := NewWithOptions(http.DefaultClient, Endpoint{BasePath: s.BasePath(), UploadURL: s.UploadURL()}, Options{RetryPolicy: fastRetryPolicy})
+	if err != nil {
+		s.Close()
+		t.Fatal(err)
+	}
+	return p, s
+}
+
+func fastRetryPolicy() backoff.Policy {
+	return backoff.NewExponential(
+		backoff.WithInterval(time.Millisecond),
+		backoff.WithMaxRetries(3),
+	)
+}
+
+func TestPhotos_UploadAndBatchCreate(t *testing.T) {
+	p, s := newTestPhotos(t)
+	defer s.Close()
+	ctx := context.Background()
+	album, err := p.CreateAlbum(ctx, &photoslibrary.CreateAlbumRequest{Album: &photoslibrary.Album{Title: "Trip"}})
+	if err != nil {
+		t.Fatalf("CreateAlbum returns error: %s", err)
+	}
+	var items []*photoslibrary.NewMediaItem
+	for _, content := range []string{"a", "b"} {
+		token, err := p.Upload(ctx, uploadItemMock(content))
+		if err != nil {
+			t.Fatalf("Upload returns error: %s", err)
+		}
+		items = append(items, &photoslibrary.NewMediaItem{
+			Description:     content,
+			SimpleMediaItem: &photoslibrary.SimpleMediaItem{UploadToken: string(token)},
+		})
+	}
+	items = append(items, &photoslibrary.NewMediaItem{
+		SimpleMediaItem: &photoslibrary.SimpleMediaItem{UploadToken: "invalid"},
+	})
+	res, err := p.BatchCreate(ctx, &photoslibrary.BatchCreateMediaItemsRequest{
+		AlbumId:       album.Id,
+		AlbumPosition: &photoslibrary.AlbumPosition{Position: "FIRST_IN_ALBUM"},
+		NewMediaItems: items,
+	})
+	if err != nil {
+		t.Fatalf("BatchCreate returns error: %s", err)
+	}
+	if len(res.NewMediaItemResults) != 3 {
+		t.Fatalf("len(NewMediaItemResults) wants 3 but %d", len(res.NewMediaItemResults))
+	}
+	var ids []string
+	for i, r := range res.NewMediaItemResults[:2] {
+		if r.Status.Code != 0 || r.MediaItem == nil {
+			t.Fatalf("NewMediaItemResults[%d] wants OK but %+v", i, r.Status)
+		}
+		ids = append(ids, r.MediaItem.Id)
+	}
+	if code := res.NewMediaItemResults[2].Status.Code; code != 3 {
+		t.Errorf("Status.Code of invalid token wants 3 but %d", code)
+	}
+	if got := s.AlbumEntries(album.Id); !reflect.DeepEqual(ids, got) {
+		t.Errorf("AlbumEntries wants %v but %v", ids, got)
+	}
+	if got := string(s.Content(ids[1])); got != "b" {
+		t.Errorf("Content wants b but %s", got)
+	}
+	got, err := p.GetAlbum(ctx, album.Id)
+	if err != nil {
+		t.Fatalf("GetAlbum returns error: %s", err)
+	}
+	if got.TotalMediaItems != 2 {
+		t.Errorf("TotalMediaItems wants 2 but %d", got.TotalMediaItems)
+	}
+}
+
+func TestPhotos_ListAlbums(t *testing.T) {
+	p, s := newTestPhotos(t)
+	defer s.Close()
+	for i := 0; i < 120; i++ {
+		s.CreateAlbum(fmt.Sprintf("Album %d", i))
+	}
+	ctx := context.Background()
+	var titles []string
+	var pages int
+	var pageToken string
+	for {
+		res, err := p.ListAlbums(ctx, 50, pageToken, "nextPageToken,albums(id,title)")
+		if err != nil {
+			t.Fatalf("ListAlbums returns error: %s", err)
+		}
+		pages++
+		for _, album := range res.Albums {
+			titles = append(titles, album.Title)
+		}
+		if res.NextPageToken == "" {
+			break
+		}
+		pageToken = res.NextPageToken
+	}
+	if pages != 3 || len(titles) != 120 || titles[119] != "Album 119" {
+		t.Errorf("ListAlbums wants 120 albums in 3 pages but %d albums in %d pages", len(titles), pages)
+	}
+	if _, err := p.ListAlbums(ctx, 51, "", "nextPageToken,albums(id,title)"); err == nil {
+		t.Errorf("ListAlbums wants error for pageSize=51 but nil")
+	}
+}
+
+func TestPhotos_JoinSharedAlbum(t *testing.T) {
+	p, s := newTestPhotos(t)
+	defer s.Close()
+	ctx := context.Background()
+	id, token := s.AddSharedAlbum("Team Offsite", true)
+	if _, err := p.GetAlbum(ctx, id); err == nil {
+		t.Errorf("GetAlbum wants error before joining but nil")
+	}
+	if err := p.JoinSharedAlbum(ctx, token); err != nil {
+		t.Fatalf("JoinSharedAlbum returns error: %s", err)
+	}
+	res, err := p.ListSharedAlbums(ctx, 50, "")
+	if err != nil {
+		t.Fatalf("ListSharedAlbums returns error: %s", err)
+	}
+	if len(res.SharedAlbums) != 1 || res.SharedAlbums[0].Id != id || !res.SharedAlbums[0].IsWriteable {
+		t.Errorf("SharedAlbums wants the writeable album %s but %+v", id, res.SharedAlbums)
+	}
+	if err := p.JoinSharedAlbum(ctx, "invalid"); err == nil {
+		t.Errorf("JoinSharedAlbum wants error for invalid token but nil")
+	}
+}
+
+func TestPhotos_Faults(t *testing.T) {
+	for _, c := range []struct {
+		name     string
+		fault    photostest.Fault
+		success  bool
+		requests int
+	}{
+		{"5xx", photostest.Fault{StatusCode: 503, Times: 2}, true, 3},
+		{"5xx/retry over", photostest.Fault{StatusCode: 500}, false, 4},
+		{"429", photostest.Fault{StatusCode: 429, Times: 1}, false, 1},
+		{"truncated", photostest.Fault{Truncate: true, Times: 1}, true, 2},
+	} {
+		t.Run(c.name, func(t *testing.T) {
+			p, s := newTestPhotos(t)
+			defer s.Close()
+			s.CreateAlbum("Trip")
+			ctx := context.Background()
+			c.fault.Path = "/v1/albums"
+			s.InjectFault(c.fault)
+			_, err := p.ListAlbums(ctx, 50, "")
+			if c.success && err != nil {
+				t.Errorf("ListAlbums returns error: %s", err)
+			}
+			if !c.success && err == nil {
+				t.Errorf("ListAlbums wants error but nil")
+			}
+			if n := len(s.Requests()); n != c.requests {
+				t.Errorf("requests wants %d but %d", c.requests, n)
+			}
+
+			c.fault.Path = "/v1/uploads"
+			s.InjectFault(c.fault)
+			_, err = p.Upload(ctx, uploadItemMock("a"))
+			if c.success && err != nil {
+				t.Errorf("Upload returns error: %s", err)
+			}
+			if !c.success && err == nil {
+				t.Errorf("Upload wants error but nil")
+			}
+		})
+	}
+}
+
+func TestPhotos_Timeout(t *testing.T) {
+	p, s := newTestPhotos(t)
+	defer s.Close()
+	s.InjectFault(photostest.Fault{Delay: time.Minute})
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	if _, err := p.GetAlbum(ctx, "album1"); err == nil {
+		t.Errorf("GetAlbum wants error but nil")
+	}
+	if n := len(s.Requests()); n != 1 {
+		t.Errorf("requests wants 1 but %d", n)
+	}
+}
+
+func TestNew_Endpoint(t *testing.T) {
+	s := photostest.NewServer()
+	defer s.Close()
+	// the upload URL is derived from the base path without the trailing slash
+	p, err := New(http.DefaultClient, Endpoint{BasePath: s.URL})
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx := context.Background()
+	if _, err := p.Upload(ctx, uploadItemMock("a")); err != nil {
+		t.Errorf("Upload returns error: %s", err)
+	}
+	if _, err := p.ListAlbums(ctx, 50, ""); err != nil {
+		t.Errorf("ListAlbums returns error: %s", err)
+	}
+	if want, got := []string{"POST /v1/uploads", "GET /v1/albums"}, s.Requests(); !reflect.DeepEqual(want, got) {
+		t.Errorf("Requests wants %v but %v", want, got)
+	}
+
+	for _, e := range []Endpoint{
+		{BasePath: "photoslibrary.example.com"},
+		{UploadURL: "ftp://example.com/uploads"},
+	} {
+		if _, err := New(http.DefaultClient, e); err == nil {
+			t.Errorf("New(%+v) wants error but nil", e)
+		}
+	}
+}
+
+type metricsMock struct {
+	NopMetrics
+	mu            sync.Mutex
+	retries       []string
+	quotaExceeded []string
+}
+
+func (m *metricsMock) IncRetries(operation, cause string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.retries = append(m.retries, operation+" "+cause)
+}
+
+func (m *metricsMock) IncQuotaExceeded(operation string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.quotaExceeded = append(m.quotaExceeded, operation)
+}
+
+func TestPhotos_Metrics(t *testing.T) {
+	s := photostest.NewServer()
+	defer s.Close()
+	var m metricsMock
+	p, err := NewWithOptions(http.DefaultClient, Endpoint{BasePath: s.BasePath(), UploadURL: s.UploadURL()}, Options{Metrics: &m, RetryPolicy: fastRetryPolicy})
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx := context.Background()
+	s.InjectFault(photostest.Fault{Path: "/v1/uploads", StatusCode: 503, Times: 1})
+	if _, err := p.Upload(ctx, uploadItemMock("a")); err != nil {
+		t.Errorf("Upload returns error: %s", err)
+	}
+	s.InjectFault(photostest.Fault{Path: "/v1/albums", StatusCode: 429, Times: 1})
+	if _, err := p.ListAlbums(ctx, 50, ""); err == nil {
+		t.Errorf("ListAlbums wants error but nil")
+	}
+	if want := []string{"Upload server-error"}; !reflect.DeepEqual(want, m.retries) {
+		t.Errorf("retries wants %v but %v", want, m.retries)
+	}
+	if want := []string{"ListAlbums"}; !reflect.DeepEqual(want, m.quotaExceeded) {
+		t.Errorf("quotaExceeded wants %v but %v", want, m.quotaExceeded)
+	}
+
+	// the last failed attempt is not a retry
+	m.retries = nil
+	s.InjectFault(photostest.Fault{Path: "/v1/albums/album1", StatusCode: 500})
+	if _, err := p.GetAlbum(ctx, "album1"); err == nil {
+		t.Errorf("GetAlbum wants error but nil")
+	}
+	if want := []string{"GetAlbum server-error", "GetAlbum server-error", "GetAlbum server-error"}; !reflect.DeepEqual(want, m.retries) {
+		t.Errorf("retries wants %v but %v", want, m.retries)
+	}
+}
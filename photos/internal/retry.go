@@ -1,22 +1,38 @@
 package internal
 
 import (
+	"context"
+	"errors"
 	"time"
 
 	"github.com/lestrrat-go/backoff"
 	"google.golang.org/api/googleapi"
 )
 
-var defaultRetryPolicy = backoff.NewExponential(
-	backoff.WithInterval(3*time.Second),
-	backoff.WithMaxRetries(5),
-)
+// RetryPolicy returns a policy of retrying API calls.
+// It is called for each API call, because a policy may not be shared between goroutines.
+type RetryPolicy func() backoff.Policy
+
+// DefaultRetryPolicy retries 5 times with exponential backoff from 3 seconds.
+func DefaultRetryPolicy() backoff.Policy {
+	return backoff.NewExponential(
+		backoff.WithInterval(3*time.Second),
+		backoff.WithMaxRetries(5),
+	)
+}
 
 // IsRetryableError returns true if the error is retryable,
 // such as status code is 5xx or network error occurs.
-// Otherwise returns false.
+// Otherwise returns false, e.g. the context is done.
 // See https://developers.google.com/photos/library/guides/best-practices#retrying-failed-requests
 func IsRetryableError(err error) bool {
+	var checksumErr *ChecksumError
+	if errors.As(err, &checksumErr) {
+		return false
+	}
+	if errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+		return false
+	}
 	if apiErr, ok := err.(*googleapi.Error); ok {
 		return IsRetryableStatusCode(apiErr.Code)
 	}
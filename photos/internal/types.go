@@ -3,10 +3,12 @@
 package internal
 
 import (
-	"log"
+	"fmt"
 	"net/http"
-	"os"
+	"net/url"
+	"strings"
 
+	"github.com/int128/gpup/logging"
 	photoslibrary "google.golang.org/api/photoslibrary/v1"
 )
 
@@ -18,20 +20,86 @@ type Photos interface {
 }
 
 type defaultPhotos struct {
-	client  *http.Client
-	service *photoslibrary.Service
-	log     *log.Logger
+	client    *http.Client
+	service   *photoslibrary.Service
+	uploadURL string
+	log       logging.Logger
+	metrics   Metrics
+	retry     RetryPolicy
+}
+
+// Endpoint represents URLs of the API.
+// Empty fields mean the production.
+type Endpoint struct {
+	// BasePath is the base URL of the API, e.g. https://photoslibrary.googleapis.com/.
+	BasePath string
+	// UploadURL is the URL to upload media items, e.g. https://photoslibrary.googleapis.com/v1/uploads.
+	// Default to v1/uploads of BasePath.
+	UploadURL string
 }
 
 // New returns a new Photos.
-func New(client *http.Client) (Photos, error) {
+func New(client *http.Client, endpoint Endpoint) (Photos, error) {
+	return NewWithOptions(client, endpoint, Options{})
+}
+
+// Options represents optional dependencies of Photos.
+type Options struct {
+	// Metrics receives measurements. Default to NopMetrics.
+	Metrics Metrics
+	// Logger writes logs. Default to logging.Default.
+	Logger logging.Logger
+	// RetryPolicy returns a policy of retrying API calls. Default to DefaultRetryPolicy.
+	RetryPolicy RetryPolicy
+}
+
+// NewWithOptions returns a new Photos with the options.
+func NewWithOptions(client *http.Client, endpoint Endpoint, o Options) (Photos, error) {
+	if o.Metrics == nil {
+		o.Metrics = NopMetrics{}
+	}
+	if o.Logger == nil {
+		o.Logger = logging.Default
+	}
+	if o.RetryPolicy == nil {
+		o.RetryPolicy = DefaultRetryPolicy
+	}
 	service, err := photoslibrary.New(client)
 	if err != nil {
 		return nil, err
 	}
+	uploadURL := uploadEndpoint
+	if endpoint.BasePath != "" {
+		if err := validateURL(endpoint.BasePath); err != nil {
+			return nil, fmt.Errorf("Invalid base path: %s", err)
+		}
+		// the last path segment is dropped on resolving relative paths without the trailing slash
+		service.BasePath = strings.TrimSuffix(endpoint.BasePath, "/") + "/"
+		uploadURL = service.BasePath + "v1/uploads"
+	}
+	if endpoint.UploadURL != "" {
+		if err := validateURL(endpoint.UploadURL); err != nil {
+			return nil, fmt.Errorf("Invalid upload URL: %s", err)
+		}
+		uploadURL = endpoint.UploadURL
+	}
 	return &defaultPhotos{
-		client:  client,
-		service: service,
-		log:     log.New(os.Stderr, "", log.LstdFlags),
+		client:    client,
+		service:   service,
+		uploadURL: uploadURL,
+		log:       o.Logger,
+		metrics:   o.Metrics,
+		retry:     o.RetryPolicy,
 	}, nil
 }
+
+func validateURL(rawurl string) error {
+	u, err := url.Parse(rawurl)
+	if err != nil {
+		return err
+	}
+	if u.Scheme != "http" && u.Scheme != "https" || u.Host == "" {
+		return fmt.Errorf("%s must be an absolute http or https URL", rawurl)
+	}
+	return nil
+}
@@ -6,8 +6,10 @@ import (
 	"io"
 	"io/ioutil"
 	"net/http"
+	"time"
 
 	"github.com/lestrrat-go/backoff"
+	"google.golang.org/api/googleapi"
 )
 
 type uploadService interface {
@@ -25,6 +27,17 @@ type UploadItem interface {
 	String() string
 }
 
+// ChecksumError represents mismatch of the content checksum.
+// It is not retryable.
+type ChecksumError struct {
+	Want []byte
+	Got  []byte
+}
+
+func (e *ChecksumError) Error() string {
+	return fmt.Sprintf("Checksum mismatch: wants sha256 %x but %x", e.Want, e.Got)
+}
+
 // UploadToken represents a pointer to the uploaded item.
 type UploadToken string
 
@@ -35,48 +48,77 @@ const uploadEndpoint = "https://photoslibrary.googleapis.com/v1/uploads"
 // It will retry uploading if status code is 5xx or network error occurs.
 // See https://developers.google.com/photos/library/guides/best-practices#retrying-failed-requests
 func (p *defaultPhotos) Upload(ctx context.Context, uploadItem UploadItem) (UploadToken, error) {
-	b, cancel := defaultRetryPolicy.Start(ctx)
+	b, cancel := p.retry().Start(ctx)
 	defer cancel()
-	for backoff.Continue(b) {
+	var cause string // cause of the last failure
+	for attempt := 1; backoff.Continue(b); attempt++ {
+		p.countRetry("Upload", cause)
 		r, size, err := uploadItem.Open()
 		if err != nil {
 			return "", fmt.Errorf("Could not open %s: %s", uploadItem, err)
 		}
 		defer r.Close()
 
-		req, err := http.NewRequest("POST", uploadEndpoint, r)
+		body := &countingReader{r: r}
+		req, err := http.NewRequest("POST", p.uploadURL, body)
 		if err != nil {
 			return "", fmt.Errorf("Could not create a request for uploading %s: %s", uploadItem, err)
 		}
 		req = req.WithContext(ctx)
-		req.ContentLength = size
+		if size >= 0 {
+			req.ContentLength = size
+		}
 		req.Header.Add("Content-Type", "application/octet-stream")
 		req.Header.Add("X-Goog-Upload-File-Name", uploadItem.Name())
 		req.Header.Add("X-Goog-Upload-Protocol", "raw")
 
-		p.log.Printf("Uploading %s (%d kB)", uploadItem.Name(), size/1024)
+		p.log.Info("Uploading", "item", uploadItem.String(), "size", size, "attempt", attempt)
+		start := time.Now()
 		res, err := p.client.Do(req)
 		if err != nil {
-			p.log.Printf("Error while uploading %s: %s", uploadItem, err)
+			cause = p.observe("Upload", start, err)
+			if !IsRetryableError(err) {
+				return "", err
+			}
+			p.log.Warn("Error while uploading", "item", uploadItem.String(), "attempt", attempt, "error", err)
 			continue
 		}
 		defer res.Body.Close()
 
 		b, err := ioutil.ReadAll(res.Body)
 		if err != nil {
-			p.log.Printf("Error while uploading %s: %s: could not read body: %s", uploadItem, res.Status, err)
+			cause = p.observe("Upload", start, err)
+			p.log.Warn("Error while uploading", "item", uploadItem.String(), "attempt", attempt, "status", res.Status, "error", fmt.Sprintf("could not read body: %s", err))
 			continue
 		}
-		body := string(b)
+		if res.StatusCode == 200 {
+			cause = p.observe("Upload", start, nil)
+		} else {
+			cause = p.observe("Upload", start, &googleapi.Error{Code: res.StatusCode, Body: string(b)})
+		}
 
 		switch {
 		case res.StatusCode == 200:
-			return UploadToken(body), nil
+			p.log.Debug("Uploaded", "item", uploadItem.String(), "size", body.n, "duration", time.Since(start))
+			p.metrics.AddUploadedBytes(body.n)
+			return UploadToken(b), nil
 		case IsRetryableStatusCode(res.StatusCode):
-			p.log.Printf("Error while uploading %s: %s: %s", uploadItem, res.Status, body)
+			p.log.Warn("Error while uploading", "item", uploadItem.String(), "attempt", attempt, "status", res.Status, "error", string(b))
 		default:
-			return "", fmt.Errorf("Got %s: %s", res.Status, body)
+			return "", fmt.Errorf("Got %s: %s", res.Status, b)
 		}
 	}
 	return "", fmt.Errorf("Retry over")
 }
+
+// countingReader counts bytes read from the reader.
+type countingReader struct {
+	r io.Reader
+	n int64
+}
+
+func (c *countingReader) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	c.n += int64(n)
+	return n, err
+}
@@ -1,13 +1,21 @@
 package photos
 
 import (
+	"bytes"
+	"crypto/sha256"
 	"fmt"
+	"hash"
 	"io"
+	"io/ioutil"
+	"mime"
 	"net/http"
 	"os"
 	"path"
+	"strings"
+	"time"
 
 	"github.com/int128/gpup/photos/internal"
+	"github.com/lestrrat-go/backoff"
 )
 
 // UploadItem represents an uploadable item.
@@ -15,6 +23,14 @@ type UploadItem interface {
 	internal.UploadItem
 }
 
+// DescribedUploadItem represents an uploadable item with the description.
+// If an item implements this, the description is set to the media item.
+// Otherwise the filename is set.
+type DescribedUploadItem interface {
+	UploadItem
+	Description() string
+}
+
 // FileUploadItem represents a local file.
 type FileUploadItem string
 
@@ -32,6 +48,15 @@ func (m FileUploadItem) Open() (io.ReadCloser, int64, error) {
 	return r, f.Size(), nil
 }
 
+// Size returns the size of the file.
+func (m FileUploadItem) Size() (int64, error) {
+	f, err := os.Stat(m.String())
+	if err != nil {
+		return 0, err
+	}
+	return f.Size(), nil
+}
+
 // Name returns the filename.
 func (m FileUploadItem) Name() string {
 	return path.Base(m.String())
@@ -45,27 +70,195 @@ func (m FileUploadItem) String() string {
 type HTTPUploadItem struct {
 	Client  *http.Client
 	Request *http.Request
+
+	name string // determined by the response
+}
+
+// httpFetchRetryPolicy returns a policy for each fetch,
+// because a policy may not be shared between goroutines.
+var httpFetchRetryPolicy = func() backoff.Policy {
+	return backoff.NewExponential(
+		backoff.WithInterval(1*time.Second),
+		backoff.WithMaxRetries(3),
+	)
 }
 
 // Open returns a stream.
+// It will retry fetching if status code is 5xx or network error occurs.
+// If the content length is unknown, the content is spooled to a temporary file.
 // Caller should close it finally.
 func (m *HTTPUploadItem) Open() (io.ReadCloser, int64, error) {
-	r, err := m.Client.Do(m.Request)
+	r, err := m.fetch()
 	if err != nil {
 		return nil, 0, err
 	}
-	if r.StatusCode < 200 || r.StatusCode > 299 {
-		r.Body.Close()
-		return nil, 0, fmt.Errorf("Got %s", r.Status)
+	m.name = nameOfResponse(r)
+	if r.ContentLength < 0 {
+		return spool(r.Body)
 	}
 	return r.Body, r.ContentLength, nil
 }
 
+func (m *HTTPUploadItem) fetch() (*http.Response, error) {
+	b, cancel := httpFetchRetryPolicy().Start(m.Request.Context())
+	defer cancel()
+	var lastErr error
+	for backoff.Continue(b) {
+		r, err := m.Client.Do(m.Request)
+		if err != nil {
+			lastErr = err
+			continue
+		}
+		switch {
+		case r.StatusCode >= 200 && r.StatusCode <= 299:
+			return r, nil
+		case internal.IsRetryableStatusCode(r.StatusCode):
+			r.Body.Close()
+			lastErr = fmt.Errorf("Got %s", r.Status)
+		default:
+			r.Body.Close()
+			return nil, fmt.Errorf("Got %s", r.Status)
+		}
+	}
+	if lastErr == nil {
+		lastErr = m.Request.Context().Err()
+	}
+	return nil, fmt.Errorf("Retry over: %s", lastErr)
+}
+
 // Name returns the filename.
+// It is determined by Content-Disposition header or the final URL after redirects.
+// If it is called before Open, it returns the last path component of the URL.
 func (m *HTTPUploadItem) Name() string {
+	if m.name != "" {
+		return m.name
+	}
 	return path.Base(m.Request.URL.Path)
 }
 
 func (m *HTTPUploadItem) String() string {
 	return m.Request.URL.String()
 }
+
+// nameOfResponse returns the filename of the response.
+func nameOfResponse(r *http.Response) string {
+	if _, params, err := mime.ParseMediaType(r.Header.Get("Content-Disposition")); err == nil {
+		if filename := path.Base(strings.Replace(params["filename"], "\\", "/", -1)); filename != "." && filename != "/" {
+			return filename
+		}
+	}
+	name := path.Base(r.Request.URL.Path)
+	if name == "." || name == "/" {
+		name = "download"
+	}
+	if path.Ext(name) == "" {
+		if ext := extensionOf(r.Header.Get("Content-Type")); ext != "" {
+			name += ext
+		}
+	}
+	return name
+}
+
+var mediaTypeExtensions = map[string]string{
+	"image/jpeg":      ".jpg",
+	"image/png":       ".png",
+	"image/gif":       ".gif",
+	"image/webp":      ".webp",
+	"image/heic":      ".heic",
+	"image/tiff":      ".tif",
+	"image/bmp":       ".bmp",
+	"video/mp4":       ".mp4",
+	"video/quicktime": ".mov",
+	"video/mpeg":      ".mpg",
+	"video/x-msvideo": ".avi",
+	"video/webm":      ".webm",
+	"video/3gpp":      ".3gp",
+}
+
+func extensionOf(contentType string) string {
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return ""
+	}
+	if ext, ok := mediaTypeExtensions[mediaType]; ok {
+		return ext
+	}
+	if exts, err := mime.ExtensionsByType(mediaType); err == nil && len(exts) > 0 {
+		return exts[0]
+	}
+	return ""
+}
+
+// spool copies the stream to a temporary file and returns it.
+// The file is removed on close.
+func spool(r io.ReadCloser) (io.ReadCloser, int64, error) {
+	defer r.Close()
+	f, err := ioutil.TempFile("", "gpup")
+	if err != nil {
+		return nil, 0, fmt.Errorf("Could not create a temporary file: %s", err)
+	}
+	size, err := io.Copy(f, r)
+	if err != nil {
+		f.Close()
+		os.Remove(f.Name())
+		return nil, 0, fmt.Errorf("Could not read the content: %s", err)
+	}
+	if _, err := f.Seek(0, io.SeekStart); err != nil {
+		f.Close()
+		os.Remove(f.Name())
+		return nil, 0, fmt.Errorf("Could not seek the temporary file: %s", err)
+	}
+	return &spoolFile{f}, size, nil
+}
+
+type spoolFile struct {
+	*os.File
+}
+
+func (f *spoolFile) Close() error {
+	err := f.File.Close()
+	os.Remove(f.Name())
+	return err
+}
+
+// ChecksumUploadItem represents an item which content is verified by SHA-256.
+// If the checksum does not match, the upload fails without retrying.
+type ChecksumUploadItem struct {
+	UploadItem
+	SHA256 []byte
+}
+
+// Size returns the size of the underlying item, or -1 if unknown.
+func (m *ChecksumUploadItem) Size() (int64, error) {
+	if s, ok := m.UploadItem.(SizedUploadItem); ok {
+		return s.Size()
+	}
+	return -1, nil
+}
+
+// Open returns a stream which verifies the checksum on reaching EOF.
+// Caller should close it finally.
+func (m *ChecksumUploadItem) Open() (io.ReadCloser, int64, error) {
+	r, size, err := m.UploadItem.Open()
+	if err != nil {
+		return nil, 0, err
+	}
+	return &checksumReader{ReadCloser: r, hash: sha256.New(), want: m.SHA256}, size, nil
+}
+
+type checksumReader struct {
+	io.ReadCloser
+	hash hash.Hash
+	want []byte
+}
+
+func (r *checksumReader) Read(p []byte) (int, error) {
+	n, err := r.ReadCloser.Read(p)
+	r.hash.Write(p[:n])
+	if err == io.EOF {
+		if got := r.hash.Sum(nil); !bytes.Equal(got, r.want) {
+			return n, &internal.ChecksumError{Want: r.want, Got: got}
+		}
+	}
+	return n, err
+}
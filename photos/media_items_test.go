@@ -1,12 +1,17 @@
 package photos
 
 import (
+	"crypto/sha256"
 	"fmt"
 	"io/ioutil"
 	"net/http"
 	"net/http/httptest"
 	"os"
 	"testing"
+	"time"
+
+	"github.com/int128/gpup/photos/internal"
+	"github.com/lestrrat-go/backoff"
 )
 
 func TestFileUploadItem(t *testing.T) {
@@ -88,3 +93,158 @@ func TestHTTPUploadItem(t *testing.T) {
 		t.Errorf("Content wants example but %s", string(b))
 	}
 }
+
+func TestChecksumUploadItem(t *testing.T) {
+	tempdir, err := ioutil.TempDir("", t.Name())
+	if err != nil {
+		t.Fatalf("Could not create a temporary directory: %s", err)
+	}
+	defer os.RemoveAll(tempdir)
+	if err := ioutil.WriteFile(tempdir+"/foo.jpg", []byte("example"), 0644); err != nil {
+		t.Fatalf("Could not write bytes to file: %s", err)
+	}
+	sum := sha256.Sum256([]byte("example"))
+
+	item := &ChecksumUploadItem{UploadItem: FileUploadItem(tempdir + "/foo.jpg"), SHA256: sum[:]}
+	r, _, err := item.Open()
+	if err != nil {
+		t.Fatalf("Open() returns error: %s", err)
+	}
+	defer r.Close()
+	if _, err := ioutil.ReadAll(r); err != nil {
+		t.Errorf("ReadAll wants nil but %s", err)
+	}
+
+	item = &ChecksumUploadItem{UploadItem: FileUploadItem(tempdir + "/foo.jpg"), SHA256: make([]byte, 32)}
+	r, _, err = item.Open()
+	if err != nil {
+		t.Fatalf("Open() returns error: %s", err)
+	}
+	defer r.Close()
+	_, err = ioutil.ReadAll(r)
+	if _, ok := err.(*internal.ChecksumError); !ok {
+		t.Errorf("ReadAll wants ChecksumError but %+v", err)
+	}
+	if internal.IsRetryableError(err) {
+		t.Errorf("IsRetryableError wants false but true")
+	}
+}
+
+func TestHTTPUploadItem_Name(t *testing.T) {
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/download":
+			w.Header().Set("Content-Disposition", `attachment; filename="bar.jpg"`)
+			fmt.Fprint(w, "example")
+		case "/redirect":
+			http.Redirect(w, r, "/photos/baz.png", 302)
+		case "/photos/baz.png":
+			fmt.Fprint(w, "example")
+		case "/media":
+			w.Header().Set("Content-Type", "video/mp4")
+			fmt.Fprint(w, "example")
+		default:
+			http.Error(w, "Not Found", 404)
+		}
+	}))
+	defer s.Close()
+	for _, c := range []struct {
+		path string
+		name string
+	}{
+		{"/download?id=123", "bar.jpg"},
+		{"/redirect", "baz.png"},
+		{"/media", "media.mp4"},
+	} {
+		t.Run(c.path, func(t *testing.T) {
+			req, err := http.NewRequest("GET", s.URL+c.path, nil)
+			if err != nil {
+				t.Fatalf("Could not create a request: %s", err)
+			}
+			item := &HTTPUploadItem{Client: http.DefaultClient, Request: req}
+			r, _, err := item.Open()
+			if err != nil {
+				t.Fatalf("Open() returns error: %s", err)
+			}
+			r.Close()
+			if c.name != item.Name() {
+				t.Errorf("Name() wants %s but %s", c.name, item.Name())
+			}
+		})
+	}
+}
+
+func TestHTTPUploadItem_UnknownLength(t *testing.T) {
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, "exam")
+		w.(http.Flusher).Flush()
+		fmt.Fprint(w, "ple")
+	}))
+	defer s.Close()
+	req, err := http.NewRequest("GET", s.URL+"/foo.jpg", nil)
+	if err != nil {
+		t.Fatalf("Could not create a request: %s", err)
+	}
+	item := &HTTPUploadItem{Client: http.DefaultClient, Request: req}
+	r, l, err := item.Open()
+	if err != nil {
+		t.Fatalf("Open() returns error: %s", err)
+	}
+	defer r.Close()
+	if 7 != l {
+		t.Errorf("Content length wants 7 but %d", l)
+	}
+	b, err := ioutil.ReadAll(r)
+	if err != nil {
+		t.Fatalf("Could not read from Open(): %s", err)
+	}
+	if "example" != string(b) {
+		t.Errorf("Content wants example but %s", string(b))
+	}
+}
+
+func TestHTTPUploadItem_Retry(t *testing.T) {
+	defer func(restore func() backoff.Policy) { httpFetchRetryPolicy = restore }(httpFetchRetryPolicy)
+	httpFetchRetryPolicy = func() backoff.Policy { return backoff.NewConstant(time.Millisecond, backoff.WithMaxRetries(3)) }
+
+	var calls int
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		switch {
+		case r.URL.Path == "/flaky.jpg" && calls < 3:
+			http.Error(w, "Service Unavailable", 503)
+		case r.URL.Path == "/flaky.jpg":
+			fmt.Fprint(w, "example")
+		default:
+			http.Error(w, "Not Found", 404)
+		}
+	}))
+	defer s.Close()
+
+	req, err := http.NewRequest("GET", s.URL+"/flaky.jpg", nil)
+	if err != nil {
+		t.Fatalf("Could not create a request: %s", err)
+	}
+	item := &HTTPUploadItem{Client: http.DefaultClient, Request: req}
+	r, _, err := item.Open()
+	if err != nil {
+		t.Fatalf("Open() returns error: %s", err)
+	}
+	r.Close()
+	if calls != 3 {
+		t.Errorf("calls wants 3 but %d", calls)
+	}
+
+	calls = 0
+	req, err = http.NewRequest("GET", s.URL+"/missing.jpg", nil)
+	if err != nil {
+		t.Fatalf("Could not create a request: %s", err)
+	}
+	item = &HTTPUploadItem{Client: http.DefaultClient, Request: req}
+	if _, _, err := item.Open(); err == nil {
+		t.Errorf("Open() wants error but nil")
+	}
+	if calls != 1 {
+		t.Errorf("calls wants 1 but %d", calls)
+	}
+}
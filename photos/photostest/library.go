@@ -0,0 +1,557 @@
+package photostest
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"mime"
+	"net/http"
+	"path"
+	"strings"
+	"time"
+
+	photoslibrary "google.golang.org/api/photoslibrary/v1"
+)
+
+type upload struct {
+	filename string
+	data     []byte
+	used     bool
+}
+
+type mediaItem struct {
+	id          string
+	filename    string
+	description string
+	mimeType    string
+	createdAt   time.Time
+	data        []byte
+}
+
+type album struct {
+	id    string
+	title string
+	// owned is true if the album is owned by the user.
+	owned bool
+	// joined is true if the user has joined the album shared by another user.
+	joined bool
+	// collaborative is true if other users can add items to the shared album.
+	collaborative bool
+	shareInfo     *photoslibrary.ShareInfo
+	// entries are IDs of media items and enrichment items in the order.
+	entries []string
+}
+
+func (a *album) visible() bool {
+	return a.owned || a.joined
+}
+
+func (a *album) writeable() bool {
+	return a.owned || (a.joined && a.collaborative)
+}
+
+func (s *Server) toAlbum(a *album) *photoslibrary.Album {
+	var n int64
+	for _, id := range a.entries {
+		if _, ok := s.mediaItems[id]; ok {
+			n++
+		}
+	}
+	var shareInfo *photoslibrary.ShareInfo
+	if a.shareInfo != nil {
+		copied := *a.shareInfo
+		shareInfo = &copied
+	}
+	return &photoslibrary.Album{
+		Id:              a.id,
+		Title:           a.title,
+		ProductUrl:      s.URL + "/album/" + a.id,
+		IsWriteable:     a.writeable(),
+		ShareInfo:       shareInfo,
+		TotalMediaItems: n,
+	}
+}
+
+func (s *Server) toMediaItem(m *mediaItem) *photoslibrary.MediaItem {
+	return &photoslibrary.MediaItem{
+		Id:          m.id,
+		Description: m.description,
+		MimeType:    m.mimeType,
+		ProductUrl:  s.URL + "/item/" + m.id,
+		BaseUrl:     s.URL + "/media/" + m.id,
+		MediaMetadata: &photoslibrary.MediaMetadata{
+			CreationTime: m.createdAt.UTC().Format(time.RFC3339),
+		},
+	}
+}
+
+// CreateAlbum creates an album owned by the user and returns the ID.
+func (s *Server) CreateAlbum(title string) string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.createAlbum(&album{title: title, owned: true})
+}
+
+// AddSharedAlbum creates an album shared by another user and returns the ID and share token.
+// The user can find the album after joining it by the share token.
+// If collaborative is true, the user can add items to the album after joining.
+func (s *Server) AddSharedAlbum(title string, collaborative bool) (string, string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	a := &album{title: title, collaborative: collaborative}
+	id := s.createAlbum(a)
+	a.shareInfo = s.newShareInfo(collaborative)
+	return id, a.shareInfo.ShareToken
+}
+
+func (s *Server) createAlbum(a *album) string {
+	a.id = s.newID("album")
+	s.albums[a.id] = a
+	s.albumOrder = append(s.albumOrder, a.id)
+	return a.id
+}
+
+func (s *Server) newShareInfo(collaborative bool) *photoslibrary.ShareInfo {
+	token := s.newID("share")
+	return &photoslibrary.ShareInfo{
+		ShareToken:         token,
+		ShareableUrl:       s.URL + "/share/" + token,
+		SharedAlbumOptions: &photoslibrary.SharedAlbumOptions{IsCollaborative: collaborative},
+	}
+}
+
+// Album returns the album of the ID, or nil if not found.
+func (s *Server) Album(id string) *photoslibrary.Album {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	a, ok := s.albums[id]
+	if !ok {
+		return nil
+	}
+	return s.toAlbum(a)
+}
+
+// Albums returns all albums including albums which the user has not joined.
+func (s *Server) Albums() []*photoslibrary.Album {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	albums := make([]*photoslibrary.Album, 0)
+	for _, id := range s.albumOrder {
+		albums = append(albums, s.toAlbum(s.albums[id]))
+	}
+	return albums
+}
+
+// AlbumEntries returns IDs of the media items and enrichment items in the album in the order.
+func (s *Server) AlbumEntries(id string) []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	a, ok := s.albums[id]
+	if !ok {
+		return nil
+	}
+	return append([]string{}, a.entries...)
+}
+
+// MediaItems returns all media items in the library in the order of creation.
+func (s *Server) MediaItems() []*photoslibrary.MediaItem {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	items := make([]*photoslibrary.MediaItem, 0)
+	for _, id := range s.itemOrder {
+		items = append(items, s.toMediaItem(s.mediaItems[id]))
+	}
+	return items
+}
+
+// Content returns the uploaded content of the media item.
+func (s *Server) Content(id string) []byte {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	m, ok := s.mediaItems[id]
+	if !ok {
+		return nil
+	}
+	return m.data
+}
+
+func writeJSON(w http.ResponseWriter, code int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json; charset=UTF-8")
+	w.WriteHeader(code)
+	json.NewEncoder(w).Encode(v)
+}
+
+func readJSON(w http.ResponseWriter, r *http.Request, v interface{}) bool {
+	if err := json.NewDecoder(r.Body).Decode(v); err != nil {
+		writeError(w, http.StatusBadRequest, fmt.Sprintf("Invalid JSON payload: %s", err))
+		return false
+	}
+	return true
+}
+
+func (s *Server) handleUpload(w http.ResponseWriter, r *http.Request) {
+	if r.Header.Get("X-Goog-Upload-Protocol") != "raw" {
+		writeError(w, http.StatusBadRequest, "X-Goog-Upload-Protocol must be raw")
+		return
+	}
+	filename := r.Header.Get("X-Goog-Upload-File-Name")
+	if filename == "" {
+		writeError(w, http.StatusBadRequest, "X-Goog-Upload-File-Name is required")
+		return
+	}
+	data, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, fmt.Sprintf("Could not read the body: %s", err))
+		return
+	}
+	if len(data) == 0 {
+		writeError(w, http.StatusBadRequest, "Empty content")
+		return
+	}
+	s.mu.Lock()
+	token := s.newID("upload")
+	s.uploads[token] = &upload{filename: filename, data: data}
+	s.mu.Unlock()
+	w.Header().Set("Content-Type", "text/plain")
+	w.WriteHeader(http.StatusOK)
+	w.Write([]byte(token))
+}
+
+// insertionIndex returns the index in the album to insert items at the position.
+func (s *Server) insertionIndex(a *album, p *photoslibrary.AlbumPosition) (int, error) {
+	if p == nil {
+		return len(a.entries), nil
+	}
+	switch p.Position {
+	case "", "LAST_IN_ALBUM":
+		return len(a.entries), nil
+	case "FIRST_IN_ALBUM":
+		return 0, nil
+	case "AFTER_MEDIA_ITEM":
+		for i, id := range a.entries {
+			if _, ok := s.mediaItems[id]; ok && id == p.RelativeMediaItemId {
+				return i + 1, nil
+			}
+		}
+		return 0, fmt.Errorf("Media item %s is not in the album", p.RelativeMediaItemId)
+	case "AFTER_ENRICHMENT_ITEM":
+		for i, id := range a.entries {
+			if _, ok := s.enrichments[id]; ok && id == p.RelativeEnrichmentItemId {
+				return i + 1, nil
+			}
+		}
+		return 0, fmt.Errorf("Enrichment item %s is not in the album", p.RelativeEnrichmentItemId)
+	}
+	return 0, fmt.Errorf("Invalid position %s", p.Position)
+}
+
+// writeableAlbum returns the album of the ID if the user can add items to it.
+func (s *Server) writeableAlbum(id string) (*album, int, error) {
+	a, ok := s.albums[id]
+	if !ok || !a.visible() {
+		return nil, http.StatusNotFound, fmt.Errorf("Album %s was not found", id)
+	}
+	if !a.writeable() {
+		return nil, http.StatusForbidden, fmt.Errorf("Album %s is not writeable", id)
+	}
+	return a, 0, nil
+}
+
+func insert(entries []string, index int, ids []string) []string {
+	var ret []string
+	ret = append(ret, entries[:index]...)
+	ret = append(ret, ids...)
+	return append(ret, entries[index:]...)
+}
+
+func (s *Server) handleBatchCreate(w http.ResponseWriter, r *http.Request) {
+	var req photoslibrary.BatchCreateMediaItemsRequest
+	if !readJSON(w, r, &req) {
+		return
+	}
+	if len(req.NewMediaItems) == 0 || len(req.NewMediaItems) > 50 {
+		writeError(w, http.StatusBadRequest, fmt.Sprintf("newMediaItems must contain 1 to 50 items but %d", len(req.NewMediaItems)))
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var a *album
+	var index int
+	if req.AlbumId != "" {
+		var code int
+		var err error
+		if a, code, err = s.writeableAlbum(req.AlbumId); err != nil {
+			writeError(w, code, err.Error())
+			return
+		}
+		if index, err = s.insertionIndex(a, req.AlbumPosition); err != nil {
+			writeError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+	} else if req.AlbumPosition != nil {
+		writeError(w, http.StatusBadRequest, "albumPosition requires albumId")
+		return
+	}
+
+	var res photoslibrary.BatchCreateMediaItemsResponse
+	var ids []string
+	for _, item := range req.NewMediaItems {
+		if item.SimpleMediaItem == nil {
+			res.NewMediaItemResults = append(res.NewMediaItemResults, &photoslibrary.NewMediaItemResult{
+				Status: &photoslibrary.Status{Code: 3, Message: "simpleMediaItem is required"},
+			})
+			continue
+		}
+		token := item.SimpleMediaItem.UploadToken
+		u, ok := s.uploads[token]
+		if !ok || u.used {
+			res.NewMediaItemResults = append(res.NewMediaItemResults, &photoslibrary.NewMediaItemResult{
+				UploadToken: token,
+				Status:      &photoslibrary.Status{Code: 3, Message: "Invalid upload token"},
+			})
+			continue
+		}
+		u.used = true
+		mimeType := mime.TypeByExtension(path.Ext(u.filename))
+		if mimeType == "" {
+			mimeType = "application/octet-stream"
+		}
+		m := &mediaItem{
+			id:          s.newID("item"),
+			filename:    u.filename,
+			description: item.Description,
+			mimeType:    strings.SplitN(mimeType, ";", 2)[0],
+			createdAt:   time.Now(),
+			data:        u.data,
+		}
+		s.mediaItems[m.id] = m
+		s.itemOrder = append(s.itemOrder, m.id)
+		ids = append(ids, m.id)
+		res.NewMediaItemResults = append(res.NewMediaItemResults, &photoslibrary.NewMediaItemResult{
+			UploadToken: token,
+			Status:      &photoslibrary.Status{Message: "OK"},
+			MediaItem:   s.toMediaItem(m),
+		})
+	}
+	if a != nil {
+		a.entries = insert(a.entries, index, ids)
+	}
+	writeJSON(w, http.StatusOK, &res)
+}
+
+func (s *Server) handleGetMediaItem(w http.ResponseWriter, r *http.Request, id string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	m, ok := s.mediaItems[id]
+	if !ok {
+		writeError(w, http.StatusNotFound, fmt.Sprintf("Media item %s was not found", id))
+		return
+	}
+	writeJSON(w, http.StatusOK, s.toMediaItem(m))
+}
+
+// matchesFilters returns true if the media item matches the media type filter.
+// Other filters are ignored.
+func matchesFilters(m *mediaItem, f *photoslibrary.Filters) bool {
+	if f == nil || f.MediaTypeFilter == nil || len(f.MediaTypeFilter.MediaTypes) == 0 {
+		return true
+	}
+	for _, t := range f.MediaTypeFilter.MediaTypes {
+		switch {
+		case t == "ALL_MEDIA":
+			return true
+		case t == "PHOTO" && strings.HasPrefix(m.mimeType, "image/"):
+			return true
+		case t == "VIDEO" && strings.HasPrefix(m.mimeType, "video/"):
+			return true
+		}
+	}
+	return false
+}
+
+func (s *Server) handleSearch(w http.ResponseWriter, r *http.Request) {
+	var req photoslibrary.SearchMediaItemsRequest
+	if !readJSON(w, r, &req) {
+		return
+	}
+	if req.AlbumId != "" && req.Filters != nil {
+		writeError(w, http.StatusBadRequest, "albumId and filters cannot be set together")
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	ids := s.itemOrder
+	if req.AlbumId != "" {
+		a, ok := s.albums[req.AlbumId]
+		if !ok || !a.visible() {
+			writeError(w, http.StatusNotFound, fmt.Sprintf("Album %s was not found", req.AlbumId))
+			return
+		}
+		ids = a.entries
+	}
+	var items []*mediaItem
+	for _, id := range ids {
+		if m, ok := s.mediaItems[id]; ok && matchesFilters(m, req.Filters) {
+			items = append(items, m)
+		}
+	}
+	start, end, next, err := pageOf(len(items), req.PageSize, 100, req.PageToken)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	var res photoslibrary.SearchMediaItemsResponse
+	for _, m := range items[start:end] {
+		res.MediaItems = append(res.MediaItems, s.toMediaItem(m))
+	}
+	res.NextPageToken = next
+	writeJSON(w, http.StatusOK, &res)
+}
+
+func (s *Server) handleCreateAlbum(w http.ResponseWriter, r *http.Request) {
+	var req photoslibrary.CreateAlbumRequest
+	if !readJSON(w, r, &req) {
+		return
+	}
+	if req.Album == nil || req.Album.Title == "" {
+		writeError(w, http.StatusBadRequest, "album.title is required")
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	id := s.createAlbum(&album{title: req.Album.Title, owned: true})
+	writeJSON(w, http.StatusOK, s.toAlbum(s.albums[id]))
+}
+
+func (s *Server) handleGetAlbum(w http.ResponseWriter, r *http.Request, id string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	a, ok := s.albums[id]
+	if !ok || !a.visible() {
+		writeError(w, http.StatusNotFound, fmt.Sprintf("Album %s was not found", id))
+		return
+	}
+	writeJSON(w, http.StatusOK, s.toAlbum(a))
+}
+
+// listAlbums returns a page of the albums which satisfy the condition.
+func (s *Server) listAlbums(r *http.Request, cond func(*album) bool) ([]*photoslibrary.Album, string, error) {
+	var pageSize int64
+	if v := r.URL.Query().Get("pageSize"); v != "" {
+		if _, err := fmt.Sscanf(v, "%d", &pageSize); err != nil {
+			return nil, "", fmt.Errorf("Invalid pageSize %s", v)
+		}
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var albums []*album
+	for _, id := range s.albumOrder {
+		if a := s.albums[id]; cond(a) {
+			albums = append(albums, a)
+		}
+	}
+	start, end, next, err := pageOf(len(albums), pageSize, 50, r.URL.Query().Get("pageToken"))
+	if err != nil {
+		return nil, "", err
+	}
+	var page []*photoslibrary.Album
+	for _, a := range albums[start:end] {
+		page = append(page, s.toAlbum(a))
+	}
+	return page, next, nil
+}
+
+func (s *Server) handleListAlbums(w http.ResponseWriter, r *http.Request) {
+	albums, next, err := s.listAlbums(r, (*album).visible)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	writeJSON(w, http.StatusOK, &photoslibrary.ListAlbumsResponse{Albums: albums, NextPageToken: next})
+}
+
+func (s *Server) handleListSharedAlbums(w http.ResponseWriter, r *http.Request) {
+	albums, next, err := s.listAlbums(r, func(a *album) bool {
+		return a.visible() && a.shareInfo != nil
+	})
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	writeJSON(w, http.StatusOK, &photoslibrary.ListSharedAlbumsResponse{SharedAlbums: albums, NextPageToken: next})
+}
+
+func (s *Server) handleShareAlbum(w http.ResponseWriter, r *http.Request, id string) {
+	var req photoslibrary.ShareAlbumRequest
+	if !readJSON(w, r, &req) {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	a, ok := s.albums[id]
+	if !ok || !a.owned {
+		writeError(w, http.StatusNotFound, fmt.Sprintf("Album %s was not found", id))
+		return
+	}
+	if a.shareInfo == nil {
+		var collaborative bool
+		if req.SharedAlbumOptions != nil {
+			collaborative = req.SharedAlbumOptions.IsCollaborative
+		}
+		a.collaborative = collaborative
+		a.shareInfo = s.newShareInfo(collaborative)
+	}
+	writeJSON(w, http.StatusOK, &photoslibrary.ShareAlbumResponse{ShareInfo: a.shareInfo})
+}
+
+func (s *Server) handleJoinSharedAlbum(w http.ResponseWriter, r *http.Request) {
+	var req photoslibrary.JoinSharedAlbumRequest
+	if !readJSON(w, r, &req) {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, a := range s.albums {
+		if a.shareInfo != nil && a.shareInfo.ShareToken == req.ShareToken {
+			if !a.owned {
+				a.joined = true
+			}
+			writeJSON(w, http.StatusOK, &photoslibrary.JoinSharedAlbumResponse{})
+			return
+		}
+	}
+	writeError(w, http.StatusNotFound, "Invalid share token")
+}
+
+func (s *Server) handleAddEnrichment(w http.ResponseWriter, r *http.Request, id string) {
+	var req photoslibrary.AddEnrichmentToAlbumRequest
+	if !readJSON(w, r, &req) {
+		return
+	}
+	if req.NewEnrichmentItem == nil {
+		writeError(w, http.StatusBadRequest, "newEnrichmentItem is required")
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	a, code, err := s.writeableAlbum(id)
+	if err != nil {
+		writeError(w, code, err.Error())
+		return
+	}
+	if req.AlbumPosition == nil {
+		writeError(w, http.StatusBadRequest, "albumPosition is required")
+		return
+	}
+	index, err := s.insertionIndex(a, req.AlbumPosition)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	eid := s.newID("enrichment")
+	s.enrichments[eid] = a.id
+	a.entries = insert(a.entries, index, []string{eid})
+	writeJSON(w, http.StatusOK, &photoslibrary.AddEnrichmentToAlbumResponse{
+		EnrichmentItem: &photoslibrary.EnrichmentItem{Id: eid},
+	})
+}
@@ -0,0 +1,277 @@
+// Package photostest provides an in-memory fake server of Google Photos Library API for tests.
+//
+// The server supports uploads, media items, albums, sharing and search.
+// You can inject faults such as 5xx, 429, delays and truncated bodies.
+package photostest
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Server is a fake server of Google Photos Library API.
+type Server struct {
+	// URL is the base URL of the server, e.g. http://127.0.0.1:12345.
+	URL string
+
+	server *httptest.Server
+
+	mu          sync.Mutex
+	faults      []*Fault
+	requests    []string
+	lastID      int
+	uploads     map[string]*upload
+	mediaItems  map[string]*mediaItem
+	itemOrder   []string
+	albums      map[string]*album
+	albumOrder  []string
+	enrichments map[string]string // enrichment ID to album ID
+}
+
+// NewServer starts a server.
+// Caller should close it finally.
+func NewServer() *Server {
+	s := &Server{
+		uploads:     make(map[string]*upload),
+		mediaItems:  make(map[string]*mediaItem),
+		albums:      make(map[string]*album),
+		enrichments: make(map[string]string),
+	}
+	s.server = httptest.NewServer(http.HandlerFunc(s.serveHTTP))
+	s.URL = s.server.URL
+	return s
+}
+
+// Close shuts down the server.
+func (s *Server) Close() {
+	s.server.Close()
+}
+
+// BasePath returns the base path of the API for the server.
+func (s *Server) BasePath() string {
+	return s.URL + "/"
+}
+
+// UploadURL returns the URL to upload media items to the server.
+func (s *Server) UploadURL() string {
+	return s.URL + "/v1/uploads"
+}
+
+// Client returns a client which sends all requests to the server,
+// e.g. requests to https://photoslibrary.googleapis.com go to the server.
+func (s *Server) Client() *http.Client {
+	u, _ := url.Parse(s.URL)
+	return &http.Client{Transport: &rewriteTransport{target: u, transport: s.server.Client().Transport}}
+}
+
+type rewriteTransport struct {
+	target    *url.URL
+	transport http.RoundTripper
+}
+
+func (t *rewriteTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	r := req.Clone(req.Context())
+	r.URL.Scheme = t.target.Scheme
+	r.URL.Host = t.target.Host
+	r.Host = t.target.Host
+	return t.transport.RoundTrip(r)
+}
+
+// Fault represents an error injected into responses.
+type Fault struct {
+	// Method of requests to affect. Empty matches any method.
+	Method string
+	// Path is the prefix of the path of requests to affect, e.g. /v1/uploads.
+	// Empty matches any path.
+	Path string
+	// StatusCode is the status code to respond instead of the API, e.g. 500 or 429.
+	StatusCode int
+	// Delay is the time to wait before responding, e.g. to cause a timeout.
+	Delay time.Duration
+	// Truncate closes the connection in the middle of the body.
+	Truncate bool
+	// Times is the number of requests to affect. 0 means all requests.
+	Times int
+}
+
+func (f *Fault) matches(r *http.Request) bool {
+	return (f.Method == "" || f.Method == r.Method) && strings.HasPrefix(r.URL.Path, f.Path)
+}
+
+// InjectFault adds the fault.
+// If multiple faults match a request, the first one is applied.
+func (s *Server) InjectFault(f Fault) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.faults = append(s.faults, &f)
+}
+
+// ClearFaults removes all faults.
+func (s *Server) ClearFaults() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.faults = nil
+}
+
+// Requests returns the received requests in form of "METHOD /path".
+func (s *Server) Requests() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]string{}, s.requests...)
+}
+
+// takeFault returns the fault for the request and records the request.
+func (s *Server) takeFault(r *http.Request) *Fault {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.requests = append(s.requests, r.Method+" "+r.URL.Path)
+	for i, f := range s.faults {
+		if !f.matches(r) {
+			continue
+		}
+		fault := *f
+		if f.Times > 0 {
+			f.Times--
+			if f.Times == 0 {
+				s.faults = append(s.faults[:i], s.faults[i+1:]...)
+			}
+		}
+		return &fault
+	}
+	return nil
+}
+
+func (s *Server) serveHTTP(w http.ResponseWriter, r *http.Request) {
+	fault := s.takeFault(r)
+	if fault != nil && fault.Delay > 0 {
+		select {
+		case <-time.After(fault.Delay):
+		case <-r.Context().Done():
+			return
+		}
+	}
+	rec := httptest.NewRecorder()
+	if fault != nil && fault.StatusCode != 0 {
+		if fault.StatusCode == http.StatusTooManyRequests {
+			rec.Header().Set("Retry-After", "1")
+		}
+		writeError(rec, fault.StatusCode, "Injected fault")
+	} else {
+		s.route(rec, r)
+	}
+	for k, v := range rec.Header() {
+		w.Header()[k] = v
+	}
+	body := rec.Body.Bytes()
+	if fault != nil && fault.Truncate {
+		truncate(w, rec.Code, body)
+		return
+	}
+	w.Header().Set("Content-Length", strconv.Itoa(len(body)))
+	w.WriteHeader(rec.Code)
+	w.Write(body)
+}
+
+// truncate sends the first half of the body and closes the connection.
+func truncate(w http.ResponseWriter, code int, body []byte) {
+	w.Header().Set("Content-Length", strconv.Itoa(len(body)+1))
+	w.WriteHeader(code)
+	w.Write(body[:len(body)/2])
+	if f, ok := w.(http.Flusher); ok {
+		f.Flush()
+	}
+	if h, ok := w.(http.Hijacker); ok {
+		if conn, _, err := h.Hijack(); err == nil {
+			conn.Close()
+		}
+	}
+}
+
+func (s *Server) route(w http.ResponseWriter, r *http.Request) {
+	p := r.URL.Path
+	switch {
+	case p == "/v1/uploads" && r.Method == "POST":
+		s.handleUpload(w, r)
+	case p == "/v1/mediaItems:batchCreate" && r.Method == "POST":
+		s.handleBatchCreate(w, r)
+	case p == "/v1/mediaItems:search" && r.Method == "POST":
+		s.handleSearch(w, r)
+	case strings.HasPrefix(p, "/v1/mediaItems/") && r.Method == "GET":
+		s.handleGetMediaItem(w, r, strings.TrimPrefix(p, "/v1/mediaItems/"))
+	case p == "/v1/albums" && r.Method == "GET":
+		s.handleListAlbums(w, r)
+	case p == "/v1/albums" && r.Method == "POST":
+		s.handleCreateAlbum(w, r)
+	case strings.HasPrefix(p, "/v1/albums/") && strings.HasSuffix(p, ":addEnrichment") && r.Method == "POST":
+		s.handleAddEnrichment(w, r, strings.TrimSuffix(strings.TrimPrefix(p, "/v1/albums/"), ":addEnrichment"))
+	case strings.HasPrefix(p, "/v1/albums/") && strings.HasSuffix(p, ":share") && r.Method == "POST":
+		s.handleShareAlbum(w, r, strings.TrimSuffix(strings.TrimPrefix(p, "/v1/albums/"), ":share"))
+	case strings.HasPrefix(p, "/v1/albums/") && r.Method == "GET":
+		s.handleGetAlbum(w, r, strings.TrimPrefix(p, "/v1/albums/"))
+	case p == "/v1/sharedAlbums" && r.Method == "GET":
+		s.handleListSharedAlbums(w, r)
+	case p == "/v1/sharedAlbums:join" && r.Method == "POST":
+		s.handleJoinSharedAlbum(w, r)
+	default:
+		writeError(w, http.StatusNotFound, fmt.Sprintf("No such API: %s %s", r.Method, p))
+	}
+}
+
+var statusOfCode = map[int]string{
+	http.StatusBadRequest:          "INVALID_ARGUMENT",
+	http.StatusUnauthorized:        "UNAUTHENTICATED",
+	http.StatusForbidden:           "PERMISSION_DENIED",
+	http.StatusNotFound:            "NOT_FOUND",
+	http.StatusTooManyRequests:     "RESOURCE_EXHAUSTED",
+	http.StatusInternalServerError: "INTERNAL",
+	http.StatusServiceUnavailable:  "UNAVAILABLE",
+}
+
+// writeError writes the error in the format of Google APIs.
+func writeError(w http.ResponseWriter, code int, message string) {
+	status, ok := statusOfCode[code]
+	if !ok {
+		status = "UNKNOWN"
+	}
+	writeJSON(w, code, map[string]interface{}{
+		"error": map[string]interface{}{
+			"code":    code,
+			"message": message,
+			"status":  status,
+		},
+	})
+}
+
+// pageOf returns the range of the page where the page token is the offset.
+func pageOf(total int, pageSize, maxPageSize int64, pageToken string) (int, int, string, error) {
+	if pageSize < 0 || pageSize > maxPageSize {
+		return 0, 0, "", fmt.Errorf("pageSize must be between 0 and %d", maxPageSize)
+	}
+	if pageSize == 0 {
+		pageSize = maxPageSize
+	}
+	var offset int
+	if pageToken != "" {
+		n, err := strconv.Atoi(pageToken)
+		if err != nil || n < 0 || n > total {
+			return 0, 0, "", fmt.Errorf("Invalid page token %s", pageToken)
+		}
+		offset = n
+	}
+	end := offset + int(pageSize)
+	if end >= total {
+		return offset, total, "", nil
+	}
+	return offset, end, strconv.Itoa(end), nil
+}
+
+func (s *Server) newID(prefix string) string {
+	s.lastID++
+	return fmt.Sprintf("%s%d", prefix, s.lastID)
+}
@@ -0,0 +1,245 @@
+package photostest
+
+import (
+	"bytes"
+	"fmt"
+	"net/http"
+	"reflect"
+	"strings"
+	"testing"
+
+	photoslibrary "google.golang.org/api/photoslibrary/v1"
+)
+
+func newService(t *testing.T, s *Server) *photoslibrary.Service {
+	t.Helper()
+	service, err := photoslibrary.New(s.Client())
+	if err != nil {
+		t.Fatal(err)
+	}
+	return service
+}
+
+func uploadTo(t *testing.T, s *Server, name string) string {
+	t.Helper()
+	req, err := http.NewRequest("POST", "https://photoslibrary.googleapis.com/v1/uploads", bytes.NewReader([]byte(name)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("X-Goog-Upload-File-Name", name)
+	req.Header.Set("X-Goog-Upload-Protocol", "raw")
+	res, err := s.Client().Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer res.Body.Close()
+	var b bytes.Buffer
+	b.ReadFrom(res.Body)
+	if res.StatusCode != 200 {
+		t.Fatalf("upload wants 200 but %s: %s", res.Status, b.String())
+	}
+	return b.String()
+}
+
+func newMediaItems(t *testing.T, s *Server, names ...string) []*photoslibrary.NewMediaItem {
+	var items []*photoslibrary.NewMediaItem
+	for _, name := range names {
+		items = append(items, &photoslibrary.NewMediaItem{
+			SimpleMediaItem: &photoslibrary.SimpleMediaItem{UploadToken: uploadTo(t, s, name)},
+		})
+	}
+	return items
+}
+
+func idsOf(res *photoslibrary.BatchCreateMediaItemsResponse) []string {
+	var ids []string
+	for _, r := range res.NewMediaItemResults {
+		ids = append(ids, r.MediaItem.Id)
+	}
+	return ids
+}
+
+func TestServer_AlbumPosition(t *testing.T) {
+	s := NewServer()
+	defer s.Close()
+	service := newService(t, s)
+	albumID := s.CreateAlbum("Trip")
+
+	first, err := service.MediaItems.BatchCreate(&photoslibrary.BatchCreateMediaItemsRequest{
+		AlbumId:       albumID,
+		NewMediaItems: newMediaItems(t, s, "a.jpg", "b.jpg"),
+	}).Do()
+	if err != nil {
+		t.Fatal(err)
+	}
+	a, b := idsOf(first)[0], idsOf(first)[1]
+	enrichment, err := service.Albums.AddEnrichment(albumID, &photoslibrary.AddEnrichmentToAlbumRequest{
+		NewEnrichmentItem: &photoslibrary.NewEnrichmentItem{TextEnrichment: &photoslibrary.TextEnrichment{Text: "Day 1"}},
+		AlbumPosition:     &photoslibrary.AlbumPosition{Position: "FIRST_IN_ALBUM"},
+	}).Do()
+	if err != nil {
+		t.Fatal(err)
+	}
+	e := enrichment.EnrichmentItem.Id
+	res, err := service.MediaItems.BatchCreate(&photoslibrary.BatchCreateMediaItemsRequest{
+		AlbumId:       albumID,
+		AlbumPosition: &photoslibrary.AlbumPosition{Position: "AFTER_MEDIA_ITEM", RelativeMediaItemId: a},
+		NewMediaItems: newMediaItems(t, s, "c.jpg"),
+	}).Do()
+	if err != nil {
+		t.Fatal(err)
+	}
+	c := idsOf(res)[0]
+	res, err = service.MediaItems.BatchCreate(&photoslibrary.BatchCreateMediaItemsRequest{
+		AlbumId:       albumID,
+		AlbumPosition: &photoslibrary.AlbumPosition{Position: "AFTER_ENRICHMENT_ITEM", RelativeEnrichmentItemId: e},
+		NewMediaItems: newMediaItems(t, s, "d.jpg"),
+	}).Do()
+	if err != nil {
+		t.Fatal(err)
+	}
+	d := idsOf(res)[0]
+	if want, got := []string{e, d, a, c, b}, s.AlbumEntries(albumID); !reflect.DeepEqual(want, got) {
+		t.Errorf("AlbumEntries wants %v but %v", want, got)
+	}
+
+	_, err = service.MediaItems.BatchCreate(&photoslibrary.BatchCreateMediaItemsRequest{
+		AlbumId:       albumID,
+		AlbumPosition: &photoslibrary.AlbumPosition{Position: "AFTER_MEDIA_ITEM", RelativeMediaItemId: "unknown"},
+		NewMediaItems: newMediaItems(t, s, "e.jpg"),
+	}).Do()
+	if err == nil {
+		t.Errorf("BatchCreate wants error for unknown media item but nil")
+	}
+}
+
+func TestServer_BatchCreate_Limit(t *testing.T) {
+	s := NewServer()
+	defer s.Close()
+	service := newService(t, s)
+	names := make([]string, 51)
+	for i := range names {
+		names[i] = fmt.Sprintf("%d.jpg", i)
+	}
+	_, err := service.MediaItems.BatchCreate(&photoslibrary.BatchCreateMediaItemsRequest{
+		NewMediaItems: newMediaItems(t, s, names...),
+	}).Do()
+	if err == nil || !strings.Contains(err.Error(), "400") {
+		t.Errorf("BatchCreate wants 400 for 51 items but %v", err)
+	}
+}
+
+func TestServer_Search(t *testing.T) {
+	s := NewServer()
+	defer s.Close()
+	service := newService(t, s)
+	var names []string
+	for i := 0; i < 30; i++ {
+		names = append(names, fmt.Sprintf("%d.jpg", i))
+	}
+	names = append(names, "movie.mp4")
+	albumID := s.CreateAlbum("Trip")
+	if _, err := service.MediaItems.BatchCreate(&photoslibrary.BatchCreateMediaItemsRequest{
+		AlbumId:       albumID,
+		NewMediaItems: newMediaItems(t, s, names...),
+	}).Do(); err != nil {
+		t.Fatal(err)
+	}
+
+	var count, pages int
+	req := &photoslibrary.SearchMediaItemsRequest{AlbumId: albumID, PageSize: 10}
+	for {
+		res, err := service.MediaItems.Search(req).Do()
+		if err != nil {
+			t.Fatal(err)
+		}
+		count += len(res.MediaItems)
+		pages++
+		if res.NextPageToken == "" {
+			break
+		}
+		req.PageToken = res.NextPageToken
+	}
+	if count != 31 || pages != 4 {
+		t.Errorf("Search wants 31 items in 4 pages but %d items in %d pages", count, pages)
+	}
+
+	res, err := service.MediaItems.Search(&photoslibrary.SearchMediaItemsRequest{
+		Filters: &photoslibrary.Filters{MediaTypeFilter: &photoslibrary.MediaTypeFilter{MediaTypes: []string{"VIDEO"}}},
+	}).Do()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(res.MediaItems) != 1 || res.MediaItems[0].MimeType != "video/mp4" {
+		t.Errorf("Search wants 1 video but %+v", res.MediaItems)
+	}
+	item, err := service.MediaItems.Get(res.MediaItems[0].Id).Do()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if item.Id != res.MediaItems[0].Id {
+		t.Errorf("Get wants %s but %s", res.MediaItems[0].Id, item.Id)
+	}
+}
+
+func TestServer_Share(t *testing.T) {
+	s := NewServer()
+	defer s.Close()
+	service := newService(t, s)
+	albumID := s.CreateAlbum("Trip")
+	res, err := service.Albums.Share(albumID, &photoslibrary.ShareAlbumRequest{
+		SharedAlbumOptions: &photoslibrary.SharedAlbumOptions{IsCollaborative: true},
+	}).Do()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.ShareInfo.ShareToken == "" {
+		t.Errorf("ShareToken wants non-empty but empty")
+	}
+	readOnlyID, token := s.AddSharedAlbum("Team", false)
+	if _, err := service.SharedAlbums.Join(&photoslibrary.JoinSharedAlbumRequest{ShareToken: token}).Do(); err != nil {
+		t.Fatal(err)
+	}
+	list, err := service.SharedAlbums.List().Do()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(list.SharedAlbums) != 2 {
+		t.Fatalf("SharedAlbums wants 2 albums but %d", len(list.SharedAlbums))
+	}
+	if a := list.SharedAlbums[1]; a.Id != readOnlyID || a.IsWriteable {
+		t.Errorf("SharedAlbums[1] wants read-only %s but %+v", readOnlyID, a)
+	}
+	_, err = service.MediaItems.BatchCreate(&photoslibrary.BatchCreateMediaItemsRequest{
+		AlbumId:       readOnlyID,
+		NewMediaItems: newMediaItems(t, s, "a.jpg"),
+	}).Do()
+	if err == nil || !strings.Contains(err.Error(), "403") {
+		t.Errorf("BatchCreate wants 403 for read-only album but %v", err)
+	}
+}
+
+func TestServer_Fault(t *testing.T) {
+	s := NewServer()
+	defer s.Close()
+	service := newService(t, s)
+	s.InjectFault(Fault{Method: "GET", Path: "/v1/albums", StatusCode: 429, Times: 1})
+	if _, err := service.Albums.List().Do(); err == nil || !strings.Contains(err.Error(), "429") {
+		t.Errorf("List wants 429 but %v", err)
+	}
+	if _, err := service.Albums.List().Do(); err != nil {
+		t.Errorf("List wants success after the fault but %s", err)
+	}
+	s.InjectFault(Fault{Truncate: true})
+	if _, err := service.Albums.List().Do(); err == nil {
+		t.Errorf("List wants error for truncated body but nil")
+	}
+	s.ClearFaults()
+	if _, err := service.Albums.List().Do(); err != nil {
+		t.Errorf("List wants success after clearing faults but %s", err)
+	}
+	want := []string{"GET /v1/albums", "GET /v1/albums", "GET /v1/albums", "GET /v1/albums"}
+	if got := s.Requests(); !reflect.DeepEqual(want, got) {
+		t.Errorf("Requests wants %v but %v", want, got)
+	}
+}
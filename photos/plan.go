@@ -0,0 +1,259 @@
+package photos
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/int128/gpup/photos/internal"
+	"google.golang.org/api/googleapi"
+	photoslibrary "google.golang.org/api/photoslibrary/v1"
+)
+
+// SizedUploadItem is an UploadItem which knows its size without opening it.
+type SizedUploadItem interface {
+	UploadItem
+	// Size returns the size in bytes, or -1 if unknown.
+	Size() (int64, error)
+}
+
+// Plan represents what the add operation would do.
+// It is created without calling any API which changes the library.
+type Plan struct {
+	// Action is one of library, create, reuse or join.
+	Action  string          `json:"action"`
+	Album   *PlannedAlbum   `json:"album,omitempty"`
+	Items   []*PlannedItem  `json:"items"`
+	Batches []*PlannedBatch `json:"batches"`
+	// TotalBytes is the sum of the known sizes of items to upload.
+	TotalBytes int64 `json:"totalBytes"`
+	// UnknownSizeItems is the number of items to upload of which size is unknown.
+	UnknownSizeItems int      `json:"unknownSizeItems"`
+	APICalls         APICalls `json:"apiCalls"`
+}
+
+// PlannedAlbum represents the album to add items to.
+type PlannedAlbum struct {
+	ID         string `json:"id,omitempty"`
+	Title      string `json:"title,omitempty"`
+	ShareToken string `json:"shareToken,omitempty"`
+}
+
+// PlannedItem represents an item to upload or skip.
+type PlannedItem struct {
+	Item UploadItem `json:"-"`
+	// Path is the full name of the item, e.g. path or URL.
+	Path string `json:"path"`
+	// Name is the filename known before upload.
+	// For a URL it is the last path component of the URL,
+	// which may differ from the uploaded name given by Content-Disposition or redirects.
+	Name string `json:"name"`
+	// Size is the size in bytes, or -1 if unknown.
+	Size int64 `json:"size"`
+	// Skip is the reason why the item would be skipped. Empty if the item would be uploaded.
+	Skip string `json:"skip,omitempty"`
+	// Batch is the index of the batch which contains the item, or -1 if skipped.
+	Batch int `json:"batch"`
+}
+
+// PlannedBatch represents a BatchCreate call.
+type PlannedBatch struct {
+	Items int `json:"items"`
+}
+
+// APICalls is the estimated number of API calls.
+type APICalls struct {
+	// Lookup is the number of calls to find the album, i.e. albums.get and albums.list.
+	Lookup          int `json:"lookup"`
+	CreateAlbum     int `json:"createAlbum"`
+	JoinSharedAlbum int `json:"joinSharedAlbum"`
+	Upload          int `json:"upload"`
+	BatchCreate     int `json:"batchCreate"`
+}
+
+// Total returns the total number of API calls.
+func (c APICalls) Total() int {
+	return c.Lookup + c.CreateAlbum + c.JoinSharedAlbum + c.Upload + c.BatchCreate
+}
+
+// PlanAddToLibrary returns the plan of AddToLibrary.
+func (p *Photos) PlanAddToLibrary(ctx context.Context, uploadItems []UploadItem) *Plan {
+	plan := &Plan{Action: "library"}
+	planItems(plan, uploadItems)
+	return plan
+}
+
+// PlanAddToAlbum returns the plan of AddToAlbum.
+// It finds the album by read-only API calls but does not create it.
+func (p *Photos) PlanAddToAlbum(ctx context.Context, title string, uploadItems []UploadItem) (*Plan, error) {
+	q, c := p.newPlanner()
+	album, err := q.FindAlbumByTitle(ctx, title)
+	if err != nil {
+		return nil, err
+	}
+	if album == nil {
+		album, err = q.findRecordedAlbum(ctx, title)
+		if err != nil {
+			return nil, err
+		}
+	}
+	plan := &Plan{Action: "create", Album: &PlannedAlbum{Title: title}}
+	if album != nil {
+		if !album.IsWriteable {
+			return nil, fmt.Errorf("Album %s is not writeable", album.Title)
+		}
+		plan.Action = "reuse"
+		plan.Album.ID = album.Id
+	} else {
+		plan.APICalls.CreateAlbum = 1
+	}
+	plan.APICalls.Lookup = c.count
+	planItems(plan, uploadItems)
+	return plan, nil
+}
+
+// PlanAddToAlbumByID returns the plan of AddToAlbumByID.
+func (p *Photos) PlanAddToAlbumByID(ctx context.Context, id string, uploadItems []UploadItem) (*Plan, error) {
+	q, c := p.newPlanner()
+	album, err := q.GetAlbum(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	if !album.IsWriteable {
+		return nil, fmt.Errorf("Album %s is not writeable", album.Title)
+	}
+	plan := &Plan{Action: "reuse", Album: &PlannedAlbum{ID: album.Id, Title: album.Title}}
+	plan.APICalls.Lookup = c.count
+	planItems(plan, uploadItems)
+	return plan, nil
+}
+
+// PlanAddToSharedAlbum returns the plan of AddToSharedAlbum.
+// It does not join the shared album.
+func (p *Photos) PlanAddToSharedAlbum(ctx context.Context, shareToken string, uploadItems []UploadItem) (*Plan, error) {
+	q, c := p.newPlanner()
+	album, err := q.FindSharedAlbumByShareToken(ctx, shareToken)
+	if err != nil {
+		return nil, err
+	}
+	plan := &Plan{Action: "join", Album: &PlannedAlbum{ShareToken: shareToken}}
+	if album != nil {
+		plan.Album.ID = album.Id
+		plan.Album.Title = album.Title
+	}
+	plan.APICalls.JoinSharedAlbum = 1
+	plan.APICalls.Lookup = c.count
+	planItems(plan, uploadItems)
+	return plan, nil
+}
+
+// PlanCreateAlbum returns the plan of CreateAlbum.
+func (p *Photos) PlanCreateAlbum(ctx context.Context, title string, uploadItems []UploadItem) *Plan {
+	plan := &Plan{Action: "create", Album: &PlannedAlbum{Title: title}}
+	plan.APICalls.CreateAlbum = 1
+	planItems(plan, uploadItems)
+	return plan
+}
+
+// planItems checks the items and lays out the batches in the same way as add.
+func planItems(plan *Plan, uploadItems []UploadItem) {
+	for _, batch := range split(uploadItems, batchCreateSize) {
+		var pb PlannedBatch
+		for _, item := range batch {
+			pi := planItem(item)
+			plan.Items = append(plan.Items, pi)
+			if pi.Skip != "" {
+				pi.Batch = -1
+				continue
+			}
+			pi.Batch = len(plan.Batches)
+			pb.Items++
+			plan.APICalls.Upload++
+			if pi.Size < 0 {
+				plan.UnknownSizeItems++
+			} else {
+				plan.TotalBytes += pi.Size
+			}
+		}
+		if pb.Items > 0 {
+			plan.Batches = append(plan.Batches, &pb)
+			plan.APICalls.BatchCreate++
+		}
+	}
+}
+
+func planItem(item UploadItem) *PlannedItem {
+	pi := &PlannedItem{Item: item, Path: item.String(), Name: item.Name(), Size: -1}
+	size, err := checkUploadItem(item)
+	if err != nil {
+		pi.Skip = err.Error()
+		return pi
+	}
+	pi.Size = size
+	return pi
+}
+
+// checkUploadItem returns the size of the item, or -1 if unknown.
+// It returns an error if the item should be skipped, e.g. an empty file.
+// Both the plan and the add operation skip items by this.
+func checkUploadItem(item UploadItem) (int64, error) {
+	sized, ok := item.(SizedUploadItem)
+	if !ok {
+		return -1, nil
+	}
+	size, err := sized.Size()
+	switch {
+	case err != nil:
+		return -1, fmt.Errorf("Could not get the size: %s", err)
+	case size == 0:
+		return -1, fmt.Errorf("Empty file")
+	case size < 0:
+		return -1, nil
+	}
+	return size, nil
+}
+
+// newPlanner returns a copy of the Photos which counts read-only API calls.
+func (p *Photos) newPlanner() (*Photos, *lookupCounter) {
+	c := &lookupCounter{Photos: p.service}
+	q := *p
+	q.service = c
+	return &q, c
+}
+
+// lookupCounter counts calls of albums.get and albums.list.
+// It rejects any API call which changes the library.
+type lookupCounter struct {
+	internal.Photos
+	count int
+}
+
+func (c *lookupCounter) GetAlbum(ctx context.Context, id string) (*photoslibrary.Album, error) {
+	c.count++
+	return c.Photos.GetAlbum(ctx, id)
+}
+
+func (c *lookupCounter) ListAlbums(ctx context.Context, pageSize int64, pageToken string, fields ...googleapi.Field) (*photoslibrary.ListAlbumsResponse, error) {
+	c.count++
+	return c.Photos.ListAlbums(ctx, pageSize, pageToken, fields...)
+}
+
+func (c *lookupCounter) ListSharedAlbums(ctx context.Context, pageSize int64, pageToken string, fields ...googleapi.Field) (*photoslibrary.ListSharedAlbumsResponse, error) {
+	c.count++
+	return c.Photos.ListSharedAlbums(ctx, pageSize, pageToken, fields...)
+}
+
+func (c *lookupCounter) Upload(ctx context.Context, item internal.UploadItem) (internal.UploadToken, error) {
+	return "", fmt.Errorf("Upload is not allowed in a plan")
+}
+
+func (c *lookupCounter) BatchCreate(ctx context.Context, r *photoslibrary.BatchCreateMediaItemsRequest) (*photoslibrary.BatchCreateMediaItemsResponse, error) {
+	return nil, fmt.Errorf("BatchCreate is not allowed in a plan")
+}
+
+func (c *lookupCounter) CreateAlbum(ctx context.Context, r *photoslibrary.CreateAlbumRequest) (*photoslibrary.Album, error) {
+	return nil, fmt.Errorf("CreateAlbum is not allowed in a plan")
+}
+
+func (c *lookupCounter) JoinSharedAlbum(ctx context.Context, shareToken string) error {
+	return fmt.Errorf("JoinSharedAlbum is not allowed in a plan")
+}
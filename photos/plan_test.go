@@ -0,0 +1,102 @@
+package photos
+
+import (
+	"context"
+	"reflect"
+	"strings"
+	"testing"
+
+	photoslibrary "google.golang.org/api/photoslibrary/v1"
+)
+
+type sizedUploadItemMock struct {
+	uploadItemMock
+	size int64
+}
+
+func (m sizedUploadItemMock) Size() (int64, error) { return m.size, nil }
+
+func TestPhotos_PlanAddToAlbum(t *testing.T) {
+	defer func(restore int) { batchCreateSize = restore }(batchCreateSize)
+	batchCreateSize = 10
+
+	m := &serviceMock{albums: makeAlbums(120, "own")}
+	p := &Photos{service: m}
+	items := makeUploadItems(25)
+	items[0] = sizedUploadItemMock{uploadItemMock(0), 100}
+	items[1] = sizedUploadItemMock{uploadItemMock(1), 0}
+	items[2] = sizedUploadItemMock{uploadItemMock(2), 200}
+
+	for _, c := range []struct {
+		title  string
+		action string
+		id     string
+		calls  APICalls
+	}{
+		// 3 pages of own albums and 1 page of shared albums
+		{"Album 0", "reuse", "own0", APICalls{Lookup: 4, Upload: 24, BatchCreate: 3}},
+		{"Nothing", "create", "", APICalls{Lookup: 4, CreateAlbum: 1, Upload: 24, BatchCreate: 3}},
+	} {
+		t.Run(c.title, func(t *testing.T) {
+			plan, err := p.PlanAddToAlbum(context.Background(), c.title, items)
+			if err != nil {
+				t.Fatalf("PlanAddToAlbum returns error: %s", err)
+			}
+			if plan.Action != c.action {
+				t.Errorf("Action wants %s but %s", c.action, plan.Action)
+			}
+			if plan.Album.ID != c.id {
+				t.Errorf("Album.ID wants %s but %s", c.id, plan.Album.ID)
+			}
+			if plan.APICalls != c.calls {
+				t.Errorf("APICalls wants %+v but %+v", c.calls, plan.APICalls)
+			}
+			var batches []int
+			for _, b := range plan.Batches {
+				batches = append(batches, b.Items)
+			}
+			if want := []int{9, 10, 5}; !reflect.DeepEqual(want, batches) {
+				t.Errorf("Batches wants %v but %v", want, batches)
+			}
+			if plan.Items[1].Skip == "" || plan.Items[1].Batch != -1 {
+				t.Errorf("Items[1] wants skip but %+v", plan.Items[1])
+			}
+			if plan.TotalBytes != 300 {
+				t.Errorf("TotalBytes wants 300 but %d", plan.TotalBytes)
+			}
+			if plan.UnknownSizeItems != 22 {
+				t.Errorf("UnknownSizeItems wants 22 but %d", plan.UnknownSizeItems)
+			}
+		})
+	}
+	if m.uploadCalls != 0 || len(m.batchCreateCalls) != 0 || len(m.albums) != 120 {
+		t.Errorf("Plan wants no change but upload=%d, batchCreate=%d, albums=%d", m.uploadCalls, len(m.batchCreateCalls), len(m.albums))
+	}
+}
+
+func TestPhotos_add_SkipAsPlanned(t *testing.T) {
+	defer func(restore int) { batchCreateSize = restore }(batchCreateSize)
+	batchCreateSize = 10
+
+	m := &serviceMock{}
+	p := &Photos{service: m}
+	items := makeUploadItems(25)
+	items[1] = sizedUploadItemMock{uploadItemMock(1), 0}
+	plan := p.PlanAddToLibrary(context.Background(), items)
+	results := p.add(context.Background(), items, photoslibrary.BatchCreateMediaItemsRequest{})
+	for i, r := range results {
+		if skip := plan.Items[i].Skip; skip != "" {
+			if r.Error == nil || !strings.Contains(r.Error.Error(), skip) {
+				t.Errorf("results[%d].Error wants %s but %v", i, skip, r.Error)
+			}
+		} else if r.Error != nil {
+			t.Errorf("results[%d].Error wants nil but %s", i, r.Error)
+		}
+	}
+	if int(m.uploadCalls) != plan.APICalls.Upload {
+		t.Errorf("Upload API call wants %d times but %d", plan.APICalls.Upload, m.uploadCalls)
+	}
+	if len(m.batchCreateCalls) != plan.APICalls.BatchCreate {
+		t.Errorf("BatchCreate API call wants %d times but %d", plan.APICalls.BatchCreate, len(m.batchCreateCalls))
+	}
+}
@@ -1,8 +1,10 @@
 package photos
 
 import (
+	"context"
 	"net/http"
 
+	"github.com/int128/gpup/logging"
 	"github.com/int128/gpup/photos/internal"
 	"golang.org/x/oauth2/google"
 	"google.golang.org/api/photoslibrary/v1"
@@ -12,18 +14,93 @@ import (
 var Endpoint = google.Endpoint
 
 // Scopes is a set of OAuth scopes.
-var Scopes = []string{photoslibrary.PhotoslibraryScope}
+// The sharing scope is required to join shared albums.
+var Scopes = []string{photoslibrary.PhotoslibraryScope, photoslibrary.PhotoslibrarySharingScope}
 
 // Photos provides service for manage albums and uploading media items.
 type Photos struct {
 	service internal.Photos
+	metrics Metrics
+	logger  logging.Logger
+
+	// DuplicateAlbumPolicy chooses an album if multiple albums have the same title.
+	// Default to FailOnDuplicateAlbums.
+	DuplicateAlbumPolicy DuplicateAlbumPolicy
+	// AlbumRecorder records albums created by this package. Optional.
+	AlbumRecorder AlbumRecorder
+	// AlbumLocker provides exclusive access for finding or creating an album. Optional.
+	AlbumLocker AlbumLocker
+	// AlbumCache stores IDs of albums by title. Optional.
+	AlbumCache AlbumCache
+	// AlbumPosition is the position to add items in an album.
+	// Subsequent batches are placed after the previous batch to keep the order of items.
+	// Default to LAST_IN_ALBUM.
+	AlbumPosition *photoslibrary.AlbumPosition
+	// Concurrency is the number of concurrent uploads.
+	// Default to 4.
+	Concurrency int
+}
+
+// AlbumRecorder records albums created by this package.
+// It allows reusing an album which does not appear in the list yet.
+type AlbumRecorder interface {
+	// Created is called when an album is created.
+	Created(album *photoslibrary.Album)
+	// Find returns IDs of the albums created with the title.
+	Find(title string) []string
+}
+
+// AlbumLocker provides exclusive access for finding or creating an album,
+// e.g. between processes.
+type AlbumLocker interface {
+	// LockAlbum acquires the lock and returns a function to release it.
+	LockAlbum(ctx context.Context, title string) (unlock func(), err error)
+}
+
+// Options represents options to create a Photos.
+type Options struct {
+	// BasePath is the base URL of the API.
+	// Default to https://photoslibrary.googleapis.com/.
+	BasePath string
+	// UploadURL is the URL to upload media items.
+	// Default to v1/uploads of BasePath.
+	UploadURL string
+	// Metrics receives measurements of API calls and uploads. Optional.
+	Metrics Metrics
+	// Logger writes logs. Default to logging.Default.
+	// You can pass a *slog.Logger as well.
+	Logger logging.Logger
 }
 
+// Metrics receives measurements of API calls and uploads.
+// Implement it to export them to your monitoring system,
+// or use github.com/int128/gpup/metrics for Prometheus.
+type Metrics interface {
+	internal.Metrics
+}
+
+// Item states reported to Metrics.
+const (
+	ItemUploaded = "uploaded"
+	ItemAdded    = "added"
+	ItemFailed   = "failed"
+)
+
 // New creates a Photos.
 func New(client *http.Client) (*Photos, error) {
-	service, err := internal.New(client)
+	return NewWithOptions(client, Options{})
+}
+
+// NewWithOptions creates a Photos with the options.
+// It allows routing requests through a proxy or an emulator.
+func NewWithOptions(client *http.Client, o Options) (*Photos, error) {
+	opts := internal.Options{Logger: o.Logger}
+	if o.Metrics != nil {
+		opts.Metrics = o.Metrics
+	}
+	service, err := internal.NewWithOptions(client, internal.Endpoint{BasePath: o.BasePath, UploadURL: o.UploadURL}, opts)
 	if err != nil {
 		return nil, err
 	}
-	return &Photos{service}, nil
+	return &Photos{service: service, metrics: o.Metrics, logger: o.Logger}, nil
 }
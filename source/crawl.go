@@ -0,0 +1,225 @@
+// Package source provides backends to find items to upload.
+package source
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"net/url"
+	"path"
+	"strings"
+
+	"github.com/int128/gpup/logging"
+	"github.com/int128/gpup/photos"
+	"golang.org/x/net/html"
+)
+
+// DefaultCrawlPatterns is a set of filename patterns of media items.
+var DefaultCrawlPatterns = []string{
+	"*.jpg", "*.jpeg", "*.png", "*.gif", "*.webp", "*.heic", "*.heif", "*.tif", "*.tiff", "*.bmp",
+	"*.mp4", "*.m4v", "*.mov", "*.mpg", "*.mpeg", "*.avi", "*.webm", "*.3gp", "*.mkv",
+}
+
+// NewRequestFunc returns a request for the URL, e.g. with credentials.
+type NewRequestFunc func(ctx context.Context, rawurl string) (*http.Request, error)
+
+// Crawler finds media links in HTML pages such as directory listings and galleries.
+type Crawler struct {
+	Client     *http.Client
+	NewRequest NewRequestFunc // Default to a plain GET request.
+	MaxDepth   int            // Depth of pages to follow. 0 means only the given page.
+	AnyHost    bool           // Allow media links to other hosts.
+	Patterns   []string       // Filename patterns of media links. Default to DefaultCrawlPatterns.
+	Logger     logging.Logger // Default to logging.Default.
+}
+
+type crawlPage struct {
+	url   *url.URL
+	depth int
+}
+
+// Crawl returns the media items found in the page and its subpages.
+// Only pages under the directory of the given URL are followed.
+// If the given URL is not a HTML page, it returns the URL as an item.
+func (c *Crawler) Crawl(ctx context.Context, rawurl string) ([]photos.UploadItem, error) {
+	root, err := url.Parse(rawurl)
+	if err != nil {
+		return nil, fmt.Errorf("Could not parse URL: %s", err)
+	}
+	root.Fragment = ""
+	rootDir := root.Path
+	if !strings.HasSuffix(rootDir, "/") {
+		rootDir = path.Dir(rootDir) + "/"
+	}
+
+	items := make([]photos.UploadItem, 0)
+	visited := map[string]bool{root.String(): true}
+	queue := []crawlPage{{root, 0}}
+	for len(queue) > 0 {
+		page := queue[0]
+		queue = queue[1:]
+		links, isHTML, err := c.fetchLinks(ctx, page.url)
+		if err != nil {
+			if page.depth == 0 {
+				return nil, fmt.Errorf("Could not crawl %s: %s", page.url, err)
+			}
+			c.log().Warn("Skip crawling", "url", page.url.String(), "error", err)
+			continue
+		}
+		if !isHTML {
+			if page.depth == 0 {
+				item, err := c.newUploadItem(ctx, page.url)
+				if err != nil {
+					return nil, err
+				}
+				items = append(items, item)
+			}
+			continue
+		}
+		for _, link := range links {
+			if visited[link.String()] {
+				continue
+			}
+			visited[link.String()] = true
+			switch {
+			case c.isMedia(link):
+				if !c.AnyHost && link.Host != root.Host {
+					continue
+				}
+				item, err := c.newUploadItem(ctx, link)
+				if err != nil {
+					return nil, err
+				}
+				items = append(items, item)
+			case page.depth < c.MaxDepth && isPage(link, page.url, root.Host, rootDir):
+				queue = append(queue, crawlPage{link, page.depth + 1})
+			}
+		}
+	}
+	return items, nil
+}
+
+func (c *Crawler) newRequest(ctx context.Context, u *url.URL) (*http.Request, error) {
+	if c.NewRequest != nil {
+		return c.NewRequest(ctx, u.String())
+	}
+	r, err := http.NewRequest("GET", u.String(), nil)
+	if err != nil {
+		return nil, err
+	}
+	return r.WithContext(ctx), nil
+}
+
+func (c *Crawler) newUploadItem(ctx context.Context, u *url.URL) (photos.UploadItem, error) {
+	r, err := c.newRequest(ctx, u)
+	if err != nil {
+		return nil, fmt.Errorf("Could not create a request for %s: %s", u, err)
+	}
+	return &photos.HTTPUploadItem{Client: c.Client, Request: r}, nil
+}
+
+// fetchLinks returns the links in the page.
+// If the page is not HTML, it returns false.
+func (c *Crawler) fetchLinks(ctx context.Context, u *url.URL) ([]*url.URL, bool, error) {
+	req, err := c.newRequest(ctx, u)
+	if err != nil {
+		return nil, false, err
+	}
+	res, err := c.Client.Do(req)
+	if err != nil {
+		return nil, false, err
+	}
+	defer res.Body.Close()
+	if res.StatusCode < 200 || res.StatusCode > 299 {
+		return nil, false, fmt.Errorf("Got %s", res.Status)
+	}
+	mediaType, _, _ := mime.ParseMediaType(res.Header.Get("Content-Type"))
+	if mediaType != "text/html" && mediaType != "application/xhtml+xml" {
+		return nil, false, nil
+	}
+	links, err := findLinks(res.Body, res.Request.URL)
+	if err != nil {
+		return nil, false, err
+	}
+	return links, true, nil
+}
+
+// findLinks returns URLs of a, img, source and video elements in the HTML.
+func findLinks(r io.Reader, base *url.URL) ([]*url.URL, error) {
+	doc, err := html.Parse(r)
+	if err != nil {
+		return nil, fmt.Errorf("Could not parse HTML: %s", err)
+	}
+	var links []*url.URL
+	var walk func(*html.Node)
+	walk = func(n *html.Node) {
+		if n.Type == html.ElementNode {
+			for _, attr := range n.Attr {
+				switch {
+				case n.Data == "base" && attr.Key == "href":
+					if u, err := base.Parse(attr.Val); err == nil {
+						base = u
+					}
+				case n.Data == "a" && attr.Key == "href",
+					n.Data == "img" && attr.Key == "src",
+					n.Data == "source" && attr.Key == "src",
+					n.Data == "video" && attr.Key == "src":
+					u, err := base.Parse(strings.TrimSpace(attr.Val))
+					if err != nil || (u.Scheme != "http" && u.Scheme != "https") {
+						continue
+					}
+					u.Fragment = ""
+					links = append(links, u)
+				}
+			}
+		}
+		for child := n.FirstChild; child != nil; child = child.NextSibling {
+			walk(child)
+		}
+	}
+	walk(doc)
+	return links, nil
+}
+
+func (c *Crawler) isMedia(u *url.URL) bool {
+	patterns := c.Patterns
+	if len(patterns) == 0 {
+		patterns = DefaultCrawlPatterns
+	}
+	name := strings.ToLower(path.Base(u.Path))
+	for _, pattern := range patterns {
+		if ok, _ := path.Match(strings.ToLower(pattern), name); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// isPage returns true if the link should be followed.
+// It excludes links to other hosts, parent directories and the same page with queries,
+// such as sort links of autoindex.
+func isPage(link, current *url.URL, host, dir string) bool {
+	if link.Host != host {
+		return false
+	}
+	if !strings.HasPrefix(link.Path, dir) {
+		return false
+	}
+	if link.Path == current.Path {
+		return false
+	}
+	switch strings.ToLower(path.Ext(link.Path)) {
+	case "", ".html", ".htm", ".php", ".asp", ".aspx", ".jsp", ".cgi":
+		return true
+	}
+	return strings.HasSuffix(link.Path, "/")
+}
+
+func (c *Crawler) log() logging.Logger {
+	if c.Logger != nil {
+		return c.Logger
+	}
+	return logging.Default
+}
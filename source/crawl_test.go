@@ -0,0 +1,106 @@
+package source
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"sort"
+	"testing"
+)
+
+func newAutoindexServer(t *testing.T) *httptest.Server {
+	pages := map[string]string{
+		"/photos/": `<html><body><h1>Index of /photos/</h1>
+<a href="?C=N;O=D">Name</a>
+<a href="../">../</a>
+<a href="a.jpg">a.jpg</a>
+<a href="B.PNG">B.PNG</a>
+<a href="notes.txt">notes.txt</a>
+<a href="2018/">2018/</a>
+<a href="http://other.example.com/c.jpg">c.jpg</a>
+</body></html>`,
+		"/photos/2018/": `<html><body>
+<a href="../">../</a>
+<a href="d.mp4">d.mp4</a>
+<a href="trip/">trip/</a>
+</body></html>`,
+		"/photos/2018/trip/": `<html><body>
+<img src="/photos/2018/trip/e.jpg">
+</body></html>`,
+		"/": `<html><body><a href="/secret.jpg">secret.jpg</a></body></html>`,
+	}
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if page, ok := pages[r.URL.Path]; ok {
+			w.Header().Set("Content-Type", "text/html; charset=utf-8")
+			fmt.Fprint(w, page)
+			return
+		}
+		w.Header().Set("Content-Type", "application/octet-stream")
+		fmt.Fprint(w, "example")
+	}))
+}
+
+func crawledURLs(t *testing.T, c *Crawler, rawurl string) []string {
+	items, err := c.Crawl(context.Background(), rawurl)
+	if err != nil {
+		t.Fatalf("Crawl returns error: %s", err)
+	}
+	urls := make([]string, 0)
+	for _, item := range items {
+		urls = append(urls, item.String())
+	}
+	sort.Strings(urls)
+	return urls
+}
+
+func TestCrawler_Crawl(t *testing.T) {
+	s := newAutoindexServer(t)
+	defer s.Close()
+
+	for _, c := range []struct {
+		name    string
+		crawler Crawler
+		want    []string
+	}{
+		{"depth=0", Crawler{}, []string{"/photos/B.PNG", "/photos/a.jpg"}},
+		{"depth=1", Crawler{MaxDepth: 1}, []string{"/photos/2018/d.mp4", "/photos/B.PNG", "/photos/a.jpg"}},
+		{"depth=2", Crawler{MaxDepth: 2}, []string{"/photos/2018/d.mp4", "/photos/2018/trip/e.jpg", "/photos/B.PNG", "/photos/a.jpg"}},
+		{"patterns", Crawler{MaxDepth: 2, Patterns: []string{"*.mp4"}}, []string{"/photos/2018/d.mp4"}},
+	} {
+		t.Run(c.name, func(t *testing.T) {
+			c.crawler.Client = s.Client()
+			got := crawledURLs(t, &c.crawler, s.URL+"/photos/")
+			want := make([]string, len(c.want))
+			for i, p := range c.want {
+				want[i] = s.URL + p
+			}
+			if !reflect.DeepEqual(want, got) {
+				t.Errorf("Crawl wants %v but %v", want, got)
+			}
+		})
+	}
+}
+
+func TestCrawler_Crawl_AnyHost(t *testing.T) {
+	s := newAutoindexServer(t)
+	defer s.Close()
+	c := Crawler{Client: s.Client(), AnyHost: true}
+	got := crawledURLs(t, &c, s.URL+"/photos/")
+	want := []string{s.URL + "/photos/B.PNG", s.URL + "/photos/a.jpg", "http://other.example.com/c.jpg"}
+	if !reflect.DeepEqual(want, got) {
+		t.Errorf("Crawl wants %v but %v", want, got)
+	}
+}
+
+func TestCrawler_Crawl_NotHTML(t *testing.T) {
+	s := newAutoindexServer(t)
+	defer s.Close()
+	c := Crawler{Client: s.Client()}
+	got := crawledURLs(t, &c, s.URL+"/photos/a.jpg")
+	want := []string{s.URL + "/photos/a.jpg"}
+	if !reflect.DeepEqual(want, got) {
+		t.Errorf("Crawl wants %v but %v", want, got)
+	}
+}
@@ -0,0 +1,251 @@
+package source
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"path"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/int128/gpup/photos"
+)
+
+// S3 provides access to S3-compatible storage such as Amazon S3 or MinIO.
+// Requests are sent in path-style and signed with AWS Signature Version 4.
+type S3 struct {
+	Client          *http.Client
+	Endpoint        string // e.g. https://s3.us-east-1.amazonaws.com or http://localhost:9000
+	Region          string
+	AccessKeyID     string
+	SecretAccessKey string
+	SessionToken    string // Optional.
+}
+
+// ParseS3URL returns the bucket and prefix of the URL in form of s3://bucket/prefix.
+func ParseS3URL(rawurl string) (string, string, error) {
+	u, err := url.Parse(rawurl)
+	if err != nil {
+		return "", "", fmt.Errorf("Could not parse URL: %s", err)
+	}
+	if u.Scheme != "s3" || u.Host == "" {
+		return "", "", fmt.Errorf("Invalid S3 URL %s: wants s3://bucket/prefix", rawurl)
+	}
+	return u.Host, strings.TrimPrefix(u.Path, "/"), nil
+}
+
+type listBucketResult struct {
+	Contents []struct {
+		Key  string
+		Size int64
+	}
+	IsTruncated           bool
+	NextContinuationToken string
+}
+
+// List returns the objects which have the prefix.
+// Keys ending with a slash are ignored as they are folders.
+func (s *S3) List(ctx context.Context, bucket, prefix string) ([]photos.UploadItem, error) {
+	items := make([]photos.UploadItem, 0)
+	var token string
+	for {
+		q := url.Values{}
+		q.Set("list-type", "2")
+		q.Set("prefix", prefix)
+		if token != "" {
+			q.Set("continuation-token", token)
+		}
+		res, err := s.do(ctx, bucket, "", q)
+		if err != nil {
+			return nil, fmt.Errorf("Could not list objects in s3://%s/%s: %s", bucket, prefix, err)
+		}
+		var result listBucketResult
+		err = xml.NewDecoder(res.Body).Decode(&result)
+		res.Body.Close()
+		if err != nil {
+			return nil, fmt.Errorf("Could not decode the list of s3://%s/%s: %s", bucket, prefix, err)
+		}
+		for _, content := range result.Contents {
+			if strings.HasSuffix(content.Key, "/") {
+				continue
+			}
+			items = append(items, &S3UploadItem{S3: s, Bucket: bucket, Key: content.Key, size: content.Size, ctx: ctx})
+		}
+		if !result.IsTruncated || result.NextContinuationToken == "" {
+			return items, nil
+		}
+		token = result.NextContinuationToken
+	}
+}
+
+// do sends a signed GET request and returns the response if status is 2xx.
+func (s *S3) do(ctx context.Context, bucket, key string, q url.Values) (*http.Response, error) {
+	endpoint, err := url.Parse(s.Endpoint)
+	if err != nil {
+		return nil, fmt.Errorf("Invalid endpoint %s: %s", s.Endpoint, err)
+	}
+	u := *endpoint
+	u.Path = path.Join("/", endpoint.Path, bucket, key)
+	if key == "" {
+		u.Path += "/"
+	}
+	// send the path in the same encoding as the signature
+	u.RawPath = uriEncodePath(u.Path)
+	u.RawQuery = strings.Replace(q.Encode(), "+", "%20", -1)
+	req, err := http.NewRequest("GET", u.String(), nil)
+	if err != nil {
+		return nil, err
+	}
+	req = req.WithContext(ctx)
+	s.sign(req, time.Now())
+	res, err := s.Client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if res.StatusCode < 200 || res.StatusCode > 299 {
+		b, _ := ioutil.ReadAll(io.LimitReader(res.Body, 1024))
+		res.Body.Close()
+		return nil, fmt.Errorf("Got %s: %s", res.Status, string(b))
+	}
+	return res, nil
+}
+
+const emptyPayloadHash = "e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855"
+
+// sign sets the authorization header of AWS Signature Version 4 to the request without body.
+// See https://docs.aws.amazon.com/AmazonS3/latest/API/sig-v4-header-based-auth.html
+func (s *S3) sign(req *http.Request, now time.Time) {
+	amzDate := now.UTC().Format("20060102T150405Z")
+	date := amzDate[:8]
+	req.Header.Set("X-Amz-Date", amzDate)
+	req.Header.Set("X-Amz-Content-Sha256", emptyPayloadHash)
+	if s.SessionToken != "" {
+		req.Header.Set("X-Amz-Security-Token", s.SessionToken)
+	}
+
+	headers := map[string]string{"host": req.URL.Host}
+	for k, v := range req.Header {
+		headers[strings.ToLower(k)] = strings.TrimSpace(strings.Join(v, ","))
+	}
+	var names []string
+	for k := range headers {
+		names = append(names, k)
+	}
+	sort.Strings(names)
+	var canonicalHeaders strings.Builder
+	for _, k := range names {
+		canonicalHeaders.WriteString(k + ":" + headers[k] + "\n")
+	}
+	signedHeaders := strings.Join(names, ";")
+
+	canonicalRequest := strings.Join([]string{
+		req.Method,
+		uriEncodePath(req.URL.Path),
+		canonicalQuery(req.URL.Query()),
+		canonicalHeaders.String(),
+		signedHeaders,
+		emptyPayloadHash,
+	}, "\n")
+	scope := date + "/" + s.Region + "/s3/aws4_request"
+	stringToSign := "AWS4-HMAC-SHA256\n" + amzDate + "\n" + scope + "\n" + hexSHA256(canonicalRequest)
+
+	key := hmacSHA256([]byte("AWS4"+s.SecretAccessKey), date)
+	key = hmacSHA256(key, s.Region)
+	key = hmacSHA256(key, "s3")
+	key = hmacSHA256(key, "aws4_request")
+	signature := hex.EncodeToString(hmacSHA256(key, stringToSign))
+	req.Header.Set("Authorization", fmt.Sprintf("AWS4-HMAC-SHA256 Credential=%s/%s, SignedHeaders=%s, Signature=%s",
+		s.AccessKeyID, scope, signedHeaders, signature))
+}
+
+func canonicalQuery(q url.Values) string {
+	var keys []string
+	for k := range q {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	var pairs []string
+	for _, k := range keys {
+		values := q[k]
+		sort.Strings(values)
+		for _, v := range values {
+			pairs = append(pairs, uriEncode(k)+"="+uriEncode(v))
+		}
+	}
+	return strings.Join(pairs, "&")
+}
+
+// uriEncode encodes the string except the unreserved characters of RFC 3986.
+func uriEncode(s string) string {
+	return strings.Replace(url.QueryEscape(s), "+", "%20", -1)
+}
+
+// uriEncodePath encodes each segment of the path by uriEncode.
+func uriEncodePath(p string) string {
+	segments := strings.Split(p, "/")
+	for i, segment := range segments {
+		segments[i] = uriEncode(segment)
+	}
+	return strings.Join(segments, "/")
+}
+
+func hexSHA256(s string) string {
+	h := sha256.Sum256([]byte(s))
+	return hex.EncodeToString(h[:])
+}
+
+func hmacSHA256(key []byte, s string) []byte {
+	h := hmac.New(sha256.New, key)
+	h.Write([]byte(s))
+	return h.Sum(nil)
+}
+
+// S3UploadItem represents an object in S3-compatible storage.
+// The request is signed on opening, so it does not expire while waiting in the queue.
+type S3UploadItem struct {
+	S3     *S3
+	Bucket string
+	Key    string
+
+	size int64           // size in the list
+	ctx  context.Context // context of the list operation
+}
+
+// Open returns a stream.
+// Caller should close it finally.
+func (m *S3UploadItem) Open() (io.ReadCloser, int64, error) {
+	ctx := m.ctx
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	res, err := m.S3.do(ctx, m.Bucket, m.Key, url.Values{})
+	if err != nil {
+		return nil, 0, err
+	}
+	return res.Body, res.ContentLength, nil
+}
+
+// Size returns the size in the list, or -1 if unknown.
+func (m *S3UploadItem) Size() (int64, error) {
+	if m.size <= 0 {
+		return -1, nil
+	}
+	return m.size, nil
+}
+
+// Name returns the filename.
+func (m *S3UploadItem) Name() string {
+	return path.Base(m.Key)
+}
+
+func (m *S3UploadItem) String() string {
+	return "s3://" + m.Bucket + "/" + m.Key
+}
@@ -0,0 +1,154 @@
+package source
+
+import (
+	"context"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/int128/gpup/photos"
+)
+
+// WebDAV provides access to WebDAV shares such as Nextcloud.
+type WebDAV struct {
+	Client     *http.Client
+	NewRequest NewRequestFunc // Default to a plain GET request.
+}
+
+// ParseWebDAVURL returns the HTTP URL of the URL in form of webdav://host/path.
+// webdav:// is mapped to https:// and webdav+http:// is mapped to http://.
+func ParseWebDAVURL(rawurl string) (string, error) {
+	switch {
+	case strings.HasPrefix(rawurl, "webdav://"):
+		return "https://" + strings.TrimPrefix(rawurl, "webdav://"), nil
+	case strings.HasPrefix(rawurl, "webdav+http://"):
+		return "http://" + strings.TrimPrefix(rawurl, "webdav+http://"), nil
+	case strings.HasPrefix(rawurl, "webdav+https://"):
+		return "https://" + strings.TrimPrefix(rawurl, "webdav+https://"), nil
+	}
+	return "", fmt.Errorf("Invalid WebDAV URL %s: wants webdav://host/path", rawurl)
+}
+
+type multistatus struct {
+	Responses []struct {
+		Href     string `xml:"href"`
+		Propstat []struct {
+			Prop struct {
+				ResourceType struct {
+					Collection *struct{} `xml:"collection"`
+				} `xml:"resourcetype"`
+			} `xml:"prop"`
+			Status string `xml:"status"`
+		} `xml:"propstat"`
+	} `xml:"response"`
+}
+
+const propfindBody = `<?xml version="1.0" encoding="utf-8"?>
+<propfind xmlns="DAV:"><prop><resourcetype/></prop></propfind>`
+
+// List returns the files in the collection and its descendants.
+// If the URL is a file, it returns the file.
+func (d *WebDAV) List(ctx context.Context, rawurl string) ([]photos.UploadItem, error) {
+	root, err := url.Parse(rawurl)
+	if err != nil {
+		return nil, fmt.Errorf("Could not parse URL: %s", err)
+	}
+	items := make([]photos.UploadItem, 0)
+	visited := map[string]bool{}
+	queue := []*url.URL{root}
+	for len(queue) > 0 {
+		collection := queue[0]
+		queue = queue[1:]
+		if visited[collection.Path] {
+			continue
+		}
+		visited[collection.Path] = true
+		ms, err := d.propfind(ctx, collection)
+		if err != nil {
+			return nil, fmt.Errorf("Could not list %s: %s", collection, err)
+		}
+		for _, r := range ms.Responses {
+			u, err := collection.Parse(r.Href)
+			if err != nil {
+				return nil, fmt.Errorf("Invalid href %s in %s: %s", r.Href, collection, err)
+			}
+			isCollection := false
+			for _, ps := range r.Propstat {
+				if strings.Contains(ps.Status, " 200 ") && ps.Prop.ResourceType.Collection != nil {
+					isCollection = true
+				}
+			}
+			switch {
+			case strings.TrimSuffix(u.Path, "/") == strings.TrimSuffix(collection.Path, "/"):
+				if !isCollection {
+					item, err := d.newUploadItem(ctx, u)
+					if err != nil {
+						return nil, err
+					}
+					items = append(items, item)
+				}
+			case isCollection:
+				if !strings.HasSuffix(u.Path, "/") {
+					u.Path += "/"
+				}
+				queue = append(queue, u)
+			default:
+				item, err := d.newUploadItem(ctx, u)
+				if err != nil {
+					return nil, err
+				}
+				items = append(items, item)
+			}
+		}
+	}
+	return items, nil
+}
+
+func (d *WebDAV) newRequest(ctx context.Context, u *url.URL) (*http.Request, error) {
+	if d.NewRequest != nil {
+		return d.NewRequest(ctx, u.String())
+	}
+	r, err := http.NewRequest("GET", u.String(), nil)
+	if err != nil {
+		return nil, err
+	}
+	return r.WithContext(ctx), nil
+}
+
+func (d *WebDAV) newUploadItem(ctx context.Context, u *url.URL) (photos.UploadItem, error) {
+	r, err := d.newRequest(ctx, u)
+	if err != nil {
+		return nil, fmt.Errorf("Could not create a request for %s: %s", u, err)
+	}
+	return &photos.HTTPUploadItem{Client: d.Client, Request: r}, nil
+}
+
+func (d *WebDAV) propfind(ctx context.Context, u *url.URL) (*multistatus, error) {
+	req, err := d.newRequest(ctx, u)
+	if err != nil {
+		return nil, err
+	}
+	req.Method = "PROPFIND"
+	req.Header.Set("Depth", "1")
+	req.Header.Set("Content-Type", "application/xml; charset=utf-8")
+	req.Body = ioutil.NopCloser(strings.NewReader(propfindBody))
+	req.ContentLength = int64(len(propfindBody))
+	res, err := d.Client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+	if res.StatusCode != 207 {
+		b, _ := ioutil.ReadAll(io.LimitReader(res.Body, 1024))
+		return nil, fmt.Errorf("Got %s: %s", res.Status, string(b))
+	}
+	var ms multistatus
+	if err := xml.NewDecoder(res.Body).Decode(&ms); err != nil {
+		return nil, fmt.Errorf("Could not decode the response: %s", err)
+	}
+	return &ms, nil
+}
@@ -0,0 +1,98 @@
+package source
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"reflect"
+	"sort"
+	"testing"
+
+	"golang.org/x/net/webdav"
+)
+
+func newWebDAVServer(t *testing.T) *httptest.Server {
+	ctx := context.Background()
+	fs := webdav.NewMemFS()
+	for _, dir := range []string{"/photos", "/photos/2018"} {
+		if err := fs.Mkdir(ctx, dir, 0755); err != nil {
+			t.Fatalf("Could not create a directory: %s", err)
+		}
+	}
+	for _, name := range []string{"/photos/a.jpg", "/photos/2018/b c.jpg", "/other.jpg"} {
+		f, err := fs.OpenFile(ctx, name, os.O_RDWR|os.O_CREATE, 0644)
+		if err != nil {
+			t.Fatalf("Could not create a file: %s", err)
+		}
+		if _, err := f.Write([]byte("example")); err != nil {
+			t.Fatalf("Could not write to file: %s", err)
+		}
+		f.Close()
+	}
+	h := &webdav.Handler{FileSystem: fs, LockSystem: webdav.NewMemLS()}
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if _, pass, ok := r.BasicAuth(); !ok || pass != "secret" {
+			http.Error(w, "Unauthorized", 401)
+			return
+		}
+		h.ServeHTTP(w, r)
+	}))
+}
+
+func TestWebDAV_List(t *testing.T) {
+	s := newWebDAVServer(t)
+	defer s.Close()
+	d := WebDAV{
+		Client: s.Client(),
+		NewRequest: func(ctx context.Context, rawurl string) (*http.Request, error) {
+			r, err := http.NewRequest("GET", rawurl, nil)
+			if err != nil {
+				return nil, err
+			}
+			r.SetBasicAuth("alice", "secret")
+			return r.WithContext(ctx), nil
+		},
+	}
+	for _, c := range []struct {
+		path string
+		want []string
+	}{
+		{"/photos/", []string{"/photos/2018/b%20c.jpg", "/photos/a.jpg"}},
+		{"/photos/a.jpg", []string{"/photos/a.jpg"}},
+	} {
+		t.Run(c.path, func(t *testing.T) {
+			items, err := d.List(context.Background(), s.URL+c.path)
+			if err != nil {
+				t.Fatalf("List returns error: %s", err)
+			}
+			var got []string
+			for _, item := range items {
+				got = append(got, item.String())
+			}
+			sort.Strings(got)
+			want := make([]string, len(c.want))
+			for i, p := range c.want {
+				want[i] = s.URL + p
+			}
+			if !reflect.DeepEqual(want, got) {
+				t.Errorf("List wants %v but %v", want, got)
+			}
+		})
+	}
+}
+
+func TestParseWebDAVURL(t *testing.T) {
+	for in, want := range map[string]string{
+		"webdav://example.com/dav/":      "https://example.com/dav/",
+		"webdav+http://example.com/dav/": "http://example.com/dav/",
+	} {
+		got, err := ParseWebDAVURL(in)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if want != got {
+			t.Errorf("ParseWebDAVURL wants %s but %s", want, got)
+		}
+	}
+}